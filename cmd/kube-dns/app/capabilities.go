@@ -0,0 +1,100 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"k8s.io/dns/cmd/kube-dns/app/options"
+	"k8s.io/dns/pkg/version"
+)
+
+// Capabilities are the features enabled in a running kube-dns, served as
+// JSON on /capabilities for fleet tooling. They must not include secrets,
+// as the endpoint is not authenticated.
+type Capabilities struct {
+	// Version of the kube-dns build.
+	Version string `json:"version"`
+
+	ConfigSource          string `json:"configSource"`
+	Federations           bool   `json:"federations"`
+	Chaos                 bool   `json:"chaos"`
+	UpstreamProtocol      string `json:"upstreamProtocol"`
+	UpstreamDenyNetworks  bool   `json:"upstreamDenyNetworks"`
+	NoUpstream            bool   `json:"noUpstream"`
+	MinimalAny            bool   `json:"minimalAny"`
+	MinimalResponses      bool   `json:"minimalResponses"`
+	AnswerOrder           string `json:"answerOrder"`
+	ZoneTransfers         bool   `json:"zoneTransfers"`
+	ResponseRateLimiting  bool   `json:"responseRateLimiting"`
+	Cookies               bool   `json:"cookies"`
+	CookieEnforcement     bool   `json:"cookieEnforcement"`
+	Watchdog              bool   `json:"watchdog"`
+	SelfCheck             bool   `json:"selfCheck"`
+	EmptyEndpointResponse string `json:"emptyEndpointResponse"`
+	BootstrapKubernetesIP bool   `json:"bootstrapKubernetesIP"`
+	TCPFastOpen           bool   `json:"tcpFastOpen"`
+	DebugEndpoints        bool   `json:"debugEndpoints"`
+}
+
+// newCapabilities returns the Capabilities of kube-dns started with
+// config.
+func newCapabilities(config *options.KubeDNSConfig) Capabilities {
+	c := Capabilities{
+		Version:               version.VERSION,
+		ConfigSource:          "flags",
+		Federations:           len(config.Federations) > 0,
+		Chaos:                 config.Chaos,
+		UpstreamProtocol:      string(config.UpstreamProtocol),
+		UpstreamDenyNetworks:  len(config.UpstreamDenyNetworks) > 0,
+		NoUpstream:            config.NoUpstream,
+		MinimalAny:            config.MinimalAny,
+		MinimalResponses:      config.MinimalResponses,
+		AnswerOrder:           string(config.AnswerOrder),
+		ZoneTransfers:         len(config.TransferAllowNetworks) > 0,
+		ResponseRateLimiting:  config.RRLResponsesPerSecond > 0,
+		Cookies:               config.CookieSecretFile != "",
+		CookieEnforcement:     config.CookieEnforceSize > 0,
+		Watchdog:              config.WatchdogInterval > 0,
+		SelfCheck:             config.SelfCheckInterval > 0,
+		EmptyEndpointResponse: string(config.EmptyEndpointResponse),
+		BootstrapKubernetesIP: config.BootstrapKubernetesIP != "",
+		TCPFastOpen:           config.TCPFastOpenQueue > 0,
+		DebugEndpoints:        config.DebugEndpoints,
+	}
+	switch {
+	case config.ConfigMap != "":
+		c.ConfigSource = "configmap"
+	case config.ConfigDir != "":
+		c.ConfigSource = "directory"
+	}
+	return c
+}
+
+// capabilitiesHandler serves c as JSON to GET requests.
+func capabilitiesHandler(c Capabilities) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
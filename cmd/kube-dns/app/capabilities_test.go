@@ -0,0 +1,89 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/dns/cmd/kube-dns/app/options"
+)
+
+// getCapabilities returns the capabilities served for kube-dns started
+// with args.
+func getCapabilities(t *testing.T, args []string) (Capabilities, string) {
+	config := options.NewKubeDNSConfig()
+	fs := pflag.NewFlagSet("kube-dns", pflag.ContinueOnError)
+	config.AddFlags(fs)
+	require.NoError(t, fs.Parse(args))
+
+	server := httptest.NewServer(capabilitiesHandler(newCapabilities(config)))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var body json.RawMessage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	var c Capabilities
+	require.NoError(t, json.Unmarshal(body, &c))
+	return c, string(body)
+}
+
+func TestCapabilities(t *testing.T) {
+	c, _ := getCapabilities(t, nil)
+	assert.False(t, c.MinimalAny)
+	assert.False(t, c.NoUpstream)
+	assert.False(t, c.Cookies)
+	assert.Equal(t, "flags", c.ConfigSource)
+	assert.Equal(t, "stable", c.AnswerOrder)
+	assert.NotEmpty(t, c.Version)
+
+	c, body := getCapabilities(t, []string{
+		"--minimal-any",
+		"--no-upstream",
+		"--cookie-secret-file=/etc/kube-dns/cookie-secret",
+		"--config-map=kube-dns",
+		"--answer-order=sorted",
+		"--transfer-allow-cidrs=10.0.0.0/8",
+	})
+	assert.True(t, c.MinimalAny)
+	assert.True(t, c.NoUpstream)
+	assert.True(t, c.Cookies)
+	assert.Equal(t, "configmap", c.ConfigSource)
+	assert.Equal(t, "sorted", c.AnswerOrder)
+	assert.True(t, c.ZoneTransfers)
+	// Paths and secrets are not exposed.
+	assert.False(t, strings.Contains(body, "cookie-secret"), body)
+
+	// The endpoint is read-only.
+	server := httptest.NewServer(capabilitiesHandler(c))
+	defer server.Close()
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/dns/cmd/kube-dns/app/options"
+	dnsconfig "k8s.io/dns/pkg/dns/config"
+)
+
+// DumpConfig writes the configuration kube-dns would start with as JSON
+// to w: the ConfigMap or config directory, with the values from the
+// environment where they are not set. It returns an error if the
+// configuration is invalid. As at startup, the defaults are used if the
+// configuration cannot be read.
+func DumpConfig(config *options.KubeDNSConfig, w io.Writer) error {
+	var kubeClient kubernetes.Interface
+	if config.ConfigMap != "" {
+		var err error
+		if kubeClient, err = newKubeClient(config); err != nil {
+			return fmt.Errorf("failed to create a kubernetes client: %v", err)
+		}
+	}
+	configSync, err := newConfigSync(config, kubeClient)
+	if err != nil {
+		return err
+	}
+
+	effective, err := configSync.Once()
+	if _, invalid := err.(*dnsconfig.InvalidConfigError); invalid {
+		return err
+	}
+	if err != nil {
+		glog.Errorf("Error getting the configuration: %v, using default values", err)
+		effective = dnsconfig.NewDefaultConfig()
+	}
+
+	out, err := json.MarshalIndent(effective, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/dns/cmd/kube-dns/app/options"
+	dnsconfig "k8s.io/dns/pkg/dns/config"
+)
+
+// dumpConfig runs DumpConfig with args and the environment env.
+func dumpConfig(t *testing.T, args []string, env map[string]string) (*dnsconfig.Config, error) {
+	config := options.NewKubeDNSConfig()
+	fs := pflag.NewFlagSet("kube-dns", pflag.ContinueOnError)
+	config.AddFlags(fs)
+	require.NoError(t, fs.Parse(args))
+	require.NoError(t, config.ReadEnv(fs, func(name string) string { return env[name] }))
+
+	var out bytes.Buffer
+	if err := DumpConfig(config, &out); err != nil {
+		return nil, err
+	}
+	dumped := &dnsconfig.Config{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), dumped), out.String())
+	return dumped, nil
+}
+
+func TestDumpConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-dns-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	env := map[string]string{dnsconfig.EnvUpstreamNameservers: "10.0.0.53"}
+
+	// The environment overrides the default (no upstream nameservers).
+	dumped, err := dumpConfig(t, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, dumped.UpstreamNameservers)
+	dumped, err = dumpConfig(t, nil, env)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.53"}, dumped.UpstreamNameservers)
+
+	// The environment overrides the configuration directory, and the
+	// command line overrides both.
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "upstreamNameservers"), []byte(`["10.0.0.54"]`), 0644))
+	dumped, err = dumpConfig(t, []string{"--config-dir=" + dir}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.54"}, dumped.UpstreamNameservers)
+	dumped, err = dumpConfig(t, []string{"--config-dir=" + dir}, env)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.53"}, dumped.UpstreamNameservers)
+	dumped, err = dumpConfig(t, []string{"--config-dir=" + dir, "--nameservers=10.0.0.55"}, env)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.55"}, dumped.UpstreamNameservers)
+
+	// Invalid configurations are an error.
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "upstreamNameservers"), []byte(`["not an address"]`), 0644))
+	_, err = dumpConfig(t, []string{"--config-dir=" + dir}, nil)
+	assert.Error(t, err)
+}
+
+func TestDumpConfigNameserversLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-dns-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// More --nameservers than the default limit are accepted.
+	nameservers := []string{"10.0.0.53", "10.0.0.54", "10.0.0.55", "10.0.0.56"}
+	dumped, err := dumpConfig(t, []string{"--nameservers=" + strings.Join(nameservers, ",")}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, nameservers, dumped.UpstreamNameservers)
+
+	// The environment is checked against --max-upstream-nameservers.
+	env := map[string]string{dnsconfig.EnvUpstreamNameservers: strings.Join(nameservers, ",")}
+	dumped, err = dumpConfig(t, []string{"--max-upstream-nameservers=5"}, env)
+	require.NoError(t, err)
+	assert.Equal(t, nameservers, dumped.UpstreamNameservers)
+
+	// The limit still applies to the configuration directory.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "upstreamNameservers"),
+		[]byte(`["10.0.0.53", "10.0.0.54", "10.0.0.55", "10.0.0.56"]`), 0644))
+	_, err = dumpConfig(t, []string{"--config-dir=" + dir}, nil)
+	assert.Error(t, err)
+}
@@ -19,6 +19,7 @@ package options
 
 import (
 	"fmt"
+	"net"
 	_ "net/http/pprof"
 	"net/url"
 	"os"
@@ -27,7 +28,11 @@ import (
 
 	"github.com/spf13/pflag"
 
+	kdns "k8s.io/dns/pkg/dns"
+	dnsconfig "k8s.io/dns/pkg/dns/config"
 	fed "k8s.io/dns/pkg/dns/federation"
+	kdserver "k8s.io/dns/pkg/dns/server"
+	"k8s.io/dns/pkg/util"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/util/validation"
 )
@@ -37,42 +42,184 @@ type KubeDNSConfig struct {
 	KubeConfigFile     string
 	KubeMasterURL      string
 	InitialSyncTimeout time.Duration
+	ReadyTimeout       time.Duration
 
 	HealthzPort    int
 	DNSBindAddress string
 	DNSPort        int
 
+	TCPReuseAddr       bool
+	TCPKeepAlivePeriod time.Duration
+	TCPFastOpenQueue   int
+
 	Federations map[string]string
 
-	ConfigMapNs string
-	ConfigMap   string
+	ConfigMapNs           string
+	ConfigMap             string
+	ConfigMapWatchBackoff dnsconfig.WatchBackoff
 
 	ConfigDir    string
 	ConfigPeriod time.Duration
+	ConfigStrict bool
 
 	NameServers string
+
+	NamespaceMetricsLimit int
+	Chaos                 bool
+	HandlerWorkers        int
+	MaxConcurrentUpstream int
+	UpstreamDeadline      time.Duration
+	UpstreamProtocol      kdserver.UpstreamProtocol
+	UpstreamDenyNetworks  []*net.IPNet
+	NoUpstream            bool
+	NoUpstreamResponse    kdserver.NoUpstreamResponse
+	MinimalAny            bool
+	MinimalResponses      bool
+	AnswerOrder           kdserver.AnswerOrder
+	TransferAllowNetworks []*net.IPNet
+
+	RRLResponsesPerSecond int
+	RRLSlip               int
+
+	CookieSecretFile  string
+	CookieEnforceSize int
+
+	WatchdogInterval time.Duration
+	WatchdogExit     bool
+
+	SelfCheckName     string
+	SelfCheckInterval time.Duration
+	SelfCheckFailures int
+
+	SOA kdserver.SOAOptions
+
+	EmptyEndpointResponse kdns.EmptyEndpointResponse
+	HeadlessEmptyGrace    time.Duration
+	APIDisconnectGrace    time.Duration
+	MaxRecords            int
+	BootstrapKubernetesIP string
+
+	LogFormat string
+
+	DebugEndpoints bool
+
+	// ConfigLimits on the dynamic configuration, applied by ReadEnv().
+	ConfigLimits dnsconfig.Limits
+
+	// EnvConfig is the configuration read from the environment by
+	// ReadEnv(). Its values take precedence over the ConfigMap and are
+	// overridden by the command line.
+	EnvConfig *dnsconfig.Config
 }
 
+// EnvClusterDomain sets the cluster domain if --domain is not given.
+const EnvClusterDomain = "KUBEDNS_CLUSTER_DOMAIN"
+
+// EnvKubernetesServiceHost is set by the kubelet to the ClusterIP of the
+// kubernetes service. It is used with --bootstrap-kubernetes-ip=auto.
+const EnvKubernetesServiceHost = "KUBERNETES_SERVICE_HOST"
+
+// bootstrapKubernetesIPAuto makes ReadEnv() discover the
+// --bootstrap-kubernetes-ip from the environment.
+const bootstrapKubernetesIPAuto = "auto"
+
 func NewKubeDNSConfig() *KubeDNSConfig {
 	return &KubeDNSConfig{
 		ClusterDomain:      "cluster.local.",
 		HealthzPort:        8081,
 		DNSBindAddress:     "0.0.0.0",
 		DNSPort:            53,
+		TCPReuseAddr:       true,
 		InitialSyncTimeout: 60 * time.Second,
 
 		Federations: make(map[string]string),
 
-		ConfigMapNs: api.NamespaceSystem,
-		ConfigMap:   "", // default to using command line flags
+		ConfigMapNs:           api.NamespaceSystem,
+		ConfigMap:             "", // default to using command line flags
+		ConfigMapWatchBackoff: dnsconfig.DefaultWatchBackoff(),
 
 		ConfigPeriod: 10 * time.Second,
 		ConfigDir:    "",
 
 		NameServers: "",
+
+		NamespaceMetricsLimit: 100,
+		Chaos:                 true,
+		HandlerWorkers:        kdserver.DefaultHandlerWorkers(),
+		MaxConcurrentUpstream: 1000,
+		UpstreamDeadline:      5 * time.Second,
+		UpstreamProtocol:      kdserver.UpstreamProtocolUDP,
+		NoUpstreamResponse:    kdserver.NoUpstreamNXDomain,
+		AnswerOrder:           kdserver.AnswerOrderStable,
+
+		RRLSlip: 2,
+
+		WatchdogInterval: 30 * time.Second,
+
+		SelfCheckInterval: 10 * time.Second,
+
+		EmptyEndpointResponse: kdns.EmptyEndpointNXDomain,
+		APIDisconnectGrace:    10 * time.Second,
+
+		LogFormat: util.LogFormatText,
+
+		ConfigLimits: dnsconfig.DefaultLimits(),
 	}
 }
 
+// ReadEnv reads the configuration from the environment using getenv
+// (e.g. os.Getenv). Values given on the command line take precedence; fs
+// must have been parsed. The ConfigLimits are applied first, as the
+// environment, and later the ConfigMap, are validated against them.
+func (s *KubeDNSConfig) ReadEnv(fs *pflag.FlagSet, getenv func(string) string) error {
+	if err := s.applyLimits(fs); err != nil {
+		return err
+	}
+
+	if domain := getenv(EnvClusterDomain); domain != "" && !fs.Changed("domain") {
+		if err := (clusterDomainVar{&s.ClusterDomain}).Set(domain); err != nil {
+			return fmt.Errorf("invalid %v: %v", EnvClusterDomain, err)
+		}
+	}
+
+	if s.BootstrapKubernetesIP == bootstrapKubernetesIPAuto {
+		host := getenv(EnvKubernetesServiceHost)
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("--bootstrap-kubernetes-ip=%v requires %v to be an IP address, got %q",
+				bootstrapKubernetesIPAuto, EnvKubernetesServiceHost, host)
+		}
+		s.BootstrapKubernetesIP = host
+	}
+
+	envConfig, err := dnsconfig.ReadEnv(getenv)
+	if err != nil {
+		return err
+	}
+	if fs.Changed("nameservers") {
+		envConfig.UpstreamNameservers = nil
+	}
+	s.EnvConfig = envConfig
+	return nil
+}
+
+// applyLimits sets the limits the configuration is validated against to
+// ConfigLimits. Without --max-upstream-nameservers, the limit is raised to
+// the number of --nameservers so that a command line that was valid before
+// the limit existed keeps working; with it, more --nameservers are an error.
+func (s *KubeDNSConfig) applyLimits(fs *pflag.FlagSet) error {
+	if max := s.ConfigLimits.MaxUpstreamNameservers; s.NameServers != "" && max > 0 {
+		if n := len(strings.Split(s.NameServers, ",")); n > max {
+			if fs.Changed("max-upstream-nameservers") {
+				return fmt.Errorf("--nameservers has %v nameservers, more than --max-upstream-nameservers=%v",
+					n, max)
+			}
+			s.ConfigLimits.MaxUpstreamNameservers = n
+		}
+	}
+	dnsconfig.SetLimits(s.ConfigLimits)
+	return nil
+}
+
 type clusterDomainVar struct {
 	val *string
 }
@@ -124,6 +271,138 @@ func (m kubeMasterURLVar) Type() string {
 	return "string"
 }
 
+type emptyEndpointResponseVar struct {
+	val *kdns.EmptyEndpointResponse
+}
+
+func (m emptyEndpointResponseVar) Set(v string) error {
+	if err := kdns.ValidateEmptyEndpointResponse(v); err != nil {
+		return err
+	}
+	*m.val = kdns.EmptyEndpointResponse(v)
+	return nil
+}
+
+func (m emptyEndpointResponseVar) String() string {
+	return string(*m.val)
+}
+
+func (m emptyEndpointResponseVar) Type() string {
+	return "string"
+}
+
+type bootstrapKubernetesIPVar struct {
+	val *string
+}
+
+func (m bootstrapKubernetesIPVar) Set(v string) error {
+	if v != "" && v != bootstrapKubernetesIPAuto && net.ParseIP(v) == nil {
+		return fmt.Errorf("%q is not an IP address or %q", v, bootstrapKubernetesIPAuto)
+	}
+	*m.val = v
+	return nil
+}
+
+func (m bootstrapKubernetesIPVar) String() string {
+	return *m.val
+}
+
+func (m bootstrapKubernetesIPVar) Type() string {
+	return "string"
+}
+
+type answerOrderVar struct {
+	val *kdserver.AnswerOrder
+}
+
+func (m answerOrderVar) Set(v string) error {
+	if err := kdserver.ValidateAnswerOrder(v); err != nil {
+		return err
+	}
+	*m.val = kdserver.AnswerOrder(v)
+	return nil
+}
+
+func (m answerOrderVar) String() string {
+	return string(*m.val)
+}
+
+func (m answerOrderVar) Type() string {
+	return "string"
+}
+
+type upstreamProtocolVar struct {
+	val *kdserver.UpstreamProtocol
+}
+
+func (m upstreamProtocolVar) Set(v string) error {
+	if err := kdserver.ValidateUpstreamProtocol(v); err != nil {
+		return err
+	}
+	*m.val = kdserver.UpstreamProtocol(v)
+	return nil
+}
+
+func (m upstreamProtocolVar) String() string {
+	return string(*m.val)
+}
+
+func (m upstreamProtocolVar) Type() string {
+	return "string"
+}
+
+type networksVar struct {
+	val *[]*net.IPNet
+}
+
+func (m networksVar) Set(v string) error {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(v, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, network)
+	}
+	*m.val = networks
+	return nil
+}
+
+func (m networksVar) String() string {
+	var cidrs []string
+	for _, network := range *m.val {
+		cidrs = append(cidrs, network.String())
+	}
+	return strings.Join(cidrs, ",")
+}
+
+func (m networksVar) Type() string {
+	return "string"
+}
+
+type noUpstreamResponseVar struct {
+	val *kdserver.NoUpstreamResponse
+}
+
+func (m noUpstreamResponseVar) Set(v string) error {
+	if err := kdserver.ValidateNoUpstreamResponse(v); err != nil {
+		return err
+	}
+	*m.val = kdserver.NoUpstreamResponse(v)
+	return nil
+}
+
+func (m noUpstreamResponseVar) String() string {
+	return string(*m.val)
+}
+
+func (m noUpstreamResponseVar) Type() string {
+	return "string"
+}
+
 type federationsVar struct {
 	nameDomainMap map[string]string
 }
@@ -165,6 +444,16 @@ func (s *KubeDNSConfig) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&s.DNSBindAddress, "dns-bind-address", s.DNSBindAddress,
 		"address on which to serve DNS requests.")
 	fs.IntVar(&s.DNSPort, "dns-port", s.DNSPort, "port on which to serve DNS requests.")
+	fs.BoolVar(&s.TCPReuseAddr, "tcp-reuse-addr", s.TCPReuseAddr,
+		"set SO_REUSEADDR on the DNS TCP listener, so that it can be bound"+
+			" while connections of a previous instance are in TIME_WAIT.")
+	fs.DurationVar(&s.TCPKeepAlivePeriod, "tcp-keepalive-period", s.TCPKeepAlivePeriod,
+		"period of the TCP keepalives on DNS TCP connections. 0 disables"+
+			" keepalives.")
+	fs.IntVar(&s.TCPFastOpenQueue, "tcp-fast-open-queue", s.TCPFastOpenQueue,
+		"maximum number of pending TCP Fast Open requests on the DNS TCP"+
+			" listener. 0 disables TCP Fast Open, which is only supported on"+
+			" Linux.")
 
 	fs.Var(federationsVar{s.Federations}, "federations",
 		"a comma separated list of the federation names and their corresponding"+
@@ -179,12 +468,174 @@ func (s *KubeDNSConfig) AddFlags(fs *pflag.FlagSet) {
 		"config-map name. If empty, then the config-map will not used. Cannot be "+
 			"used in conjunction with federations or config-dir flag. config-map contains "+
 			"dynamically adjustable configuration.")
+	fs.DurationVar(&s.ConfigMapWatchBackoff.Initial, "config-map-watch-backoff-initial",
+		s.ConfigMapWatchBackoff.Initial,
+		"initial delay before reconnecting the config-map watch when it did not"+
+			" last for --config-map-watch-backoff-reset. It doubles on each such"+
+			" reconnection. 0 disables the backoff.")
+	fs.DurationVar(&s.ConfigMapWatchBackoff.Max, "config-map-watch-backoff-max",
+		s.ConfigMapWatchBackoff.Max,
+		"maximum delay before reconnecting the config-map watch.")
+	fs.DurationVar(&s.ConfigMapWatchBackoff.StablePeriod, "config-map-watch-backoff-reset",
+		s.ConfigMapWatchBackoff.StablePeriod,
+		"time after which a config-map watch is stable and the reconnection"+
+			" delay is reset.")
 	fs.DurationVar(&s.InitialSyncTimeout, "initial-sync-timeout", s.InitialSyncTimeout,
 		"Timeout for initial resource sync.")
+	fs.DurationVar(&s.ReadyTimeout, "ready-timeout", s.ReadyTimeout,
+		"time after which /readiness reports ready with a warning even if the"+
+			" initial resource sync is not complete. 0 means /readiness waits"+
+			" for the sync.")
 
 	fs.StringVar(&s.ConfigDir, "config-dir", s.ConfigDir,
 		"directory to read config values from. Cannot be "+
 			"used in conjunction with federations or config-map flag.")
 	fs.DurationVar(&s.ConfigPeriod, "config-period", s.ConfigPeriod,
 		"period at which to check for updates in config-dir.")
+	fs.BoolVar(&s.ConfigStrict, "config-strict", s.ConfigStrict,
+		"exit at startup if the initial configuration (from config-map or"+
+			" config-dir) is invalid, instead of starting with the defaults."+
+			" Invalid updates are ignored either way.")
+
+	fs.IntVar(&s.NamespaceMetricsLimit, "namespace-metrics-limit", s.NamespaceMetricsLimit,
+		"maximum number of namespaces to export individual query metrics for."+
+			" Queries for other namespaces are counted under the \"other\" label.")
+
+	fs.BoolVar(&s.Chaos, "chaos", s.Chaos,
+		"answer CHAOS class queries (e.g. version.bind). Queries of the"+
+			" classes other than IN, and CHAOS if disabled, are refused.")
+
+	fs.IntVar(&s.HandlerWorkers, "handler-workers", s.HandlerWorkers,
+		"number of workers processing queries, for each of TCP and UDP.")
+
+	fs.IntVar(&s.MaxConcurrentUpstream, "max-concurrent-upstream", s.MaxConcurrentUpstream,
+		"maximum number of queries in flight to upstream nameservers. Queries"+
+			" beyond the limit are answered with REFUSED. 0 means no limit.")
+	fs.DurationVar(&s.UpstreamDeadline, "upstream-deadline", s.UpstreamDeadline,
+		"maximum time spent forwarding a query upstream, across nameservers"+
+			" and retries, after which it is answered with SERVFAIL. Set it to"+
+			" the client resolver timeout (5s for glibc). 0 means no deadline.")
+	fs.Var(upstreamProtocolVar{&s.UpstreamProtocol}, "upstream-protocol",
+		"transport used to forward queries upstream: \"udp\" uses the transport"+
+			" the query was received on, \"tcp\" always uses TCP and"+
+			" \"prefer-tcp\" uses TCP, falling back to UDP if the TCP exchange"+
+			" with a nameserver fails.")
+	fs.Var(networksVar{&s.UpstreamDenyNetworks}, "upstream-deny-cidrs",
+		"comma separated list of networks (e.g. 127.0.0.0/8,169.254.0.0/16) that"+
+			" addresses in forwarded answers must not be in. Responses from upstream"+
+			" nameservers and stub domains with such addresses are answered with"+
+			" SERVFAIL and counted in kubedns_upstream_rejected_answers_total.")
+	fs.BoolVar(&s.NoUpstream, "no-upstream", s.NoUpstream,
+		"disable forwarding to the upstream nameservers, e.g. in isolated"+
+			" clusters. Queries for names outside of the cluster domain and stub"+
+			" domains are answered right away with --no-upstream-response.")
+	fs.Var(noUpstreamResponseVar{&s.NoUpstreamResponse}, "no-upstream-response",
+		"response to queries for external names with --no-upstream, one of"+
+			" \"nxdomain\" or \"refused\".")
+	fs.BoolVar(&s.MinimalAny, "minimal-any", s.MinimalAny,
+		"answer ANY queries for names in the cluster domain with a single"+
+			" synthesized HINFO record (RFC 8482) rather than refusing them.")
+	fs.BoolVar(&s.MinimalResponses, "minimal-responses", s.MinimalResponses,
+		"leave the authority section and the additional records (such as the"+
+			" glue of SRV targets) out of positive responses, for clients that"+
+			" only handle the answer section. Negative responses keep their SOA.")
+	fs.Var(networksVar{&s.TransferAllowNetworks}, "transfer-allow-cidrs",
+		"comma separated list of networks of the clients allowed to transfer"+
+			" (AXFR over TCP) the cluster domain and the reverse zones, e.g. to"+
+			" migrate to another DNS server. Zone transfers are disabled if empty.")
+
+	fs.Var(answerOrderVar{&s.AnswerOrder}, "answer-order",
+		"order of the records with the same name and type in answers: \"stable\""+
+			" keeps the order of the record source, \"sorted\" sorts them and"+
+			" \"roundrobin\" rotates them on every query.")
+
+	fs.StringVar(&s.SOA.MName, "soa-mname", s.SOA.MName,
+		"primary nameserver in the SOA of the cluster domain. Defaults to ns.dns.<domain>.")
+	fs.StringVar(&s.SOA.RName, "soa-rname", s.SOA.RName,
+		"responsible mailbox in the SOA of the cluster domain. Defaults to hostmaster.<domain>.")
+	fs.DurationVar(&s.SOA.Refresh, "soa-refresh", s.SOA.Refresh,
+		"refresh interval in the SOA of the cluster domain. Defaults to 8h.")
+	fs.DurationVar(&s.SOA.Retry, "soa-retry", s.SOA.Retry,
+		"retry interval in the SOA of the cluster domain. Defaults to 2h.")
+	fs.DurationVar(&s.SOA.Expire, "soa-expire", s.SOA.Expire,
+		"expire time in the SOA of the cluster domain. Defaults to 168h.")
+	fs.DurationVar(&s.SOA.Minimum, "soa-minimum", s.SOA.Minimum,
+		"minimum TTL in the SOA of the cluster domain, used by resolvers to"+
+			" cache negative answers. Defaults to 60s.")
+	fs.StringSliceVar(&s.SOA.NSNames, "ns-names", s.SOA.NSNames,
+		"comma-separated nameservers in the NS records at the apex of the"+
+			" cluster domain. Defaults to the SOA primary nameserver.")
+
+	fs.IntVar(&s.RRLResponsesPerSecond, "rrl-responses-per-second", s.RRLResponsesPerSecond,
+		"maximum rate of identical UDP responses (same name, type and rcode)"+
+			" sent to a client subnet (/24 for IPv4, /56 for IPv6), to mitigate"+
+			" amplification attacks. 0 disables response rate limiting.")
+	fs.IntVar(&s.RRLSlip, "rrl-slip", s.RRLSlip,
+		"send every Nth rate limited response as an empty truncated response,"+
+			" so that legitimate clients retry over TCP, and drop the others."+
+			" 0 drops all rate limited responses.")
+
+	fs.StringVar(&s.CookieSecretFile, "cookie-secret-file", s.CookieSecretFile,
+		"file holding the secret server DNS cookies (RFC 7873) are derived"+
+			" from. If empty, the client cookies are echoed without a server cookie.")
+	fs.IntVar(&s.CookieEnforceSize, "cookie-enforce-size", s.CookieEnforceSize,
+		"size in bytes above which UDP responses to clients without a valid"+
+			" server cookie are truncated, so that they retry over TCP. Requires"+
+			" --cookie-secret-file. 0 disables the enforcement.")
+
+	fs.DurationVar(&s.WatchdogInterval, "watchdog-interval", s.WatchdogInterval,
+		"time after which DNS processing is considered stalled if queries are"+
+			" in progress but none has completed. 0 disables the watchdog.")
+	fs.BoolVar(&s.WatchdogExit, "watchdog-exit", s.WatchdogExit,
+		"exit when the watchdog detects a stall, so that the pod is restarted.")
+
+	fs.StringVar(&s.SelfCheckName, "self-check-name", s.SelfCheckName,
+		"name resolved periodically through kube-dns to check that it answers"+
+			" queries. Defaults to kubernetes.default.svc.<domain>.")
+	fs.DurationVar(&s.SelfCheckInterval, "self-check-interval", s.SelfCheckInterval,
+		"interval between self check queries, whose result is exported as"+
+			" kubedns_self_resolve_ok. 0 disables the self check.")
+	fs.IntVar(&s.SelfCheckFailures, "self-check-failures", s.SelfCheckFailures,
+		"number of consecutive failed self checks after which /healthz on the"+
+			" status port fails. 0 means /healthz does not depend on the self check.")
+
+	fs.Var(emptyEndpointResponseVar{&s.EmptyEndpointResponse}, "empty-endpoint-response",
+		"response to queries for a headless service without ready endpoints,"+
+			" one of \"nxdomain\" or \"nodata\". Resolvers may cache an NXDOMAIN"+
+			" for the names below the service as well, a NODATA only for the"+
+			" queried name and type. Services with a ClusterIP are not affected.")
+	fs.DurationVar(&s.HeadlessEmptyGrace, "headless-empty-grace", s.HeadlessEmptyGrace,
+		"how long a headless service that loses all of its ready endpoints keeps"+
+			" resolving to its previous endpoints, e.g. during a rollout, before"+
+			" --empty-endpoint-response applies. 0 drops the records immediately.")
+	fs.DurationVar(&s.APIDisconnectGrace, "api-disconnect-grace", s.APIDisconnectGrace,
+		"how long the list and watch calls to the API server have to fail before"+
+			" kubedns_api_connected drops to 0. The last known records are served"+
+			" while disconnected, with kubedns_serving_stale set to 1.")
+
+	fs.IntVar(&s.MaxRecords, "max-records", s.MaxRecords,
+		"maximum number of records to hold in memory. Once reached, records of"+
+			" new or growing services are not added and"+
+			" kubedns_record_limit_exceeded_total is incremented. 0 means no limit.")
+	fs.Var(bootstrapKubernetesIPVar{&s.BootstrapKubernetesIP}, "bootstrap-kubernetes-ip",
+		"ClusterIP to answer for kubernetes.default.svc until the kubernetes"+
+			" service is synced from the API server, so that pods can resolve it"+
+			" during startup. \"auto\" uses $KUBERNETES_SERVICE_HOST. Empty disables"+
+			" the bootstrap record.")
+
+	fs.IntVar(&s.ConfigLimits.MaxStubDomains, "max-stub-domains", s.ConfigLimits.MaxStubDomains,
+		"maximum number of stub domains in the configuration. A configuration"+
+			" with more is rejected. 0 means no limit.")
+	fs.IntVar(&s.ConfigLimits.MaxUpstreamNameservers, "max-upstream-nameservers",
+		s.ConfigLimits.MaxUpstreamNameservers,
+		"maximum number of upstream nameservers, and of fallback upstream"+
+			" nameservers, in the configuration. A configuration with more is"+
+			" rejected. 0 means no limit. If not set, it is raised to the number"+
+			" of --nameservers if needed.")
+
+	fs.StringVar(&s.LogFormat, "log-format", s.LogFormat,
+		"format of the log output, one of \"text\" or \"json\".")
+
+	fs.BoolVar(&s.DebugEndpoints, "debug-endpoints", s.DebugEndpoints,
+		"serve debugging endpoints under /admin/ on the status port.")
 }
@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dnsconfig "k8s.io/dns/pkg/dns/config"
+)
+
+func newTestConfig(t *testing.T, args []string, env map[string]string) (*KubeDNSConfig, error) {
+	config := NewKubeDNSConfig()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	config.AddFlags(fs)
+	require.NoError(t, fs.Parse(args))
+	err := config.ReadEnv(fs, func(key string) string { return env[key] })
+	return config, err
+}
+
+func TestReadEnv(t *testing.T) {
+	env := map[string]string{
+		EnvClusterDomain:                 "example.org",
+		dnsconfig.EnvUpstreamNameservers: "8.8.8.8",
+		dnsconfig.EnvStubDomains:         `{"acme.local": ["1.2.3.4"]}`,
+	}
+
+	// Environment only.
+	config, err := newTestConfig(t, nil, env)
+	require.NoError(t, err)
+	assert.Equal(t, "example.org.", config.ClusterDomain)
+	assert.Equal(t, []string{"8.8.8.8"}, config.EnvConfig.UpstreamNameservers)
+	assert.Equal(t, map[string][]string{"acme.local": {"1.2.3.4"}}, config.EnvConfig.StubDomains)
+
+	// Flags take precedence.
+	config, err = newTestConfig(t, []string{"--domain=cluster.test", "--nameservers=9.9.9.9"}, env)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster.test.", config.ClusterDomain)
+	assert.Equal(t, "9.9.9.9", config.NameServers)
+	assert.Nil(t, config.EnvConfig.UpstreamNameservers)
+	assert.Equal(t, map[string][]string{"acme.local": {"1.2.3.4"}}, config.EnvConfig.StubDomains)
+
+	// Values are validated as for the flags and ConfigMap.
+	for _, invalid := range []map[string]string{
+		{EnvClusterDomain: "not_a.domain"},
+		{dnsconfig.EnvUpstreamNameservers: "not-an-ip"},
+		{dnsconfig.EnvStubDomains: `{"acme.local": "1.2.3.4"}`},
+	} {
+		_, err := newTestConfig(t, nil, invalid)
+		assert.Error(t, err, "%v", invalid)
+	}
+}
+
+func TestReadEnvLimits(t *testing.T) {
+	defer dnsconfig.SetLimits(dnsconfig.DefaultLimits())
+	nameservers := "8.8.8.8,8.8.4.4,1.1.1.1,9.9.9.9"
+	env := map[string]string{
+		dnsconfig.EnvUpstreamNameservers: nameservers,
+		dnsconfig.EnvStubDomains:         `{"a.local": ["1.2.3.4"], "b.local": ["1.2.3.4"]}`,
+	}
+
+	// The environment is validated against the limits from the flags.
+	_, err := newTestConfig(t, nil, env)
+	assert.Error(t, err)
+	config, err := newTestConfig(t, []string{"--max-upstream-nameservers=5"}, env)
+	require.NoError(t, err)
+	assert.Len(t, config.EnvConfig.UpstreamNameservers, 4)
+	_, err = newTestConfig(t, []string{"--max-upstream-nameservers=0"}, env)
+	assert.NoError(t, err)
+	_, err = newTestConfig(t, []string{"--max-upstream-nameservers=0", "--max-stub-domains=1"}, env)
+	assert.Error(t, err)
+
+	// Without --max-upstream-nameservers, the limit is raised to the
+	// number of --nameservers. With it, more --nameservers are an error.
+	config, err = newTestConfig(t, []string{"--nameservers=" + nameservers}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, config.ConfigLimits.MaxUpstreamNameservers)
+	_, err = newTestConfig(t, []string{"--nameservers=" + nameservers, "--max-upstream-nameservers=3"}, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "--max-upstream-nameservers=3")
+	}
+}
+
+func TestBootstrapKubernetesIP(t *testing.T) {
+	env := map[string]string{EnvKubernetesServiceHost: "10.0.0.1"}
+
+	config, err := newTestConfig(t, []string{"--bootstrap-kubernetes-ip=10.0.0.2"}, env)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", config.BootstrapKubernetesIP)
+
+	// auto discovers the IP from the environment.
+	config, err = newTestConfig(t, []string{"--bootstrap-kubernetes-ip=auto"}, env)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", config.BootstrapKubernetesIP)
+	_, err = newTestConfig(t, []string{"--bootstrap-kubernetes-ip=auto"}, nil)
+	assert.Error(t, err)
+
+	config = NewKubeDNSConfig()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	config.AddFlags(fs)
+	assert.Error(t, fs.Parse([]string{"--bootstrap-kubernetes-ip=kubernetes"}))
+}
+
+func TestUpstreamDenyCIDRs(t *testing.T) {
+	config, err := newTestConfig(t, []string{"--upstream-deny-cidrs=127.0.0.0/8, ::1/128"}, nil)
+	require.NoError(t, err)
+	require.Len(t, config.UpstreamDenyNetworks, 2)
+	assert.Equal(t, "127.0.0.0/8", config.UpstreamDenyNetworks[0].String())
+	assert.Equal(t, "::1/128", config.UpstreamDenyNetworks[1].String())
+
+	config = NewKubeDNSConfig()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	config.AddFlags(fs)
+	assert.Error(t, fs.Parse([]string{"--upstream-deny-cidrs=127.0.0.1"}))
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package app
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -26,15 +28,17 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/miekg/dns"
 	"github.com/skynetservices/skydns/metrics"
-	"github.com/skynetservices/skydns/server"
 	"github.com/spf13/pflag"
 
 	"k8s.io/dns/cmd/kube-dns/app/options"
-	"k8s.io/dns/pkg/dns"
+	kdns "k8s.io/dns/pkg/dns"
 	dnsconfig "k8s.io/dns/pkg/dns/config"
+	kdserver "k8s.io/dns/pkg/dns/server"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -47,8 +51,39 @@ type KubeDNSServer struct {
 	healthzPort    int
 	dnsBindAddress string
 	dnsPort        int
+	tcp            kdserver.TCPOptions
 	nameServers    string
-	kd             *dns.KubeDNS
+	kd             *kdns.KubeDNS
+
+	namespaceMetricsLimit int
+	chaos                 bool
+	handlerWorkers        int
+	maxConcurrentUpstream int
+	upstreamDeadline      time.Duration
+	upstreamProtocol      kdserver.UpstreamProtocol
+	upstreamDenyNetworks  []*net.IPNet
+	noUpstream            bool
+	noUpstreamResponse    kdserver.NoUpstreamResponse
+	minimalAny            bool
+	minimalResponses      bool
+	answerOrder           kdserver.AnswerOrder
+	transferAllowNetworks []*net.IPNet
+	rrlResponsesPerSecond int
+	rrlSlip               int
+	cookieSecret          string
+	cookieEnforceSize     int
+	watchdogInterval      time.Duration
+	watchdogExit          bool
+	selfCheckName         string
+	selfCheckInterval     time.Duration
+	selfCheckFailures     int
+	readyTimeout          time.Duration
+	soa                   kdserver.SOAOptions
+	debugEndpoints        bool
+	capabilities          Capabilities
+
+	// dnsServer is set once the DNS server is running.
+	dnsServer *kdserver.Server
 }
 
 func NewKubeDNSServerDefault(config *options.KubeDNSConfig) *KubeDNSServer {
@@ -57,32 +92,103 @@ func NewKubeDNSServerDefault(config *options.KubeDNSConfig) *KubeDNSServer {
 		glog.Fatalf("Failed to create a kubernetes client: %v", err)
 	}
 
+	configSync, err := newConfigSync(config, kubeClient)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	var cookieSecret string
+	if config.CookieSecretFile != "" {
+		secret, err := ioutil.ReadFile(config.CookieSecretFile)
+		if err != nil {
+			glog.Fatalf("Failed to read the cookie secret: %v", err)
+		}
+		cookieSecret = strings.TrimSpace(string(secret))
+	}
+	if config.CookieEnforceSize > 0 && cookieSecret == "" {
+		glog.Fatalf("--cookie-enforce-size requires a --cookie-secret-file")
+	}
+
+	kd := kdns.NewKubeDNS(kubeClient, config.ClusterDomain, config.InitialSyncTimeout, configSync)
+	kd.SetEmptyEndpointResponse(config.EmptyEndpointResponse)
+	kd.SetHeadlessEmptyGrace(config.HeadlessEmptyGrace)
+	kd.SetAPIDisconnectGrace(config.APIDisconnectGrace)
+	kd.SetMaxRecords(config.MaxRecords)
+	kd.SetStrictConfig(config.ConfigStrict)
+	if config.BootstrapKubernetesIP != "" {
+		kd.SetBootstrapKubernetesIP(config.BootstrapKubernetesIP)
+	}
+
+	return &KubeDNSServer{
+		domain:         config.ClusterDomain,
+		healthzPort:    config.HealthzPort,
+		dnsBindAddress: config.DNSBindAddress,
+		dnsPort:        config.DNSPort,
+		nameServers:    config.NameServers,
+		kd:             kd,
+		tcp: kdserver.TCPOptions{
+			DisableReuseAddr:    !config.TCPReuseAddr,
+			KeepAlivePeriod:     config.TCPKeepAlivePeriod,
+			FastOpenQueueLength: config.TCPFastOpenQueue,
+		},
+
+		namespaceMetricsLimit: config.NamespaceMetricsLimit,
+		chaos:                 config.Chaos,
+		handlerWorkers:        config.HandlerWorkers,
+		maxConcurrentUpstream: config.MaxConcurrentUpstream,
+		upstreamDeadline:      config.UpstreamDeadline,
+		upstreamProtocol:      config.UpstreamProtocol,
+		upstreamDenyNetworks:  config.UpstreamDenyNetworks,
+		noUpstream:            config.NoUpstream,
+		noUpstreamResponse:    config.NoUpstreamResponse,
+		minimalAny:            config.MinimalAny,
+		minimalResponses:      config.MinimalResponses,
+		answerOrder:           config.AnswerOrder,
+		transferAllowNetworks: config.TransferAllowNetworks,
+		rrlResponsesPerSecond: config.RRLResponsesPerSecond,
+		rrlSlip:               config.RRLSlip,
+		cookieSecret:          cookieSecret,
+		cookieEnforceSize:     config.CookieEnforceSize,
+		watchdogInterval:      config.WatchdogInterval,
+		watchdogExit:          config.WatchdogExit,
+		selfCheckName:         config.SelfCheckName,
+		selfCheckInterval:     config.SelfCheckInterval,
+		selfCheckFailures:     config.SelfCheckFailures,
+		readyTimeout:          config.ReadyTimeout,
+		soa:                   config.SOA,
+		debugEndpoints:        config.DebugEndpoints,
+		capabilities:          newCapabilities(config),
+	}
+}
+
+// newConfigSync returns the Sync for the configuration sources in config,
+// merged by precedence: the defaults, the ConfigMap or config directory if
+// any, the environment and the command line.
+func newConfigSync(config *options.KubeDNSConfig, kubeClient kubernetes.Interface) (dnsconfig.Sync, error) {
 	var configSync dnsconfig.Sync
 	switch {
 	case config.ConfigMap != "" && config.ConfigDir != "":
-		glog.Fatal("Cannot use both ConfigMap and ConfigDir")
+		return nil, fmt.Errorf("cannot use both ConfigMap and ConfigDir")
 
 	case config.ConfigMap != "":
 		glog.V(0).Infof("Using configuration read from ConfigMap: %v:%v", config.ConfigMapNs, config.ConfigMap)
-		configSync = dnsconfig.NewConfigMapSync(kubeClient, config.ConfigMapNs, config.ConfigMap)
+		configSync = dnsconfig.NewConfigMapSync(
+			kubeClient, config.ConfigMapNs, config.ConfigMap, config.ConfigMapWatchBackoff)
 
 	case config.ConfigDir != "":
-		glog.V(0).Infof("Using configuration read from directory: %v", config.ConfigDir, config.ConfigPeriod)
+		glog.V(0).Infof("Using configuration read from directory: %v with period %v", config.ConfigDir, config.ConfigPeriod)
 		configSync = dnsconfig.NewFileSync(config.ConfigDir, config.ConfigPeriod)
 
 	default:
 		glog.V(0).Infof("ConfigMap and ConfigDir not configured, using values from command line flags")
 		configSync = dnsconfig.NewNopSync(&dnsconfig.Config{Federations: config.Federations})
 	}
-
-	return &KubeDNSServer{
-		domain:         config.ClusterDomain,
-		healthzPort:    config.HealthzPort,
-		dnsBindAddress: config.DNSBindAddress,
-		dnsPort:        config.DNSPort,
-		nameServers:    config.NameServers,
-		kd:             dns.NewKubeDNS(kubeClient, config.ClusterDomain, config.InitialSyncTimeout, configSync),
+	flagConfig := &dnsconfig.Config{}
+	if config.NameServers != "" {
+		flagConfig.UpstreamNameservers = strings.Split(config.NameServers, ",")
 	}
+	return dnsconfig.NewLayeredSync(configSync, dnsconfig.NewDefaultConfig(),
+		dnsconfig.Merge(config.EnvConfig, flagConfig)), nil
 }
 
 func newKubeClient(dnsConfig *options.KubeDNSConfig) (kubernetes.Interface, error) {
@@ -110,9 +216,16 @@ func (server *KubeDNSServer) Run() {
 		glog.V(0).Infof("FLAG: --%s=%q", flag.Name, flag.Value)
 	})
 	setupSignalHandlers()
-	server.startSkyDNSServer()
-	server.kd.Start()
+	server.dnsServer = server.newSkyDNSServer()
 	server.setupHandlers()
+	go func() {
+		// Run also starts d.kd and waits for the initial sync, until which
+		// /readiness fails.
+		glog.V(0).Infof("Starting SkyDNS server (%v:%v)", server.dnsBindAddress, server.dnsPort)
+		if _, err := server.dnsServer.Run(context.Background()); err != nil {
+			glog.Fatalf("Error starting DNS server: %v", err)
+		}
+	}()
 
 	glog.V(0).Infof("Status HTTP port %v", server.healthzPort)
 	if server.nameServers != "" {
@@ -125,6 +238,21 @@ func (server *KubeDNSServer) Run() {
 func (server *KubeDNSServer) setupHandlers() {
 	glog.V(0).Infof("Setting up Healthz Handler (/readiness)")
 	http.HandleFunc("/readiness", func(w http.ResponseWriter, req *http.Request) {
+		if err := server.dnsServer.Ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "%v\n", err)
+			return
+		}
+		fmt.Fprintf(w, "ok\n")
+	})
+
+	glog.V(0).Infof("Setting up liveness handler (/healthz)")
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		if err := server.dnsServer.Healthy(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%v\n", err)
+			return
+		}
 		fmt.Fprintf(w, "ok\n")
 	})
 
@@ -138,6 +266,14 @@ func (server *KubeDNSServer) setupHandlers() {
 			fmt.Fprint(w, err)
 		}
 	})
+
+	glog.V(0).Infof("Setting up capabilities handler (/capabilities)")
+	http.HandleFunc("/capabilities", capabilitiesHandler(server.capabilities))
+
+	if server.debugEndpoints {
+		glog.V(0).Infof("Setting up records debug handler (/admin/records)")
+		http.HandleFunc("/admin/records", server.kd.HandleRecords)
+	}
 }
 
 // setupSignalHandlers installs signal handler to ignore SIGINT and
@@ -169,11 +305,47 @@ func validateHostAndPort(hostAndPort string) error {
 	return nil
 }
 
-func (d *KubeDNSServer) startSkyDNSServer() {
-	glog.V(0).Infof("Starting SkyDNS server (%v:%v)", d.dnsBindAddress, d.dnsPort)
-	skydnsConfig := &server.Config{
-		Domain:  d.domain,
-		DnsAddr: fmt.Sprintf("%s:%d", d.dnsBindAddress, d.dnsPort),
+// newSkyDNSServer returns the DNS server for d. Call Run() to start it.
+func (d *KubeDNSServer) newSkyDNSServer() *kdserver.Server {
+	options := kdserver.ServerOptions{
+		Options: kdserver.Options{
+			Domain:                d.domain,
+			NamespaceMetricsLimit: d.namespaceMetricsLimit,
+			Chaos:                 d.chaos,
+			SOA:                   d.soa,
+			MaxConcurrentUpstream: d.maxConcurrentUpstream,
+			UpstreamDeadline:      d.upstreamDeadline,
+			UpstreamProtocol:      d.upstreamProtocol,
+			UpstreamDenyNetworks:  d.upstreamDenyNetworks,
+			NoUpstream:            d.noUpstream,
+			NoUpstreamResponse:    d.noUpstreamResponse,
+			MinimalAny:            d.minimalAny,
+			MinimalResponses:      d.minimalResponses,
+			AnswerOrder:           d.answerOrder,
+			TransferAllowNetworks: d.transferAllowNetworks,
+			RRLResponsesPerSecond: d.rrlResponsesPerSecond,
+			RRLSlip:               d.rrlSlip,
+			CookieSecret:          d.cookieSecret,
+			CookieEnforceSize:     d.cookieEnforceSize,
+			Config:                d.kd,
+			Backend:               d.kd,
+		},
+		Addr:              fmt.Sprintf("%s:%d", d.dnsBindAddress, d.dnsPort),
+		TCP:               d.tcp,
+		HandlerWorkers:    d.handlerWorkers,
+		WatchdogInterval:  d.watchdogInterval,
+		SelfCheckName:     d.selfCheckName,
+		SelfCheckInterval: d.selfCheckInterval,
+		SelfCheckFailures: d.selfCheckFailures,
+		ReadyTimeout:      d.readyTimeout,
+	}
+	if options.SelfCheckName == "" {
+		options.SelfCheckName = "kubernetes.default.svc." + dns.Fqdn(d.domain)
+	}
+	if d.watchdogExit {
+		options.OnStall = func() {
+			glog.Fatalf("DNS queries are not being processed, exiting")
+		}
 	}
 	if d.nameServers != "" {
 		for _, nameServer := range strings.Split(d.nameServers, ",") {
@@ -184,11 +356,9 @@ func (d *KubeDNSServer) startSkyDNSServer() {
 			if err := validateHostAndPort(nameServer); err != nil {
 				glog.Fatalf("nameserver is invalid: %s", err)
 			}
-			skydnsConfig.Nameservers = append(skydnsConfig.Nameservers, nameServer)
+			options.Nameservers = append(options.Nameservers, nameServer)
 		}
 	}
-	server.SetDefaults(skydnsConfig)
-	s := server.New(d.kd, skydnsConfig)
 	if err := metrics.Metrics(); err != nil {
 		glog.Fatalf("Skydns metrics error: %s", err)
 	} else if metrics.Port != "" {
@@ -197,5 +367,5 @@ func (d *KubeDNSServer) startSkyDNSServer() {
 		glog.V(0).Infof("Skydns metrics not enabled")
 	}
 
-	go s.Run()
+	return kdserver.New(options)
 }
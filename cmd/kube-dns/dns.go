@@ -18,12 +18,14 @@ package main
 
 import (
 	goflag "flag"
+	"os"
 
 	"github.com/golang/glog"
 	"github.com/spf13/pflag"
 
 	"k8s.io/dns/cmd/kube-dns/app"
 	"k8s.io/dns/cmd/kube-dns/app/options"
+	"k8s.io/dns/pkg/util"
 	"k8s.io/kubernetes/pkg/util/flag"
 	"k8s.io/kubernetes/pkg/util/logs"
 	"k8s.io/kubernetes/pkg/version"
@@ -44,8 +46,27 @@ func main() {
 	logs.InitLogs()
 	defer logs.FlushLogs()
 
+	if err := util.InitLogFormat(config.LogFormat); err != nil {
+		glog.Fatalf("%v", err)
+	}
+
+	if err := config.ReadEnv(pflag.CommandLine, os.Getenv); err != nil {
+		glog.Fatalf("Invalid configuration: %v", err)
+	}
+
 	verflag.PrintAndExitIfRequested()
 
+	switch args := pflag.CommandLine.Args(); {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "dump-config":
+		if err := app.DumpConfig(config, os.Stdout); err != nil {
+			glog.Fatalf("%v", err)
+		}
+		return
+	default:
+		glog.Fatalf("Unknown command %q, the only command is dump-config", args)
+	}
+
 	glog.V(0).Infof("version: %+v", version.Get())
 
 	server := app.NewKubeDNSServerDefault(config)
@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/clock"
+	"k8s.io/client-go/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/golang/glog"
+)
+
+// WatchBackoff bounds the rate at which the ConfigMap watch reconnects
+// to the API server.
+type WatchBackoff struct {
+	// Initial delay before reconnecting a watch that did not last for
+	// StablePeriod. It doubles on each such reconnection. 0 disables the
+	// backoff.
+	Initial time.Duration
+	// Max delay before reconnecting.
+	Max time.Duration
+	// StablePeriod after which a watch is considered stable: the next
+	// reconnection is immediate and the delay is reset to Initial.
+	StablePeriod time.Duration
+}
+
+// DefaultWatchBackoff returns the default WatchBackoff.
+func DefaultWatchBackoff() WatchBackoff {
+	return WatchBackoff{
+		Initial:      time.Second,
+		Max:          time.Minute,
+		StablePeriod: 2 * time.Minute,
+	}
+}
+
+// watchBackoff delays the reconnections of a watch, e.g. when the API
+// server connection is flapping. The informer retries failed lists and
+// watches every second on its own.
+type watchBackoff struct {
+	backoff WatchBackoff
+	clock   clock.Clock
+
+	// lock protects the fields below.
+	lock sync.Mutex
+	// watched is whether the watch was started once.
+	watched bool
+	// lastWatch is the time the watch was last started.
+	lastWatch time.Time
+	// delay before the last reconnection, 0 if the previous watch was
+	// stable.
+	delay time.Duration
+}
+
+// watchFunc returns watchFunc with the reconnections delayed.
+func (b *watchBackoff) watchFunc(watchFunc cache.WatchFunc) cache.WatchFunc {
+	return func(options v1.ListOptions) (watch.Interface, error) {
+		b.wait()
+		return watchFunc(options)
+	}
+}
+
+// wait blocks before a reconnection for the backoff delay.
+func (b *watchBackoff) wait() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.clock.Now()
+	if !b.watched {
+		b.watched = true
+		b.lastWatch = now
+		return
+	}
+	configWatchReconnects.Inc()
+
+	if b.backoff.Initial == 0 || now.Sub(b.lastWatch) >= b.backoff.StablePeriod {
+		b.delay = 0
+	} else {
+		if b.delay == 0 {
+			b.delay = b.backoff.Initial
+		} else {
+			b.delay *= 2
+		}
+		if b.delay > b.backoff.Max {
+			b.delay = b.backoff.Max
+		}
+	}
+	if b.delay > 0 {
+		glog.V(2).Infof("Reconnecting the ConfigMap watch in %v", b.delay)
+		b.clock.Sleep(b.delay)
+	}
+	b.lastWatch = b.clock.Now()
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/clock"
+	"k8s.io/client-go/pkg/watch"
+)
+
+func configWatchReconnectsValue() float64 {
+	m := &dto.Metric{}
+	configWatchReconnects.Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func TestWatchBackoff(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	b := &watchBackoff{
+		backoff: WatchBackoff{Initial: time.Second, Max: 5 * time.Second, StablePeriod: time.Minute},
+		clock:   fakeClock,
+	}
+	watches := 0
+	watchFunc := b.watchFunc(func(v1.ListOptions) (watch.Interface, error) {
+		watches++
+		return nil, errors.New("connection reset")
+	})
+	// reconnect calls watchFunc, as the informer does after a failed
+	// watch, and returns the time it blocked for.
+	reconnect := func() time.Duration {
+		start := fakeClock.Now()
+		_, err := watchFunc(v1.ListOptions{})
+		assert.Error(t, err)
+		return fakeClock.Since(start)
+	}
+	before := configWatchReconnectsValue()
+
+	// The first watch is not delayed, then the delay doubles up to Max.
+	for i, delay := range []time.Duration{0, 1, 2, 4, 5, 5} {
+		assert.Equal(t, delay*time.Second, reconnect(), "watch %d", i)
+	}
+	assert.Equal(t, 6, watches)
+	assert.Equal(t, float64(5), configWatchReconnectsValue()-before)
+
+	// Once a watch is stable, the delay is reset.
+	fakeClock.Step(time.Minute)
+	assert.Equal(t, time.Duration(0), reconnect())
+	assert.Equal(t, time.Second, reconnect())
+	assert.Equal(t, float64(7), configWatchReconnectsValue()-before)
+
+	// The backoff can be disabled.
+	b = &watchBackoff{clock: fakeClock}
+	watchFunc = b.watchFunc(func(v1.ListOptions) (watch.Interface, error) {
+		return nil, errors.New("connection reset")
+	})
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), reconnect())
+	}
+}
@@ -17,10 +17,20 @@ limitations under the License.
 package config
 
 import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
 	types "k8s.io/client-go/pkg/apis/meta/v1"
 	fed "k8s.io/dns/pkg/dns/federation"
+	"k8s.io/kubernetes/pkg/util/validation"
 )
 
+// MaxRecordTTL is the largest TTL allowed by RFC 2181.
+const MaxRecordTTL = 1<<31 - 1
+
 // Config populated either from the configuration source (command
 // line flags or via the config map mechanism).
 type Config struct {
@@ -35,6 +45,71 @@ type Config struct {
 	// Map of federation names that the cluster in which this kube-dns
 	// is running belongs to, to the corresponding domain names.
 	Federations map[string]string `json:"federations"`
+
+	// QueryACLs maps source CIDRs to the zones they are allowed to
+	// query, e.g. {"10.1.0.0/16": ["acme.local"]}. A zone listed for any
+	// CIDR can only be queried from the CIDRs that list it. Zones that
+	// are not listed can be queried from anywhere.
+	QueryACLs map[string][]string `json:"queryACLs"`
+
+	// Views maps client CIDRs to record overrides (split-horizon DNS),
+	// e.g. {"10.2.0.0/16": {"records": {"api.acme.local": ["10.3.0.1"]}}}.
+	// Address queries for a name with an override are answered from the
+	// most specific view of the client that has one. All other queries
+	// are resolved as usual.
+	Views map[string]View `json:"views"`
+
+	// RecordTTLs maps record types (e.g. "A", "SRV") to the TTL in
+	// seconds of the records of that type in the cluster domain. Types
+	// that are not listed keep the default TTL.
+	RecordTTLs map[string]uint32 `json:"recordTTLs"`
+
+	// Map of stub domains to the nameservers (ip or ip:port) that
+	// queries for names in the domain are forwarded to.
+	StubDomains map[string][]string `json:"stubDomains"`
+
+	// List of nameservers (ip or ip:port) to forward queries outside of
+	// the cluster domain to. If empty, the nameservers from the command
+	// line or /etc/resolv.conf are used.
+	UpstreamNameservers []string `json:"upstreamNameservers"`
+
+	// List of nameservers (ip or ip:port) that queries outside of the
+	// cluster domain are forwarded to once every upstream nameserver
+	// has failed or answered SERVFAIL.
+	FallbackUpstreamNameservers []string `json:"fallbackUpstreamNameservers"`
+}
+
+// View is the set of record overrides for the clients in a CIDR.
+type View struct {
+	// Records maps names to their IPv4 and IPv6 addresses in the view.
+	Records map[string][]string `json:"records"`
+}
+
+// Limits on the size of a Config, checked by Validate. 0 means no limit.
+type Limits struct {
+	// MaxStubDomains is the maximum number of stub domains.
+	MaxStubDomains int
+	// MaxUpstreamNameservers is the maximum number of upstream
+	// nameservers, and of fallback upstream nameservers.
+	MaxUpstreamNameservers int
+}
+
+// DefaultLimits returns the default Limits. Resolvers commonly only use
+// the first three nameservers (the resolv.conf limit), so more upstream
+// nameservers are unlikely to be useful.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxStubDomains:         100,
+		MaxUpstreamNameservers: 3,
+	}
+}
+
+var limits = DefaultLimits()
+
+// SetLimits sets the Limits checked by Validate. It must be called before
+// any configuration is read.
+func SetLimits(l Limits) {
+	limits = l
 }
 
 func NewDefaultConfig() *Config {
@@ -48,6 +123,24 @@ func (config *Config) Validate() error {
 	if err := config.validateFederations(); err != nil {
 		return err
 	}
+	if err := config.validateQueryACLs(); err != nil {
+		return err
+	}
+	if err := config.validateViews(); err != nil {
+		return err
+	}
+	if err := config.validateRecordTTLs(); err != nil {
+		return err
+	}
+	if err := config.validateStubDomains(); err != nil {
+		return err
+	}
+	if err := config.validateUpstreamNameservers(); err != nil {
+		return err
+	}
+	if err := config.validateFallbackUpstreamNameservers(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -63,3 +156,136 @@ func (config *Config) validateFederations() error {
 	}
 	return nil
 }
+
+func (config *Config) validateQueryACLs() error {
+	for cidr, zones := range config.QueryACLs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid query ACL source %q: %v", cidr, err)
+		}
+		for _, zone := range zones {
+			if zone == "" {
+				return fmt.Errorf("invalid query ACL for %q: empty zone", cidr)
+			}
+		}
+	}
+	return nil
+}
+
+func (config *Config) validateViews() error {
+	for cidr, view := range config.Views {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid view %q: %v", cidr, err)
+		}
+		for name, addresses := range view.Records {
+			if errs := validation.IsDNS1123Subdomain(strings.ToLower(strings.TrimSuffix(name, "."))); len(errs) > 0 {
+				return fmt.Errorf("invalid name %q in view %q: %v", name, cidr, errs)
+			}
+			if len(addresses) == 0 {
+				return fmt.Errorf("name %q in view %q has no addresses", name, cidr)
+			}
+			for _, address := range addresses {
+				if net.ParseIP(address) == nil {
+					return fmt.Errorf("invalid address %q for %q in view %q", address, name, cidr)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (config *Config) validateRecordTTLs() error {
+	for qtype, ttl := range config.RecordTTLs {
+		if _, ok := RecordType(qtype); !ok {
+			return fmt.Errorf("invalid record TTL: unknown record type %q", qtype)
+		}
+		if ttl > MaxRecordTTL {
+			return fmt.Errorf("invalid record TTL for %v: %d is larger than %d",
+				qtype, ttl, MaxRecordTTL)
+		}
+	}
+	return nil
+}
+
+// RecordType returns the type of the record type name (e.g. "SRV").
+func RecordType(name string) (uint16, bool) {
+	qtype, ok := dns.StringToType[strings.ToUpper(name)]
+	return qtype, ok
+}
+
+func (config *Config) validateStubDomains() error {
+	if limits.MaxStubDomains > 0 && len(config.StubDomains) > limits.MaxStubDomains {
+		return fmt.Errorf("%d stub domains configured, the limit (max-stub-domains) is %d",
+			len(config.StubDomains), limits.MaxStubDomains)
+	}
+	for domain, nameservers := range config.StubDomains {
+		if errs := validation.IsDNS1123Subdomain(strings.TrimSuffix(domain, ".")); len(errs) > 0 {
+			return fmt.Errorf("invalid stub domain %q: %v", domain, errs)
+		}
+		if len(nameservers) == 0 {
+			return fmt.Errorf("stub domain %q has no nameservers", domain)
+		}
+		for _, nameserver := range nameservers {
+			if err := ValidateNameserver(nameserver); err != nil {
+				return fmt.Errorf("invalid nameserver for stub domain %q: %v", domain, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (config *Config) validateUpstreamNameservers() error {
+	if limits.MaxUpstreamNameservers > 0 && len(config.UpstreamNameservers) > limits.MaxUpstreamNameservers {
+		return fmt.Errorf("%d upstream nameservers configured, the limit (max-upstream-nameservers) is %d",
+			len(config.UpstreamNameservers), limits.MaxUpstreamNameservers)
+	}
+	for _, nameserver := range config.UpstreamNameservers {
+		if err := ValidateNameserver(nameserver); err != nil {
+			return fmt.Errorf("invalid upstream nameserver: %v", err)
+		}
+	}
+	return nil
+}
+
+func (config *Config) validateFallbackUpstreamNameservers() error {
+	if limits.MaxUpstreamNameservers > 0 && len(config.FallbackUpstreamNameservers) > limits.MaxUpstreamNameservers {
+		return fmt.Errorf("%d fallback upstream nameservers configured, the limit (max-upstream-nameservers) is %d",
+			len(config.FallbackUpstreamNameservers), limits.MaxUpstreamNameservers)
+	}
+	for _, nameserver := range config.FallbackUpstreamNameservers {
+		if err := ValidateNameserver(nameserver); err != nil {
+			return fmt.Errorf("invalid fallback upstream nameserver: %v", err)
+		}
+	}
+	return nil
+}
+
+// ValidateNameserver returns an error if nameserver is not of the form
+// "ip" or "ip:port".
+func ValidateNameserver(nameserver string) error {
+	host, port := nameserver, ""
+	if h, p, err := net.SplitHostPort(nameserver); err == nil {
+		host, port = h, p
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("%q is not an IP address", nameserver)
+	}
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("%q has an invalid port: %v", nameserver, err)
+		}
+		if errs := validation.IsValidPortNum(n); len(errs) > 0 {
+			return fmt.Errorf("%q has an invalid port: %v", nameserver, errs)
+		}
+	}
+	return nil
+}
+
+// NameserverAddr returns nameserver as "ip:port", using port 53 if
+// nameserver has no port.
+func NameserverAddr(nameserver string) string {
+	if _, _, err := net.SplitHostPort(nameserver); err == nil {
+		return nameserver
+	}
+	return net.JoinHostPort(nameserver, "53")
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package config
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,6 +46,88 @@ func TestValidate(t *testing.T) {
 			},
 			hasError: true,
 		},
+		{
+			config: &Config{
+				QueryACLs: map[string][]string{
+					"10.1.0.0/16": {"acme.local"},
+					"fd00::/8":    {"acme.local", "globex.local."},
+				},
+			},
+		},
+		{
+			config: &Config{
+				QueryACLs: map[string][]string{"10.1.0.0": {"acme.local"}},
+			},
+			hasError: true,
+		},
+		{
+			config: &Config{
+				QueryACLs: map[string][]string{"10.1.0.0/16": {""}},
+			},
+			hasError: true,
+		},
+		{
+			config: &Config{Views: map[string]View{
+				"10.2.0.0/16": {Records: map[string][]string{
+					"api.acme.local.": {"10.3.0.1", "fd00::1"},
+					"DB.acme.local":   {"10.3.0.2"},
+				}},
+			}},
+		},
+		{
+			config:   &Config{Views: map[string]View{"10.2.0.0": {}}},
+			hasError: true,
+		},
+		{
+			config: &Config{Views: map[string]View{
+				"10.2.0.0/16": {Records: map[string][]string{"api_acme.local": {"10.3.0.1"}}},
+			}},
+			hasError: true,
+		},
+		{
+			config: &Config{Views: map[string]View{
+				"10.2.0.0/16": {Records: map[string][]string{"api.acme.local": {}}},
+			}},
+			hasError: true,
+		},
+		{
+			config: &Config{Views: map[string]View{
+				"10.2.0.0/16": {Records: map[string][]string{"api.acme.local": {"api.other.local"}}},
+			}},
+			hasError: true,
+		},
+		{
+			config: &Config{RecordTTLs: map[string]uint32{"A": 5, "srv": 3600, "PTR": 0}},
+		},
+		{
+			config:   &Config{RecordTTLs: map[string]uint32{"BOGUS": 30}},
+			hasError: true,
+		},
+		{
+			config:   &Config{RecordTTLs: map[string]uint32{"A": MaxRecordTTL + 1}},
+			hasError: true,
+		},
+		{
+			config: &Config{
+				StubDomains:         map[string][]string{"acme.local": {"1.2.3.4", "1.2.3.5:5353"}},
+				UpstreamNameservers: []string{"8.8.8.8", "[2001:db8::1]:53"},
+			},
+		},
+		{
+			config:   &Config{StubDomains: map[string][]string{"acme.local": {"ns.acme.local"}}},
+			hasError: true,
+		},
+		{
+			config:   &Config{UpstreamNameservers: []string{"8.8.8.8:0"}},
+			hasError: true,
+		},
+		{
+			config: &Config{FallbackUpstreamNameservers: []string{"8.8.8.8", "1.1.1.1:53"}},
+		},
+		{
+			config:   &Config{FallbackUpstreamNameservers: []string{"dns.example.com"}},
+			hasError: true,
+		},
 	} {
 		err := testCase.config.Validate()
 		if !testCase.hasError {
@@ -54,3 +137,36 @@ func TestValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateLimits(t *testing.T) {
+	defer SetLimits(DefaultLimits())
+	SetLimits(Limits{MaxStubDomains: 2, MaxUpstreamNameservers: 1})
+
+	stubDomains := func(n int) map[string][]string {
+		domains := make(map[string][]string)
+		for i := 0; i < n; i++ {
+			domains[fmt.Sprintf("stub%d.local", i)] = []string{"1.2.3.4"}
+		}
+		return domains
+	}
+
+	assert.NoError(t, (&Config{StubDomains: stubDomains(2)}).Validate())
+	err := (&Config{StubDomains: stubDomains(3)}).Validate()
+	if assert.Error(t, err) {
+		assert.Equal(t, "3 stub domains configured, the limit (max-stub-domains) is 2", err.Error())
+	}
+
+	assert.NoError(t, (&Config{UpstreamNameservers: []string{"8.8.8.8"}}).Validate())
+	err = (&Config{UpstreamNameservers: []string{"8.8.8.8", "8.8.4.4"}}).Validate()
+	if assert.Error(t, err) {
+		assert.Equal(t, "2 upstream nameservers configured, the limit (max-upstream-nameservers) is 1", err.Error())
+	}
+	err = (&Config{FallbackUpstreamNameservers: []string{"8.8.8.8", "8.8.4.4"}}).Validate()
+	if assert.Error(t, err) {
+		assert.Equal(t, "2 fallback upstream nameservers configured, the limit (max-upstream-nameservers) is 1", err.Error())
+	}
+
+	// 0 means no limit.
+	SetLimits(Limits{})
+	assert.NoError(t, (&Config{StubDomains: stubDomains(200)}).Validate())
+}
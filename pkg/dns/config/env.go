@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// EnvUpstreamNameservers is a comma separated list of upstream
+	// nameservers (ip or ip:port).
+	EnvUpstreamNameservers = "KUBEDNS_UPSTREAM_NAMESERVERS"
+	// EnvStubDomains is a JSON map of stub domains to nameservers, in the
+	// same format as the stubDomains ConfigMap key.
+	EnvStubDomains = "KUBEDNS_STUB_DOMAINS"
+)
+
+// ReadEnv returns the configuration from the environment, using getenv
+// (e.g. os.Getenv) to read variables. Fields of variables that are not
+// set are left nil.
+func ReadEnv(getenv func(string) string) (*Config, error) {
+	config := &Config{}
+
+	if value := strings.TrimSpace(getenv(EnvUpstreamNameservers)); value != "" {
+		for _, nameserver := range strings.Split(value, ",") {
+			config.UpstreamNameservers = append(
+				config.UpstreamNameservers, strings.TrimSpace(nameserver))
+		}
+	}
+
+	if value := getenv(EnvStubDomains); value != "" {
+		if err := json.Unmarshal([]byte(value), &config.StubDomains); err != nil {
+			return nil, fmt.Errorf("invalid %v: %v", EnvStubDomains, err)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envFunc(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestReadEnv(t *testing.T) {
+	config, err := ReadEnv(envFunc(map[string]string{
+		EnvUpstreamNameservers: "8.8.8.8, 8.8.4.4:5353",
+		EnvStubDomains:         `{"acme.local": ["1.2.3.4"]}`,
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"8.8.8.8", "8.8.4.4:5353"}, config.UpstreamNameservers)
+	assert.Equal(t, map[string][]string{"acme.local": {"1.2.3.4"}}, config.StubDomains)
+
+	config, err = ReadEnv(envFunc(nil))
+	require.NoError(t, err)
+	assert.Nil(t, config.UpstreamNameservers)
+	assert.Nil(t, config.StubDomains)
+
+	for _, env := range []map[string]string{
+		{EnvUpstreamNameservers: "dns.example.com"},
+		{EnvUpstreamNameservers: "8.8.8.8:99999"},
+		{EnvStubDomains: `not json`},
+		{EnvStubDomains: `{"acme.local": []}`},
+		{EnvStubDomains: `{"acme..local": ["1.2.3.4"]}`},
+		{EnvStubDomains: `{"acme.local": ["1.2.3"]}`},
+	} {
+		_, err := ReadEnv(envFunc(env))
+		assert.Error(t, err, "%v", env)
+	}
+}
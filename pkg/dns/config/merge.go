@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/golang/glog"
+)
+
+// Merge returns the configuration made of sources, from the lowest to
+// the highest precedence. Each field is taken from the last source that
+// sets it (non-nil), so that fields a source leaves unset do not clobber
+// those of the sources before it. Nil sources are skipped. The sources
+// are not modified and the result is not validated.
+func Merge(sources ...*Config) *Config {
+	merged := &Config{}
+	for _, source := range sources {
+		if source == nil {
+			continue
+		}
+		if source.Federations != nil {
+			merged.Federations = source.Federations
+		}
+		if source.QueryACLs != nil {
+			merged.QueryACLs = source.QueryACLs
+		}
+		if source.Views != nil {
+			merged.Views = source.Views
+		}
+		if source.RecordTTLs != nil {
+			merged.RecordTTLs = source.RecordTTLs
+		}
+		if source.StubDomains != nil {
+			merged.StubDomains = source.StubDomains
+		}
+		if source.UpstreamNameservers != nil {
+			merged.UpstreamNameservers = source.UpstreamNameservers
+		}
+		if source.FallbackUpstreamNameservers != nil {
+			merged.FallbackUpstreamNameservers = source.FallbackUpstreamNameservers
+		}
+	}
+	return merged
+}
+
+// NewLayeredSync returns a Sync whose configurations are those of sync
+// merged between defaults and overrides: Merge(defaults, <sync>,
+// overrides). Merged configurations that fail to validate are rejected
+// like invalid configurations from sync.
+func NewLayeredSync(sync Sync, defaults, overrides *Config) Sync {
+	return &layeredSync{sync: sync, defaults: defaults, overrides: overrides}
+}
+
+type layeredSync struct {
+	applyCallbacks
+
+	sync      Sync
+	defaults  *Config
+	overrides *Config
+	channel   chan *Config
+}
+
+var _ Sync = (*layeredSync)(nil)
+
+func (sync *layeredSync) Once() (*Config, error) {
+	config, err := sync.sync.Once()
+	if err != nil {
+		return nil, err
+	}
+	if config, err = sync.merge(config); err != nil {
+		return nil, err
+	}
+	sync.applied(config)
+	return config, nil
+}
+
+func (sync *layeredSync) Periodic() <-chan *Config {
+	sync.channel = make(chan *Config)
+	go func() {
+		for config := range sync.sync.Periodic() {
+			config, err := sync.merge(config)
+			if err != nil {
+				glog.Errorf("%v, ignoring update", err)
+				continue
+			}
+			sync.channel <- config
+			sync.applied(config)
+		}
+	}()
+	return sync.channel
+}
+
+func (sync *layeredSync) merge(config *Config) (*Config, error) {
+	merged := Merge(sync.defaults, config, sync.overrides)
+	if err := merged.Validate(); err != nil {
+		return nil, &InvalidConfigError{err}
+	}
+	return merged, nil
+}
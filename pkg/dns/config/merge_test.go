@@ -0,0 +1,142 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	// layer returns a config with each field set to a value identifying
+	// the layer.
+	layer := func(name string) *Config {
+		return &Config{
+			Federations:                 map[string]string{name: "example.com"},
+			QueryACLs:                   map[string][]string{"10.0.0.0/8": {name + ".local"}},
+			Views:                       map[string]View{"10.0.0.0/8": {Records: map[string][]string{name: {"10.0.0.1"}}}},
+			RecordTTLs:                  map[string]uint32{"A": uint32(len(name))},
+			StubDomains:                 map[string][]string{name + ".local": {"1.2.3.4"}},
+			UpstreamNameservers:         []string{name},
+			FallbackUpstreamNameservers: []string{name + "-fallback"},
+		}
+	}
+	defaults, configMap, env, flags := layer("defaults"), layer("configmap"), layer("env"), layer("flags")
+
+	for _, tc := range []struct {
+		field string
+		// unset clears the field in config.
+		unset func(config *Config)
+		// get returns the field of config.
+		get func(config *Config) interface{}
+	}{
+		{
+			"federations",
+			func(config *Config) { config.Federations = nil },
+			func(config *Config) interface{} { return config.Federations },
+		},
+		{
+			"queryACLs",
+			func(config *Config) { config.QueryACLs = nil },
+			func(config *Config) interface{} { return config.QueryACLs },
+		},
+		{
+			"views",
+			func(config *Config) { config.Views = nil },
+			func(config *Config) interface{} { return config.Views },
+		},
+		{
+			"recordTTLs",
+			func(config *Config) { config.RecordTTLs = nil },
+			func(config *Config) interface{} { return config.RecordTTLs },
+		},
+		{
+			"stubDomains",
+			func(config *Config) { config.StubDomains = nil },
+			func(config *Config) interface{} { return config.StubDomains },
+		},
+		{
+			"upstreamNameservers",
+			func(config *Config) { config.UpstreamNameservers = nil },
+			func(config *Config) interface{} { return config.UpstreamNameservers },
+		},
+		{
+			"fallbackUpstreamNameservers",
+			func(config *Config) { config.FallbackUpstreamNameservers = nil },
+			func(config *Config) interface{} { return config.FallbackUpstreamNameservers },
+		},
+	} {
+		unset := func(config *Config) *Config {
+			c := *config
+			tc.unset(&c)
+			return &c
+		}
+
+		// Each layer takes precedence over the ones before it.
+		assert.Equal(t, tc.get(flags), tc.get(Merge(defaults, configMap, env, flags)), tc.field)
+		assert.Equal(t, tc.get(env), tc.get(Merge(defaults, configMap, env, unset(flags))), tc.field)
+		assert.Equal(t, tc.get(configMap),
+			tc.get(Merge(defaults, configMap, unset(env), unset(flags))), tc.field)
+		assert.Equal(t, tc.get(defaults),
+			tc.get(Merge(defaults, unset(configMap), unset(env), unset(flags))), tc.field)
+		// Unset layers do not clobber the ones below, and missing layers
+		// are skipped.
+		assert.Equal(t, tc.get(configMap), tc.get(Merge(nil, configMap, unset(env), nil)), tc.field)
+		assert.Nil(t, tc.get(Merge(unset(defaults), nil)), tc.field)
+	}
+}
+
+func TestLayeredSync(t *testing.T) {
+	defaults := &Config{
+		Federations: map[string]string{},
+		StubDomains: map[string][]string{"acme.local": {"1.2.3.4"}},
+	}
+	overrides := &Config{UpstreamNameservers: []string{"8.8.8.8"}}
+
+	mockSource := newMockSource(syncResult{
+		Version: "1",
+		Data: map[string]string{
+			"upstreamNameservers": `["9.9.9.9"]`,
+			"recordTTLs":          `{"A": 5}`,
+		},
+	}, nil)
+	sync := NewLayeredSync(newSync(mockSource), defaults, overrides)
+
+	config, err := sync.Once()
+	require.NoError(t, err)
+	assert.Equal(t, overrides.UpstreamNameservers, config.UpstreamNameservers)
+	assert.Equal(t, map[string]uint32{"A": 5}, config.RecordTTLs)
+	assert.Equal(t, defaults.StubDomains, config.StubDomains)
+
+	ch := sync.Periodic()
+	mockSource.ch <- syncResult{
+		Version: "2",
+		Data:    map[string]string{"stubDomains": `{"globex.local": ["5.6.7.8"]}`},
+	}
+	config = <-ch
+	assert.Equal(t, overrides.UpstreamNameservers, config.UpstreamNameservers)
+	assert.Nil(t, config.RecordTTLs)
+	assert.Equal(t, map[string][]string{"globex.local": {"5.6.7.8"}}, config.StubDomains)
+
+	// The merged configuration is validated.
+	sync = NewLayeredSync(NewNopSync(&Config{}), nil, &Config{UpstreamNameservers: []string{"not-an-ip"}})
+	_, err = sync.Once()
+	_, invalid := err.(*InvalidConfigError)
+	assert.True(t, invalid, "%v", err)
+}
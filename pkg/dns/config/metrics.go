@@ -0,0 +1,41 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configMapDeleted = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "kubedns",
+			Name:      "configmap_deleted",
+			Help:      "1 if the kube-dns ConfigMap was deleted and the last configuration is kept, 0 otherwise",
+		})
+	configWatchReconnects = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kubedns",
+			Name:      "config_watch_reconnects_total",
+			Help:      "Number of times the kube-dns ConfigMap watch reconnected to the API server",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(configMapDeleted)
+	prometheus.MustRegister(configWatchReconnects)
+}
@@ -18,6 +18,8 @@ package config
 
 // MockSync is a testing mock.
 type MockSync struct {
+	applyCallbacks
+
 	// Config that will be returned from Once().
 	Config *Config
 	// Error that will be returned from Once().
@@ -38,11 +40,22 @@ func NewMockSync(config *Config, error error) *MockSync {
 }
 
 func (sync *MockSync) Once() (*Config, error) {
+	if sync.Error == nil {
+		sync.applied(sync.Config)
+	}
 	return sync.Config, sync.Error
 }
 
 func (sync *MockSync) Periodic() <-chan *Config {
-	return sync.Chan
+	channel := make(chan *Config)
+	go func() {
+		for config := range sync.Chan {
+			channel <- config
+			sync.applied(config)
+		}
+		close(channel)
+	}()
+	return channel
 }
 
 type mockSource struct {
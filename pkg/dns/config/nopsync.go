@@ -19,6 +19,8 @@ package config
 // nopSync does no synchronization, used when the DNS server is
 // started without a ConfigMap configured.
 type nopSync struct {
+	applyCallbacks
+
 	config *Config
 }
 
@@ -29,6 +31,7 @@ func NewNopSync(config *Config) Sync {
 }
 
 func (sync *nopSync) Once() (*Config, error) {
+	sync.applied(sync.config)
 	return sync.config, nil
 }
 
@@ -17,6 +17,10 @@ limitations under the License.
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
 	fed "k8s.io/dns/pkg/dns/federation"
 
 	"github.com/golang/glog"
@@ -25,7 +29,8 @@ import (
 // Sync manages synchronization of the config map.
 type Sync interface {
 	// Once does a blocking synchronization of the config map. If the
-	// ConfigMap fails to validate, this method will return nil, err.
+	// ConfigMap fails to validate, this method will return nil, err with
+	// an *InvalidConfigError.
 	Once() (*Config, error)
 
 	// Start a periodic synchronization of the configuration map. When a
@@ -34,8 +39,51 @@ type Sync interface {
 	//
 	// It is an error to call this more than once.
 	Periodic() <-chan *Config
+
+	// OnApply registers callback to be called with each valid
+	// configuration returned by Once() or received from the Periodic()
+	// channel. Callbacks are called in registration order, after the
+	// configuration has been delivered.
+	OnApply(callback func(*Config))
+}
+
+// applyCallbacks implements Sync.OnApply.
+type applyCallbacks struct {
+	// lock protects callbacks.
+	lock      sync.Mutex
+	callbacks []func(*Config)
+}
+
+func (c *applyCallbacks) OnApply(callback func(*Config)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.callbacks = append(c.callbacks, callback)
+}
+
+// applied calls the registered callbacks with config.
+func (c *applyCallbacks) applied(config *Config) {
+	c.lock.Lock()
+	callbacks := c.callbacks
+	c.lock.Unlock()
+	for _, callback := range callbacks {
+		callback(config)
+	}
+}
+
+// InvalidConfigError is returned when the configuration fails to parse
+// or validate, as opposed to failing to be read.
+type InvalidConfigError struct {
+	Err error
+}
+
+func (e *InvalidConfigError) Error() string {
+	return "invalid configuration: " + e.Err.Error()
 }
 
+// maxValueSize is the maximum size in bytes of a configuration value, so
+// that an enormous value is rejected before it is parsed.
+const maxValueSize = 256 * 1024
+
 type syncResult struct {
 	Version string
 	Data    map[string]string
@@ -57,6 +105,8 @@ func newSync(source syncSource) Sync {
 
 // kubeSync implements Sync using the provided syncSource
 type kubeSync struct {
+	applyCallbacks
+
 	syncSource syncSource
 
 	channel chan *Config
@@ -73,6 +123,9 @@ func (sync *kubeSync) Once() (*Config, error) {
 	}
 	// Always build a config object so we return non-nil
 	config, _, err := sync.processUpdate(result, true)
+	if err == nil {
+		sync.applied(config)
+	}
 	return config, err
 }
 
@@ -89,6 +142,7 @@ func (sync *kubeSync) Periodic() <-chan *Config {
 				continue
 			}
 			sync.channel <- config
+			sync.applied(config)
 		}
 	}()
 	return sync.channel
@@ -114,16 +168,42 @@ func (sync *kubeSync) processUpdate(result syncResult, buildUnchangedConfig bool
 		return
 	}
 
+	for key, value := range result.Data {
+		if len(value) > maxValueSize {
+			glog.Errorf("Invalid configuration: %v is %d bytes, ignoring update", key, len(value))
+			err = &InvalidConfigError{fmt.Errorf("value of %v is %d bytes, larger than the limit of %d bytes",
+				key, len(value), maxValueSize)}
+			return
+		}
+	}
+
 	config = &Config{}
 
 	if err = sync.updateFederations(result.Data, config); err != nil {
 		glog.Errorf("Invalid configuration, ignoring update")
+		err = &InvalidConfigError{err}
 		return
 	}
 
+	for key, value := range map[string]interface{}{
+		"fallbackUpstreamNameservers": &config.FallbackUpstreamNameservers,
+		"queryACLs":                   &config.QueryACLs,
+		"recordTTLs":                  &config.RecordTTLs,
+		"stubDomains":                 &config.StubDomains,
+		"upstreamNameservers":         &config.UpstreamNameservers,
+		"views":                       &config.Views,
+	} {
+		if err = sync.updateJSON(result.Data, key, value); err != nil {
+			glog.Errorf("Invalid configuration, ignoring update")
+			err = &InvalidConfigError{err}
+			return
+		}
+	}
+
 	if err = config.Validate(); err != nil {
 		glog.Errorf("Invalid onfiguration: %v (value was %+v), ignoring update", err, config)
 		config = nil
+		err = &InvalidConfigError{err}
 		return
 	}
 
@@ -145,3 +225,19 @@ func (sync *kubeSync) updateFederations(data map[string]string, config *Config)
 
 	return
 }
+
+// updateJSON decodes the JSON value of key in data into value, if
+// present.
+func (sync *kubeSync) updateJSON(data map[string]string, key string, value interface{}) (err error) {
+	if jsonValue, ok := data[key]; ok {
+		if err = json.Unmarshal([]byte(jsonValue), value); err != nil {
+			glog.Errorf("Invalid %v value: %v (value was %q)", key, err, jsonValue)
+			return
+		}
+		glog.V(2).Infof("Updated %v to %v", key, jsonValue)
+	} else {
+		glog.V(2).Infof("No %v present", key)
+	}
+
+	return
+}
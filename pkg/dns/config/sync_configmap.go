@@ -22,6 +22,7 @@ import (
 	metav1 "k8s.io/client-go/pkg/apis/meta/v1"
 	"k8s.io/client-go/pkg/fields"
 	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/clock"
 	"k8s.io/client-go/pkg/util/wait"
 	"k8s.io/client-go/pkg/watch"
 	"k8s.io/client-go/tools/cache"
@@ -31,8 +32,9 @@ import (
 	"github.com/golang/glog"
 )
 
-// NewConfigMapSync returns a Sync that watches a config map in the API
-func NewConfigMapSync(client kubernetes.Interface, ns string, name string) Sync {
+// NewConfigMapSync returns a Sync that watches a config map in the API.
+// The reconnections of the watch are delayed by backoff.
+func NewConfigMapSync(client kubernetes.Interface, ns string, name string, backoff WatchBackoff) Sync {
 	syncSource := &kubeAPISyncSource{
 		ns:      ns,
 		name:    name,
@@ -40,15 +42,16 @@ func NewConfigMapSync(client kubernetes.Interface, ns string, name string) Sync
 		channel: make(chan syncResult),
 	}
 
+	watchBackoff := &watchBackoff{backoff: backoff, clock: clock.RealClock{}}
 	listWatch := &cache.ListWatch{
 		ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
 			options.FieldSelector = fields.Set{"metadata.name": name}.AsSelector().String()
 			return client.Core().ConfigMaps(ns).List(options)
 		},
-		WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+		WatchFunc: watchBackoff.watchFunc(func(options v1.ListOptions) (watch.Interface, error) {
 			options.FieldSelector = fields.Set{"metadata.name": name}.AsSelector().String()
 			return client.Core().ConfigMaps(ns).Watch(options)
-		},
+		}),
 	}
 
 	store, controller := cache.NewInformer(
@@ -105,12 +108,16 @@ func (syncSource *kubeAPISyncSource) toConfigMap(obj interface{}) *v1.ConfigMap
 func (syncSource *kubeAPISyncSource) onAdd(obj interface{}) {
 	cm := syncSource.toConfigMap(obj)
 	glog.V(2).Infof("ConfigMap %s:%s was created", syncSource.ns, syncSource.name)
+	configMapDeleted.Set(0)
 	syncSource.channel <- syncResult{Version: cm.ResourceVersion, Data: cm.Data}
 }
 
+// onDelete keeps the current configuration, as reverting to the defaults
+// would drop the stub domains and upstream nameservers while the ConfigMap
+// is being recreated. Once it is, the sync resumes.
 func (syncSource *kubeAPISyncSource) onDelete(_ interface{}) {
-	glog.V(2).Infof("ConfigMap %s:%s was deleted, reverting to default configuration", syncSource.ns, syncSource.name)
-	syncSource.channel <- syncResult{Version: "", Data: nil}
+	glog.Warningf("ConfigMap %s:%s was deleted, keeping the last configuration", syncSource.ns, syncSource.name)
+	configMapDeleted.Set(1)
 }
 
 func (syncSource *kubeAPISyncSource) onUpdate(_, obj interface{}) {
@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// informerlessSource is a kubeAPISyncSource whose events are injected by
+// the test rather than by an informer.
+type informerlessSource struct {
+	*kubeAPISyncSource
+}
+
+func (s informerlessSource) Periodic() <-chan syncResult {
+	return s.channel
+}
+
+func configMapDeletedValue() float64 {
+	m := &dto.Metric{}
+	configMapDeleted.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestConfigMapDeleted(t *testing.T) {
+	source := &kubeAPISyncSource{ns: "kube-system", name: "kube-dns", channel: make(chan syncResult)}
+	configs := newSync(informerlessSource{source}).Periodic()
+
+	configMap := func(version, stubDomains string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system", ResourceVersion: version},
+			Data:       map[string]string{"stubDomains": stubDomains},
+		}
+	}
+	next := func() *Config {
+		select {
+		case config := <-configs:
+			return config
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+
+	cm := configMap("1", `{"acme.local": ["1.2.3.4"]}`)
+	go source.onAdd(cm)
+	config := next()
+	if config == nil {
+		t.Fatalf("expected a config")
+	}
+	expected := map[string][]string{"acme.local": {"1.2.3.4"}}
+	if !reflect.DeepEqual(config.StubDomains, expected) {
+		t.Fatalf("expected stubDomains %v, got %v", expected, config.StubDomains)
+	}
+
+	// Deleting the ConfigMap keeps the configuration.
+	source.onDelete(cm)
+	if config := next(); config != nil {
+		t.Fatalf("expected no config update, got %+v", config)
+	}
+	if v := configMapDeletedValue(); v != 1 {
+		t.Errorf("expected kubedns_configmap_deleted 1, got %v", v)
+	}
+
+	// Recreating it resumes the sync.
+	go source.onAdd(configMap("3", `{"globex.local": ["1.2.3.5"]}`))
+	config = next()
+	if config == nil {
+		t.Fatalf("expected a config")
+	}
+	expected = map[string][]string{"globex.local": {"1.2.3.5"}}
+	if !reflect.DeepEqual(config.StubDomains, expected) {
+		t.Fatalf("expected stubDomains %v, got %v", expected, config.StubDomains)
+	}
+	if v := configMapDeletedValue(); v != 0 {
+		t.Errorf("expected kubedns_configmap_deleted 0, got %v", v)
+	}
+}
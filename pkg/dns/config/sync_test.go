@@ -1,7 +1,10 @@
 package config
 
+import "fmt"
+import "strings"
 import "testing"
 import "reflect"
+import "time"
 
 func TestEmptyInitialSync(t *testing.T) {
 	// New mock source that returns empty results, but not errors
@@ -20,3 +23,179 @@ func TestEmptyInitialSync(t *testing.T) {
 		t.Fatalf("expected default config, got %#v", config)
 	}
 }
+
+func TestQueryACLsSync(t *testing.T) {
+	mockSource := newMockSource(syncResult{
+		Version: "1",
+		Data: map[string]string{
+			"queryACLs": `{"10.1.0.0/16": ["acme.local"]}`,
+		},
+	}, nil)
+	s := newSync(mockSource)
+
+	config, err := s.Once()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{"10.1.0.0/16": {"acme.local"}}
+	if !reflect.DeepEqual(config.QueryACLs, expected) {
+		t.Fatalf("expected queryACLs %v, got %v", expected, config.QueryACLs)
+	}
+
+	for _, value := range []string{`not json`, `{"10.1.0.0": ["acme.local"]}`} {
+		mockSource = newMockSource(syncResult{
+			Version: "1",
+			Data:    map[string]string{"queryACLs": value},
+		}, nil)
+		_, err := newSync(mockSource).Once()
+		if _, ok := err.(*InvalidConfigError); !ok {
+			t.Errorf("expected InvalidConfigError for queryACLs %q, got %v", value, err)
+		}
+	}
+}
+
+func TestRecordTTLsSync(t *testing.T) {
+	mockSource := newMockSource(syncResult{
+		Version: "1",
+		Data:    map[string]string{"recordTTLs": `{"A": 5, "SRV": 3600}`},
+	}, nil)
+
+	config, err := newSync(mockSource).Once()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]uint32{"A": 5, "SRV": 3600}
+	if !reflect.DeepEqual(config.RecordTTLs, expected) {
+		t.Fatalf("expected recordTTLs %v, got %v", expected, config.RecordTTLs)
+	}
+
+	mockSource = newMockSource(syncResult{
+		Version: "1",
+		Data:    map[string]string{"recordTTLs": `{"BOGUS": 5}`},
+	}, nil)
+	if _, err := newSync(mockSource).Once(); err == nil {
+		t.Errorf("expected error for unknown record type")
+	}
+}
+
+func TestSourceErrorSync(t *testing.T) {
+	// Errors reading the configuration are not InvalidConfigErrors.
+	mockSource := newMockSource(syncResult{}, fmt.Errorf("configmaps \"kube-dns\" not found"))
+	_, err := newSync(mockSource).Once()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if _, ok := err.(*InvalidConfigError); ok {
+		t.Errorf("expected source error, got %v", err)
+	}
+}
+
+func TestOnApply(t *testing.T) {
+	mockSource := newMockSource(syncResult{
+		Version: "1",
+		Data:    map[string]string{"upstreamNameservers": `["8.8.8.8"]`},
+	}, nil)
+	s := newSync(mockSource)
+
+	type call struct {
+		callback string
+		config   *Config
+	}
+	calls := make(chan call, 10)
+	s.OnApply(func(config *Config) { calls <- call{"first", config} })
+	s.OnApply(func(config *Config) { calls <- call{"second", config} })
+
+	expectCalls := func(config *Config) {
+		for _, callback := range []string{"first", "second"} {
+			select {
+			case c := <-calls:
+				if c.callback != callback || c.config != config {
+					t.Errorf("expected %v callback with %+v, got %v with %+v",
+						callback, config, c.callback, c.config)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the %v callback", callback)
+			}
+		}
+	}
+
+	config, err := s.Once()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(config)
+
+	ch := s.Periodic()
+	// Invalid updates are not applied.
+	mockSource.ch <- syncResult{
+		Version: "2",
+		Data:    map[string]string{"upstreamNameservers": `["not-an-ip"]`},
+	}
+	mockSource.ch <- syncResult{
+		Version: "3",
+		Data:    map[string]string{"upstreamNameservers": `["9.9.9.9"]`},
+	}
+	config = <-ch
+	if !reflect.DeepEqual(config.UpstreamNameservers, []string{"9.9.9.9"}) {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	expectCalls(config)
+	if len(calls) != 0 {
+		t.Errorf("unexpected callback %+v", <-calls)
+	}
+}
+
+func TestInvalidValueSync(t *testing.T) {
+	oversized := `["8.8.8.8"` + strings.Repeat(` `, maxValueSize) + `]`
+	for _, value := range []string{oversized, `["8.8.8.8"`} {
+		mockSource := newMockSource(syncResult{
+			Version: "1",
+			Data:    map[string]string{"upstreamNameservers": value},
+		}, nil)
+		_, err := newSync(mockSource).Once()
+		if _, ok := err.(*InvalidConfigError); !ok {
+			t.Errorf("expected InvalidConfigError for a %d bytes value, got %v", len(value), err)
+		}
+	}
+
+	mockSource := newMockSource(syncResult{
+		Version: "1",
+		Data:    map[string]string{"upstreamNameservers": `["8.8.8.8"]`},
+	}, nil)
+	s := newSync(mockSource)
+	if _, err := s.Once(); err != nil {
+		t.Fatal(err)
+	}
+	applied := make(chan *Config, 10)
+	s.OnApply(func(config *Config) { applied <- config })
+
+	// The oversized and malformed updates are dropped, so the last good
+	// configuration is kept until the next valid update.
+	ch := s.Periodic()
+	mockSource.ch <- syncResult{
+		Version: "2",
+		Data:    map[string]string{"upstreamNameservers": oversized},
+	}
+	mockSource.ch <- syncResult{
+		Version: "3",
+		Data:    map[string]string{"upstreamNameservers": `["8.8.4.4"`},
+	}
+	mockSource.ch <- syncResult{
+		Version: "4",
+		Data:    map[string]string{"upstreamNameservers": `["9.9.9.9"]`},
+	}
+	select {
+	case config := <-ch:
+		if !reflect.DeepEqual(config.UpstreamNameservers, []string{"9.9.9.9"}) {
+			t.Fatalf("unexpected config %+v", config)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the valid update")
+	}
+	if config := <-applied; !reflect.DeepEqual(config.UpstreamNameservers, []string{"9.9.9.9"}) {
+		t.Errorf("unexpected applied config %+v", config)
+	}
+	if len(applied) != 0 {
+		t.Errorf("unexpected applied config %+v", <-applied)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/clock"
+	"k8s.io/client-go/pkg/watch"
+	kcache "k8s.io/client-go/tools/cache"
+)
+
+// apiConnection tracks the connection to the API server from the outcome
+// of the list and watch calls of the informers. While the calls fail,
+// the informers keep their stores and the records keep being served, but
+// they may be stale. The calls have to fail for grace before the
+// connection is reported as lost.
+type apiConnection struct {
+	clock clock.Clock
+	grace time.Duration
+	// synced returns whether the records have been synced from the API
+	// server, i.e. whether there are records to go stale.
+	synced func() bool
+
+	// lock protects failingSince and disconnected.
+	lock sync.Mutex
+	// failingSince is when the calls started failing, zero if the last
+	// one succeeded.
+	failingSince time.Time
+	// disconnected is whether the connection is reported as lost.
+	disconnected bool
+}
+
+func newAPIConnection(clock clock.Clock, synced func() bool) *apiConnection {
+	apiConnected.Set(1)
+	apiServingStale.Set(0)
+	return &apiConnection{clock: clock, synced: synced}
+}
+
+// listWatch returns lw, tracking the outcome of its calls.
+func (c *apiConnection) listWatch(lw *kcache.ListWatch) *kcache.ListWatch {
+	return &kcache.ListWatch{
+		ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+			obj, err := lw.ListFunc(options)
+			c.update(err)
+			return obj, err
+		},
+		WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+			w, err := lw.WatchFunc(options)
+			c.update(err)
+			return w, err
+		},
+	}
+}
+
+// update the connection state with the outcome of a call.
+func (c *apiConnection) update(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err == nil {
+		if c.disconnected {
+			glog.V(0).Infof("Reconnected to the API server after %v",
+				c.clock.Since(c.failingSince))
+		}
+		c.failingSince = time.Time{}
+		c.disconnected = false
+		apiConnected.Set(1)
+		apiServingStale.Set(0)
+		return
+	}
+
+	now := c.clock.Now()
+	if c.failingSince.IsZero() {
+		c.failingSince = now
+	}
+	if c.disconnected || now.Sub(c.failingSince) < c.grace {
+		return
+	}
+	c.disconnected = true
+	apiConnected.Set(0)
+	if c.synced() {
+		glog.Warningf("Lost the connection to the API server (%v), serving the last known records", err)
+		apiServingStale.Set(1)
+	} else {
+		glog.Warningf("Lost the connection to the API server (%v)", err)
+	}
+}
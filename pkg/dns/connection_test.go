@@ -0,0 +1,157 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/clock"
+	"k8s.io/client-go/pkg/watch"
+	core "k8s.io/client-go/testing"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+func gaugeValue(g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	g.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+// waitFor polls cond until it is true, or fails t after 10s.
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %v", msg)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestAPIDisconnect(t *testing.T) {
+	service := newService(testNamespace, "mysvc", "10.0.0.10", "", 80)
+	client := fake.NewSimpleClientset(service)
+
+	// While failing is set, the list and watch calls fail as if the API
+	// server was unreachable.
+	var lock sync.Mutex
+	failing := false
+	var watchers []*watch.FakeWatcher
+	client.PrependReactor("list", "*", func(core.Action) (bool, runtime.Object, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failing {
+			return true, nil, errors.New("connection refused")
+		}
+		return false, nil, nil
+	})
+	client.PrependWatchReactor("*", func(core.Action) (bool, watch.Interface, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failing {
+			return true, nil, errors.New("connection refused")
+		}
+		w := watch.NewFake()
+		watchers = append(watchers, w)
+		return true, w, nil
+	})
+
+	kd := NewKubeDNS(client, testDomain, time.Second, config.NewMockSync(config.NewDefaultConfig(), nil))
+	kd.config = config.NewDefaultConfig()
+	kd.SetAPIDisconnectGrace(0)
+	stop := make(chan struct{})
+	defer close(stop)
+	go kd.serviceController.Run(stop)
+	go kd.endpointsController.Run(stop)
+	waitFor(t, kd.HasSynced, "the initial sync")
+	assert.Equal(t, float64(1), gaugeValue(apiConnected))
+	assert.Equal(t, float64(0), gaugeValue(apiServingStale))
+
+	fqdn := "mysvc." + testNamespace + ".svc." + testDomain
+	records, err := kd.Records(fqdn, false)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	// The API server goes away: the watches end and the relists fail.
+	lock.Lock()
+	failing = true
+	for _, w := range watchers {
+		w.Stop()
+	}
+	watchers = nil
+	lock.Unlock()
+	waitFor(t, func() bool { return gaugeValue(apiConnected) == 0 }, "the disconnect")
+	assert.Equal(t, float64(1), gaugeValue(apiServingStale))
+
+	// The last known records are still served.
+	records, err = kd.Records(fqdn, false)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "10.0.0.10", records[0].Host)
+
+	// Once the API server is back, the metrics revert.
+	lock.Lock()
+	failing = false
+	lock.Unlock()
+	waitFor(t, func() bool { return gaugeValue(apiConnected) == 1 }, "the reconnect")
+	assert.Equal(t, float64(0), gaugeValue(apiServingStale))
+}
+
+func TestAPIDisconnectGrace(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	synced := true
+	c := newAPIConnection(fakeClock, func() bool { return synced })
+	c.grace = 10 * time.Second
+
+	// Failures within the grace are not reported.
+	c.update(errors.New("connection refused"))
+	fakeClock.Step(5 * time.Second)
+	c.update(errors.New("connection refused"))
+	assert.Equal(t, float64(1), gaugeValue(apiConnected))
+	assert.Equal(t, float64(0), gaugeValue(apiServingStale))
+
+	fakeClock.Step(5 * time.Second)
+	c.update(errors.New("connection refused"))
+	assert.Equal(t, float64(0), gaugeValue(apiConnected))
+	assert.Equal(t, float64(1), gaugeValue(apiServingStale))
+
+	// A success resets the grace.
+	c.update(nil)
+	assert.Equal(t, float64(1), gaugeValue(apiConnected))
+	assert.Equal(t, float64(0), gaugeValue(apiServingStale))
+	c.update(errors.New("connection refused"))
+	assert.Equal(t, float64(1), gaugeValue(apiConnected))
+
+	// Without synced records, nothing is stale.
+	synced = false
+	fakeClock.Step(10 * time.Second)
+	c.update(errors.New("connection refused"))
+	assert.Equal(t, float64(0), gaugeValue(apiConnected))
+	assert.Equal(t, float64(0), gaugeValue(apiServingStale))
+	c.update(nil)
+}
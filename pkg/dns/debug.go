@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	skymsg "github.com/skynetservices/skydns/msg"
+
+	"k8s.io/dns/pkg/dns/util"
+)
+
+// NameRecords are the records held in the cache for a name.
+type NameRecords struct {
+	Name string `json:"name"`
+	// Source of the records, if the name belongs to a service.
+	Source *RecordSource `json:"source,omitempty"`
+	// Records under the name.
+	Records []skymsg.Service `json:"records"`
+}
+
+// RecordSource identifies the object that records were generated from.
+type RecordSource struct {
+	// Kind is "Service" for records generated from a service and
+	// "Endpoints" for records generated from the endpoints of a headless
+	// service.
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// CachedRecords returns the records held in the cache for name and all
+// names below it. It returns false if there are none.
+func (kd *KubeDNS) CachedRecords(name string) (*NameRecords, bool) {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	path := util.ReverseArray(strings.Split(strings.TrimRight(name, "."), "."))
+
+	kd.cacheLock.RLock()
+	records := kd.cache.GetValuesForPathWithWildcards(path...)
+	kd.cacheLock.RUnlock()
+	if len(records) == 0 {
+		return nil, false
+	}
+
+	result := &NameRecords{Name: name, Source: kd.recordSource(path)}
+	for _, record := range records {
+		result.Records = append(result.Records, *record)
+	}
+	return result, true
+}
+
+// recordSource returns the source of the records at path, which is of the
+// form <domain path>/svc/<namespace>/<service>/...
+func (kd *KubeDNS) recordSource(path []string) *RecordSource {
+	namespace, name, ok := kd.serviceOfPath(path)
+	if !ok {
+		return nil
+	}
+	source := &RecordSource{Kind: "Service", Namespace: namespace, Name: name}
+	if kd.isHeadlessService(namespace, name) {
+		source.Kind = "Endpoints"
+	}
+	return source
+}
+
+// HandleRecords serves the cached records for the name given by the
+// "name" query parameter as JSON.
+func (kd *KubeDNS) HandleRecords(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "missing name parameter", http.StatusBadRequest)
+		return
+	}
+
+	records, ok := kd.CachedRecords(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no records for %q", name), http.StatusNotFound)
+		return
+	}
+
+	buf, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		glog.Errorf("Error serializing records for %q: %v", name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getRecords(kd *KubeDNS, name string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/admin/records?name="+name, nil)
+	w := httptest.NewRecorder()
+	kd.HandleRecords(w, req)
+	return w
+}
+
+func TestHandleRecords(t *testing.T) {
+	kd := newKubeDNS()
+
+	s := newService(testNamespace, testService, "1.2.3.4", "http", 80)
+	kd.servicesStore.Add(s)
+	kd.newService(s)
+
+	headless := newHeadlessService()
+	headless.Name = "headless"
+	endpoints := newEndpoints(headless, newSubsetWithOnePort("", 80, "10.0.0.1", "10.0.0.2"))
+	kd.servicesStore.Add(headless)
+	kd.endpointsStore.Add(endpoints)
+	kd.newService(headless)
+	kd.handleEndpointAdd(endpoints)
+
+	for _, testCase := range []struct {
+		name   string
+		source RecordSource
+		hosts  []string
+	}{
+		{
+			name:   testService + "." + testNamespace + ".svc.cluster.local.",
+			source: RecordSource{Kind: "Service", Namespace: testNamespace, Name: testService},
+			hosts:  []string{"1.2.3.4"},
+		},
+		{
+			// The same name without a trailing dot.
+			name:   testService + "." + testNamespace + ".svc.cluster.local",
+			source: RecordSource{Kind: "Service", Namespace: testNamespace, Name: testService},
+			hosts:  []string{"1.2.3.4"},
+		},
+		{
+			name:   headless.Name + "." + testNamespace + ".svc.cluster.local.",
+			source: RecordSource{Kind: "Endpoints", Namespace: testNamespace, Name: headless.Name},
+			hosts:  []string{"10.0.0.1", "10.0.0.2"},
+		},
+	} {
+		w := getRecords(kd, testCase.name)
+		require.Equal(t, http.StatusOK, w.Code, testCase.name)
+
+		var records NameRecords
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &records), testCase.name)
+		require.NotNil(t, records.Source, testCase.name)
+		assert.Equal(t, testCase.source, *records.Source, testCase.name)
+
+		var hosts []string
+		for _, record := range records.Records {
+			hosts = append(hosts, record.Host)
+		}
+		sort.Strings(hosts)
+		assert.Equal(t, testCase.hosts, hosts, testCase.name)
+	}
+
+	assert.Equal(t, http.StatusNotFound,
+		getRecords(kd, "missing."+testNamespace+".svc.cluster.local.").Code)
+	assert.Equal(t, http.StatusBadRequest, getRecords(kd, "").Code)
+}
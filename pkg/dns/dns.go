@@ -27,6 +27,7 @@ import (
 	"k8s.io/client-go/pkg/api/v1"
 	metav1 "k8s.io/client-go/pkg/apis/meta/v1"
 	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/clock"
 	"k8s.io/client-go/pkg/watch"
 	kcache "k8s.io/client-go/tools/cache"
 
@@ -119,6 +120,65 @@ type KubeDNS struct {
 
 	// Initial timeout for endpoints and services to be synced from APIServer
 	initialSyncTimeout time.Duration
+
+	// emptyEndpointResponse for headless services without ready endpoints.
+	emptyEndpointResponse EmptyEndpointResponse
+	// headlessEmptyGrace is how long a headless service that loses all of
+	// its ready endpoints keeps its previous records.
+	headlessEmptyGrace time.Duration
+	// headlessEmptySince is when the headless services within
+	// headlessEmptyGrace lost their endpoints, by namespace/name.
+	// Protected by cacheLock.
+	headlessEmptySince map[string]time.Time
+
+	// maxRecords is the maximum number of records in the cache, 0 if
+	// there is no limit.
+	maxRecords int
+	// serviceRecords is the number of records in the cache for each
+	// service (by namespace/name). Protected by cacheLock.
+	serviceRecords map[string]int
+	// records is the total of serviceRecords. Protected by cacheLock.
+	records int
+	// serviceTXT holds the TXT records from the TXTAnnotation of services
+	// by (lower-cased) service FQDN. Protected by cacheLock.
+	serviceTXT map[string][]skymsg.Service
+	// strictConfig makes an invalid initial configuration fatal.
+	strictConfig bool
+	// clock for the config_age_seconds metric.
+	clock clock.Clock
+	// bootstrapService is the placeholder for the kubernetes service
+	// until the actual service is seen, or nil. Protected by cacheLock.
+	bootstrapService *v1.Service
+	// apiConnection tracks the connection to the API server for the
+	// api_connected and serving_stale metrics.
+	apiConnection *apiConnection
+}
+
+// EmptyEndpointResponse is the response to a query for a headless service
+// that has no ready endpoints. Services with a ClusterIP always resolve to
+// the ClusterIP.
+type EmptyEndpointResponse string
+
+const (
+	// EmptyEndpointNXDomain answers NXDOMAIN, as if the service did not
+	// exist. Resolvers cache the NXDOMAIN for the SOA minimum TTL for the
+	// name and, per RFC 8020, possibly for the names below it as well.
+	EmptyEndpointNXDomain EmptyEndpointResponse = "nxdomain"
+	// EmptyEndpointNoData answers NOERROR with no records. Resolvers cache
+	// the empty answer for the SOA minimum TTL, but only for the queried
+	// name and type, so SRV and pod records of the service are unaffected.
+	EmptyEndpointNoData EmptyEndpointResponse = "nodata"
+)
+
+// ValidateEmptyEndpointResponse returns an error if response is not one of
+// the EmptyEndpointResponse values.
+func ValidateEmptyEndpointResponse(response string) error {
+	switch EmptyEndpointResponse(response) {
+	case EmptyEndpointNXDomain, EmptyEndpointNoData:
+		return nil
+	}
+	return fmt.Errorf("invalid empty endpoint response %q, must be %q or %q",
+		response, EmptyEndpointNXDomain, EmptyEndpointNoData)
 }
 
 func NewKubeDNS(client clientset.Interface, clusterDomain string, timeout time.Duration, configSync config.Sync) *KubeDNS {
@@ -135,7 +195,13 @@ func NewKubeDNS(client clientset.Interface, clusterDomain string, timeout time.D
 
 		configLock: sync.RWMutex{},
 		configSync: configSync,
+
+		emptyEndpointResponse: EmptyEndpointNXDomain,
+		serviceRecords:        make(map[string]int),
+		serviceTXT:            make(map[string][]skymsg.Service),
+		clock:                 clock.RealClock{},
 	}
+	kd.apiConnection = newAPIConnection(kd.clock, kd.HasSynced)
 
 	kd.setEndpointsStore()
 	kd.setServicesStore()
@@ -143,6 +209,111 @@ func NewKubeDNS(client clientset.Interface, clusterDomain string, timeout time.D
 	return kd
 }
 
+// SetEmptyEndpointResponse sets the response to queries for headless
+// services without ready endpoints. It must be called before Start().
+func (kd *KubeDNS) SetEmptyEndpointResponse(response EmptyEndpointResponse) {
+	kd.emptyEndpointResponse = response
+}
+
+// SetHeadlessEmptyGrace makes a headless service that loses all of its
+// ready endpoints keep resolving to its previous endpoints for grace,
+// e.g. during a rollout, before emptyEndpointResponse applies. 0 means
+// the records are dropped immediately. It must be called before Start().
+func (kd *KubeDNS) SetHeadlessEmptyGrace(grace time.Duration) {
+	kd.headlessEmptyGrace = grace
+	kd.headlessEmptySince = make(map[string]time.Time)
+}
+
+// SetAPIDisconnectGrace sets how long the list and watch calls to the API
+// server have to fail before the connection is reported as lost by the
+// api_connected metric. The last known records are served throughout. It
+// must be called before Start().
+func (kd *KubeDNS) SetAPIDisconnectGrace(grace time.Duration) {
+	kd.apiConnection.grace = grace
+}
+
+// SetStrictConfig makes Start() exit if the initial configuration is
+// invalid, rather than starting with the default configuration. Errors
+// reading the configuration (e.g. a missing ConfigMap) are not fatal,
+// and invalid updates are always ignored. It must be called before
+// Start().
+func (kd *KubeDNS) SetStrictConfig(strict bool) {
+	kd.strictConfig = strict
+}
+
+// SetMaxRecords sets a limit on the number of records in the cache. Once
+// it is reached, records of new services or of services that grow are not
+// added. 0 means no limit. It must be called before Start().
+func (kd *KubeDNS) SetMaxRecords(maxRecords int) {
+	kd.maxRecords = maxRecords
+}
+
+// SetBootstrapKubernetesIP adds a record for the kubernetes API service,
+// kubernetes.default.svc, with the ClusterIP ip, so that pods can reach
+// the API server while the services are being synced. The record is
+// superseded by the actual service once it is seen. It must be called
+// before Start().
+func (kd *KubeDNS) SetBootstrapKubernetesIP(ip string) {
+	service := &v1.Service{
+		ObjectMeta: v1.ObjectMeta{Name: "kubernetes", Namespace: v1.NamespaceDefault},
+		Spec: v1.ServiceSpec{
+			ClusterIP: ip,
+			Ports:     []v1.ServicePort{{Name: "https", Protocol: v1.ProtocolTCP, Port: 443}},
+		},
+	}
+	glog.V(0).Infof("Serving %v for the kubernetes service until it is synced", ip)
+	kd.newPortalService(service)
+
+	kd.cacheLock.Lock()
+	defer kd.cacheLock.Unlock()
+	kd.bootstrapService = service
+}
+
+// supersedeBootstrapService drops the bootstrap record for the kubernetes
+// service if service is the actual one. Its records are replaced by those
+// of service; only the reverse record of the bootstrap IP is removed.
+func (kd *KubeDNS) supersedeBootstrapService(service *v1.Service) {
+	kd.cacheLock.Lock()
+	defer kd.cacheLock.Unlock()
+	bootstrap := kd.bootstrapService
+	if bootstrap == nil || service.Namespace != bootstrap.Namespace || service.Name != bootstrap.Name {
+		return
+	}
+	glog.V(0).Infof("Kubernetes service synced, dropping the bootstrap record")
+	kd.bootstrapService = nil
+	if service.Spec.ClusterIP != bootstrap.Spec.ClusterIP {
+		delete(kd.reverseRecordMap, bootstrap.Spec.ClusterIP)
+		delete(kd.clusterIPServiceMap, bootstrap.Spec.ClusterIP)
+	}
+}
+
+// setServiceRecords updates the record count of service to n. It returns
+// false if the count would exceed maxRecords, in which case the records
+// must not be added. Assumes that we already have the cacheLock.
+func (kd *KubeDNS) setServiceRecords(service *v1.Service, n int) bool {
+	key := service.Namespace + "/" + service.Name
+	records := kd.records - kd.serviceRecords[key] + n
+	if kd.maxRecords > 0 && records > kd.maxRecords && n > kd.serviceRecords[key] {
+		glog.Errorf("Not adding %d records for service %q: the cache would have %d records,"+
+			" more than --max-records=%d", n, key, records, kd.maxRecords)
+		recordLimitExceeded.Inc()
+		return false
+	}
+	kd.records = records
+	kd.serviceRecords[key] = n
+	recordCount.Set(float64(kd.records))
+	return true
+}
+
+// removeServiceRecords removes the record count of service. Assumes that
+// we already have the cacheLock.
+func (kd *KubeDNS) removeServiceRecords(service *v1.Service) {
+	key := service.Namespace + "/" + service.Name
+	kd.records -= kd.serviceRecords[key]
+	delete(kd.serviceRecords, key)
+	recordCount.Set(float64(kd.records))
+}
+
 func (kd *KubeDNS) Start() {
 	glog.V(2).Infof("Starting endpointsController")
 	go kd.endpointsController.Run(wait.NeverStop)
@@ -157,6 +328,13 @@ func (kd *KubeDNS) Start() {
 	kd.waitForResourceSyncedOrDie()
 }
 
+// HasSynced returns whether the initial list of the services and
+// endpoints from the API server is complete.
+func (kd *KubeDNS) HasSynced() bool {
+	return kd.endpointsController != nil && kd.endpointsController.HasSynced() &&
+		kd.serviceController != nil && kd.serviceController.HasSynced()
+}
+
 func (kd *KubeDNS) waitForResourceSyncedOrDie() {
 	// Wait for both controllers have completed an initial resource listing
 	timeout := time.After(kd.initialSyncTimeout)
@@ -167,7 +345,7 @@ func (kd *KubeDNS) waitForResourceSyncedOrDie() {
 		case <-timeout:
 			glog.Fatalf("Timeout waiting for initialization")
 		case <-ticker.C:
-			if kd.endpointsController.HasSynced() && kd.serviceController.HasSynced() {
+			if kd.HasSynced() {
 				glog.V(0).Infof("Initialized services and endpoints from apiserver")
 				return
 			}
@@ -178,13 +356,18 @@ func (kd *KubeDNS) waitForResourceSyncedOrDie() {
 
 func (kd *KubeDNS) startConfigMapSync() {
 	initialConfig, err := kd.configSync.Once()
+	if _, invalid := err.(*config.InvalidConfigError); invalid && kd.strictConfig {
+		glog.Fatalf("Invalid initial configuration with --config-strict: %v", err)
+	}
 	if err != nil {
 		glog.Errorf(
 			"Error getting initial ConfigMap: %v, starting with default values", err)
-		kd.config = config.NewDefaultConfig()
-	} else {
-		kd.config = initialConfig
+		initialConfig = config.NewDefaultConfig()
 	}
+	kd.configLock.Lock()
+	kd.config = initialConfig
+	configApplied.set(kd.clock)
+	kd.configLock.Unlock()
 
 	go kd.syncConfigMap(kd.configSync.Periodic())
 }
@@ -195,11 +378,20 @@ func (kd *KubeDNS) syncConfigMap(syncChan <-chan *config.Config) {
 
 		kd.configLock.Lock()
 		kd.config = nextConfig
+		configApplied.set(kd.clock)
 		glog.V(2).Infof("Configuration updated: %+v", *kd.config)
 		kd.configLock.Unlock()
 	}
 }
 
+// Config returns the current configuration. The returned Config is
+// replaced, not modified, on updates and must not be modified.
+func (kd *KubeDNS) Config() *config.Config {
+	kd.configLock.RLock()
+	defer kd.configLock.RUnlock()
+	return kd.config
+}
+
 func (kd *KubeDNS) GetCacheAsJSON() (string, error) {
 	kd.cacheLock.RLock()
 	defer kd.cacheLock.RUnlock()
@@ -210,14 +402,14 @@ func (kd *KubeDNS) GetCacheAsJSON() (string, error) {
 func (kd *KubeDNS) setServicesStore() {
 	// Returns a cache.ListWatch that gets all changes to services.
 	kd.servicesStore, kd.serviceController = kcache.NewInformer(
-		&kcache.ListWatch{
+		kd.apiConnection.listWatch(&kcache.ListWatch{
 			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
 				return kd.kubeClient.Core().Services(v1.NamespaceAll).List(options)
 			},
 			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
 				return kd.kubeClient.Core().Services(v1.NamespaceAll).Watch(options)
 			},
-		},
+		}),
 		&v1.Service{},
 		resyncPeriod,
 		kcache.ResourceEventHandlerFuncs{
@@ -231,14 +423,14 @@ func (kd *KubeDNS) setServicesStore() {
 func (kd *KubeDNS) setEndpointsStore() {
 	// Returns a cache.ListWatch that gets all changes to endpoints.
 	kd.endpointsStore, kd.endpointsController = kcache.NewInformer(
-		&kcache.ListWatch{
+		kd.apiConnection.listWatch(&kcache.ListWatch{
 			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
 				return kd.kubeClient.Core().Endpoints(v1.NamespaceAll).List(options)
 			},
 			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
 				return kd.kubeClient.Core().Endpoints(v1.NamespaceAll).Watch(options)
 			},
-		},
+		}),
 		&v1.Endpoints{},
 		resyncPeriod,
 		kcache.ResourceEventHandlerFuncs{
@@ -263,6 +455,8 @@ func (kd *KubeDNS) newService(obj interface{}) {
 	if service, ok := assertIsService(obj); ok {
 		glog.V(2).Infof("New service: %v", service.Name)
 		glog.V(4).Infof("Service details: %v", service)
+		kd.supersedeBootstrapService(service)
+		kd.setServiceTXT(service)
 
 		// ExternalName services are a special kind that return CNAME records
 		if service.Spec.Type == v1.ServiceTypeExternalName {
@@ -291,6 +485,9 @@ func (kd *KubeDNS) removeService(obj interface{}) {
 		success := kd.cache.DeletePath(subCachePath...)
 		glog.V(2).Infof("removeService %v at path %v. Success: %v",
 			s.Name, subCachePath, success)
+		kd.removeServiceRecords(s)
+		kd.removeServiceTXT(s)
+		delete(kd.headlessEmptySince, s.Namespace+"/"+s.Name)
 
 		// ExternalName services have no IP
 		if v1.IsServiceIPSet(s) {
@@ -470,6 +667,9 @@ func (kd *KubeDNS) newPortalService(service *v1.Service) {
 
 	kd.cacheLock.Lock()
 	defer kd.cacheLock.Unlock()
+	if !kd.setServiceRecords(service, subCache.Size()) {
+		return
+	}
 	kd.cache.SetSubCache(service.Name, subCache, subCachePath...)
 	kd.reverseRecordMap[service.Spec.ClusterIP] = reverseRecord
 	kd.clusterIPServiceMap[service.Spec.ClusterIP] = service
@@ -477,7 +677,9 @@ func (kd *KubeDNS) newPortalService(service *v1.Service) {
 
 func (kd *KubeDNS) generateRecordsForHeadlessService(e *v1.Endpoints, svc *v1.Service) error {
 	subCache := treecache.NewTreeCache()
+	reverseRecords := make(map[string]*skymsg.Service)
 	glog.V(4).Infof("Endpoints Annotations: %v", e.Annotations)
+	weights := endpointWeights(svc)
 	for idx := range e.Subsets {
 		for subIdx := range e.Subsets[idx].Addresses {
 			address := &e.Subsets[idx].Addresses[subIdx]
@@ -486,11 +688,15 @@ func (kd *KubeDNS) generateRecordsForHeadlessService(e *v1.Endpoints, svc *v1.Se
 			if hostLabel, exists := getHostname(address); exists {
 				endpointName = hostLabel
 			}
+			if weights != nil {
+				recordValue.Weight = endpointWeight(weights, address)
+			}
 			subCache.SetEntry(endpointName, recordValue, kd.fqdn(svc, endpointName))
 			for portIdx := range e.Subsets[idx].Ports {
 				endpointPort := &e.Subsets[idx].Ports[portIdx]
 				if endpointPort.Name != "" && endpointPort.Protocol != "" {
 					srvValue := kd.generateSRVRecordValue(svc, int(endpointPort.Port), endpointName)
+					srvValue.Weight = recordValue.Weight
 					glog.V(2).Infof("Added SRV record %+v", srvValue)
 
 					l := []string{"_" + strings.ToLower(string(endpointPort.Protocol)), "_" + endpointPort.Name}
@@ -501,17 +707,67 @@ func (kd *KubeDNS) generateRecordsForHeadlessService(e *v1.Endpoints, svc *v1.Se
 			// Generate PTR records only for Named Headless service.
 			if _, has := getHostname(address); has {
 				reverseRecord, _ := util.GetSkyMsg(kd.fqdn(svc, endpointName), 0)
-				kd.reverseRecordMap[endpointIP] = reverseRecord
+				reverseRecords[endpointIP] = reverseRecord
 			}
 		}
 	}
 	subCachePath := append(kd.domainPath, serviceSubdomain, svc.Namespace)
 	kd.cacheLock.Lock()
 	defer kd.cacheLock.Unlock()
+	for ip, reverseRecord := range reverseRecords {
+		kd.reverseRecordMap[ip] = reverseRecord
+	}
+	if kd.keepDrainedHeadlessService(svc, subCache.Size()) {
+		return nil
+	}
+	if !kd.setServiceRecords(svc, subCache.Size()) {
+		return nil
+	}
 	kd.cache.SetSubCache(svc.Name, subCache, subCachePath...)
 	return nil
 }
 
+// keepDrainedHeadlessService returns true if the previous records of the
+// headless service svc are to be kept rather than replaced by n records:
+// n is 0, svc had records, and it lost its endpoints less than
+// headlessEmptyGrace ago. Assumes that we already have the cacheLock.
+func (kd *KubeDNS) keepDrainedHeadlessService(svc *v1.Service, n int) bool {
+	if kd.headlessEmptyGrace <= 0 {
+		return false
+	}
+	key := svc.Namespace + "/" + svc.Name
+	if n > 0 || kd.serviceRecords[key] == 0 {
+		delete(kd.headlessEmptySince, key)
+		return false
+	}
+	since, draining := kd.headlessEmptySince[key]
+	if !draining {
+		glog.V(2).Infof("Headless service %q has no ready endpoints, keeping its records for %v",
+			key, kd.headlessEmptyGrace)
+		kd.headlessEmptySince[key] = kd.clock.Now()
+		go kd.expireDrainedHeadlessService(key, kd.clock.After(kd.headlessEmptyGrace))
+		return true
+	}
+	if kd.clock.Since(since) < kd.headlessEmptyGrace {
+		return true
+	}
+	delete(kd.headlessEmptySince, key)
+	return false
+}
+
+// expireDrainedHeadlessService updates the records of the headless service
+// with key from its current endpoints once expired fires.
+func (kd *KubeDNS) expireDrainedHeadlessService(key string, expired <-chan time.Time) {
+	<-expired
+	obj, exists, err := kd.endpointsStore.GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+	if e, ok := obj.(*v1.Endpoints); ok {
+		kd.addDNSUsingEndpoints(e)
+	}
+}
+
 func getHostname(address *v1.EndpointAddress) (string, bool) {
 	if len(address.Hostname) > 0 {
 		return address.Hostname, true
@@ -565,6 +821,9 @@ func (kd *KubeDNS) newExternalNameService(service *v1.Service) {
 		service.Name, recordValue, fqdn, cachePath)
 	kd.cacheLock.Lock()
 	defer kd.cacheLock.Unlock()
+	if !kd.setServiceRecords(service, 1) {
+		return
+	}
 	// Store the service name directly as the leaf key
 	kd.cache.SetEntry(service.Name, recordValue, fqdn, cachePath...)
 }
@@ -603,8 +862,12 @@ func (kd *KubeDNS) Records(name string, exact bool) (retval []skymsg.Service, er
 	if isFederationQuery {
 		return kd.recordsForFederation(records, path, exact, federationSegments)
 	} else if len(records) > 0 {
+		weightedOrder(records)
 		glog.V(4).Infof("Records for %v: %v", name, records)
 		return records, nil
+	} else if kd.emptyEndpointResponse == EmptyEndpointNoData && kd.isHeadlessServicePath(path) {
+		glog.V(3).Infof("No ready endpoints for headless service %v", name)
+		return []skymsg.Service{}, nil
 	}
 
 	glog.V(3).Infof("No record found for %v", name)
@@ -705,6 +968,38 @@ func (kd *KubeDNS) getRecordsForPath(path []string, exact bool) ([]skymsg.Servic
 	return retval, nil
 }
 
+// isHeadlessServicePath returns true if path is the name of a headless
+// service, i.e. <service>.<namespace>.svc.<domain>.
+func (kd *KubeDNS) isHeadlessServicePath(path []string) bool {
+	namespace, name, ok := kd.serviceOfPath(path)
+	return ok && len(path) == len(kd.domainPath)+3 && kd.isHeadlessService(namespace, name)
+}
+
+// serviceOfPath returns the namespace and name of the service for a path
+// of the form <domain path>/svc/<namespace>/<service>/...
+func (kd *KubeDNS) serviceOfPath(path []string) (string, string, bool) {
+	if len(path) < len(kd.domainPath)+3 || path[len(kd.domainPath)] != serviceSubdomain {
+		return "", "", false
+	}
+	for i, label := range kd.domainPath {
+		if path[i] != label {
+			return "", "", false
+		}
+	}
+	return path[len(kd.domainPath)+1], path[len(kd.domainPath)+2], true
+}
+
+// isHeadlessService returns true if the service namespace/name is in the
+// services store and is headless.
+func (kd *KubeDNS) isHeadlessService(namespace, name string) bool {
+	obj, exists, err := kd.servicesStore.GetByKey(namespace + "/" + name)
+	if err != nil || !exists {
+		return false
+	}
+	service, ok := assertIsService(obj)
+	return ok && service.Spec.Type != v1.ServiceTypeExternalName && !v1.IsServiceIPSet(service)
+}
+
 // Returns true if the given record corresponds to a headless service.
 // Important: Assumes that we already have the cacheLock. Callers responsibility to acquire it.
 // This is because the code will panic, if we try to acquire it again if we already have it.
@@ -19,6 +19,8 @@ package dns
 import (
 	"fmt"
 	"net"
+	"os"
+	"os/exec"
 	"reflect"
 	"strings"
 	"sync"
@@ -27,6 +29,7 @@ import (
 
 	etcd "github.com/coreos/etcd/client"
 	"github.com/miekg/dns"
+	dto "github.com/prometheus/client_model/go"
 	skymsg "github.com/skynetservices/skydns/msg"
 	skyserver "github.com/skynetservices/skydns/server"
 	"github.com/stretchr/testify/assert"
@@ -35,12 +38,14 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/pkg/api/v1"
 	metav1 "k8s.io/client-go/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/util/clock"
 	"k8s.io/client-go/tools/cache"
 
 	"k8s.io/dns/pkg/dns/config"
 	"k8s.io/dns/pkg/dns/treecache"
 	"k8s.io/dns/pkg/dns/util"
 	"k8s.io/kubernetes/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/util/wait"
 )
 
 const (
@@ -63,10 +68,13 @@ func newKubeDNS() *KubeDNS {
 		reverseRecordMap:    make(map[string]*skymsg.Service),
 		clusterIPServiceMap: make(map[string]*v1.Service),
 		cacheLock:           sync.RWMutex{},
+		serviceRecords:      make(map[string]int),
+		serviceTXT:          make(map[string][]skymsg.Service),
 
 		config:     config.NewDefaultConfig(),
 		configLock: sync.RWMutex{},
 		configSync: config.NewNopSync(config.NewDefaultConfig()),
+		clock:      clock.RealClock{},
 	}
 }
 
@@ -343,6 +351,172 @@ func TestHeadlessServiceEndpointsUpdate(t *testing.T) {
 	assertNoReverseDNSForHeadlessService(t, kd, endpoints)
 }
 
+func TestHeadlessServiceWithoutReadyEndpoints(t *testing.T) {
+	for _, response := range []EmptyEndpointResponse{EmptyEndpointNXDomain, EmptyEndpointNoData} {
+		kd := newKubeDNS()
+		kd.SetEmptyEndpointResponse(response)
+
+		service := newHeadlessService()
+		assert.NoError(t, kd.servicesStore.Add(service))
+		// Only not-ready addresses.
+		subset := newSubsetWithOnePort("", 80)
+		subset.NotReadyAddresses = []v1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}
+		endpoints := newEndpoints(service, subset)
+		assert.NoError(t, kd.endpointsStore.Add(endpoints))
+		kd.newService(service)
+
+		clusterIPService := newService(testNamespace, "clusterip", "1.2.3.4", "", 80)
+		assert.NoError(t, kd.servicesStore.Add(clusterIPService))
+		kd.newService(clusterIPService)
+
+		records, err := kd.Records(getServiceFQDN(kd.domain, service), false)
+		switch response {
+		case EmptyEndpointNXDomain:
+			assert.Equal(t, etcd.Error{Code: etcd.ErrorCodeKeyNotFound}, err, string(response))
+		case EmptyEndpointNoData:
+			assert.NoError(t, err, string(response))
+		}
+		assert.Empty(t, records, string(response))
+
+		// Names below the service are not found in either mode.
+		_, err = kd.Records("ep-0."+getServiceFQDN(kd.domain, service), false)
+		assert.Error(t, err, string(response))
+		// Nor are services that do not exist.
+		_, err = kd.Records("missing."+testNamespace+".svc."+kd.domain, false)
+		assert.Error(t, err, string(response))
+
+		// The ClusterIP service resolves to its ClusterIP without endpoints.
+		assertDNSForClusterIP(t, kd, clusterIPService)
+	}
+}
+
+func TestHeadlessEmptyGrace(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	kd := newKubeDNS()
+	kd.clock = fakeClock
+	kd.SetHeadlessEmptyGrace(30 * time.Second)
+
+	service := newHeadlessService()
+	assert.NoError(t, kd.servicesStore.Add(service))
+	endpoints := newEndpoints(service, newSubsetWithOnePort("", 80, "10.0.0.1", "10.0.0.2"))
+	assert.NoError(t, kd.endpointsStore.Add(endpoints))
+	kd.newService(service)
+	assertDNSForHeadlessService(t, kd, endpoints)
+
+	// The endpoints are drained: the previous ones are still served.
+	drained := newEndpoints(service, newSubsetWithOnePort("", 80))
+	assert.NoError(t, kd.endpointsStore.Update(drained))
+	kd.handleEndpointUpdate(endpoints, drained)
+	assertDNSForHeadlessService(t, kd, endpoints)
+	fakeClock.Step(20 * time.Second)
+	kd.handleEndpointUpdate(drained, drained)
+	assertDNSForHeadlessService(t, kd, endpoints)
+
+	// Until the grace period is over.
+	fakeClock.Step(10 * time.Second)
+	assert.NoError(t, wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		_, err := kd.Records(getServiceFQDN(kd.domain, service), false)
+		return err != nil, nil
+	}))
+	assertNoDNSForHeadlessService(t, kd, service)
+
+	// New endpoints are served right away, and end the grace period.
+	updated := newEndpoints(service, newSubsetWithOnePort("", 80, "10.0.0.3"))
+	assert.NoError(t, kd.endpointsStore.Update(updated))
+	kd.handleEndpointUpdate(drained, updated)
+	assertDNSForHeadlessService(t, kd, updated)
+	kd.handleEndpointUpdate(updated, drained)
+	assertDNSForHeadlessService(t, kd, updated)
+	kd.handleEndpointUpdate(drained, endpoints)
+	assertDNSForHeadlessService(t, kd, endpoints)
+	fakeClock.Step(20 * time.Second)
+	kd.handleEndpointUpdate(endpoints, drained)
+	assertDNSForHeadlessService(t, kd, endpoints)
+
+	// Without a grace period, the records are dropped immediately.
+	kd = newKubeDNS()
+	assert.NoError(t, kd.servicesStore.Add(service))
+	kd.handleEndpointAdd(endpoints)
+	assertDNSForHeadlessService(t, kd, endpoints)
+	kd.handleEndpointUpdate(endpoints, drained)
+	assertNoDNSForHeadlessService(t, kd, service)
+}
+
+func TestMaxRecords(t *testing.T) {
+	kd := newKubeDNS()
+	kd.SetMaxRecords(3)
+	exceeded := func() float64 {
+		m := &dto.Metric{}
+		recordLimitExceeded.Write(m)
+		return m.GetCounter().GetValue()
+	}
+	exceededBefore := exceeded()
+
+	var services []*v1.Service
+	for i := 0; i < 4; i++ {
+		// Services with an unnamed port have a single A record.
+		s := newService(testNamespace, fmt.Sprintf("svc%d", i), fmt.Sprintf("1.2.3.%d", i), "", 80)
+		assert.NoError(t, kd.servicesStore.Add(s))
+		kd.newService(s)
+		services = append(services, s)
+	}
+	for _, s := range services[:3] {
+		assertDNSForClusterIP(t, kd, s)
+	}
+	assertNoDNSForClusterIP(t, kd, services[3])
+	assert.Equal(t, 3, kd.records)
+	assert.Equal(t, float64(1), exceeded()-exceededBefore)
+
+	// Updating a service in place is allowed at the limit.
+	kd.newService(services[0])
+	assertDNSForClusterIP(t, kd, services[0])
+	assert.Equal(t, float64(1), exceeded()-exceededBefore)
+
+	// Removing a service makes room for another.
+	kd.removeService(services[0])
+	kd.newService(services[3])
+	assertDNSForClusterIP(t, kd, services[3])
+	assert.Equal(t, 3, kd.records)
+}
+
+func TestBootstrapKubernetesIP(t *testing.T) {
+	kd := newKubeDNS()
+	kd.SetBootstrapKubernetesIP("10.0.0.1")
+
+	// The bootstrap record resolves before the service is synced.
+	bootstrap := newService(v1.NamespaceDefault, "kubernetes", "10.0.0.1", "https", 443)
+	assertDNSForClusterIP(t, kd, bootstrap)
+	assertReverseRecord(t, kd, bootstrap)
+	count := kd.records
+
+	// The actual service supersedes it.
+	service := newService(v1.NamespaceDefault, "kubernetes", "10.0.0.2", "https", 443)
+	assert.NoError(t, kd.servicesStore.Add(service))
+	kd.newService(service)
+	assertDNSForClusterIP(t, kd, service)
+	assertReverseRecord(t, kd, service)
+	assertNoReverseRecord(t, kd, bootstrap)
+	assert.Nil(t, kd.bootstrapService)
+	assert.Equal(t, count, kd.records)
+
+	// Other services do not affect the bootstrap record.
+	kd = newKubeDNS()
+	kd.SetBootstrapKubernetesIP("10.0.0.1")
+	kd.newService(newService("kube-system", "kubernetes", "10.0.0.3", "https", 443))
+	records, err := kd.Records(getServiceFQDN(kd.domain, bootstrap), false)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "10.0.0.1", records[0].Host)
+	assert.NotNil(t, kd.bootstrapService)
+}
+
+func TestValidateEmptyEndpointResponse(t *testing.T) {
+	assert.NoError(t, ValidateEmptyEndpointResponse("nxdomain"))
+	assert.NoError(t, ValidateEmptyEndpointResponse("nodata"))
+	assert.Error(t, ValidateEmptyEndpointResponse(""))
+	assert.Error(t, ValidateEmptyEndpointResponse("servfail"))
+}
+
 func TestNamedHeadlessServiceEndpointAdd(t *testing.T) {
 	kd := newKubeDNS()
 
@@ -702,6 +876,64 @@ func TestConfigSyncInitialMap(t *testing.T) {
 	checkConfigEqual(t, kd, &config.Config{Federations: map[string]string{"name3": "domain3"}})
 }
 
+func configAgeValue() float64 {
+	m := &dto.Metric{}
+	configAge.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestConfigAge(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	kd := newKubeDNS()
+	kd.clock = fakeClock
+	mockSync := config.NewMockSync(config.NewDefaultConfig(), nil)
+	kd.configSync = mockSync
+
+	kd.startConfigMapSync()
+	assert.Equal(t, float64(0), configAgeValue())
+	fakeClock.Step(30 * time.Second)
+	assert.Equal(t, float64(30), configAgeValue())
+
+	// Applying an update resets the age.
+	mockSync.Chan <- &config.Config{Federations: map[string]string{"name1": "domain1"}}
+	checkConfigEqual(t, kd, &config.Config{Federations: map[string]string{"name1": "domain1"}})
+	assert.Equal(t, float64(0), configAgeValue())
+	fakeClock.Step(5 * time.Second)
+	assert.Equal(t, float64(5), configAgeValue())
+}
+
+func TestConfigSyncStrict(t *testing.T) {
+	invalid := &config.InvalidConfigError{Err: fmt.Errorf("invalid stub domain")}
+	if os.Getenv("KUBE_DNS_TEST_CONFIG_STRICT") == "1" {
+		kd := newKubeDNS()
+		kd.configSync = config.NewMockSync(nil, invalid)
+		kd.SetStrictConfig(true)
+		kd.startConfigMapSync()
+		return
+	}
+
+	// An invalid initial configuration is fatal in strict mode.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestConfigSyncStrict$")
+	cmd.Env = append(os.Environ(), "KUBE_DNS_TEST_CONFIG_STRICT=1")
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.Success() {
+		t.Fatalf("expected nonzero exit, got %v", err)
+	}
+
+	// Errors reading the configuration are not.
+	kd := newKubeDNS()
+	kd.configSync = config.NewMockSync(nil, fmt.Errorf("configmaps \"kube-dns\" not found"))
+	kd.SetStrictConfig(true)
+	kd.startConfigMapSync()
+	checkConfigEqual(t, kd, config.NewDefaultConfig())
+
+	// Without strict mode, the defaults are used.
+	kd = newKubeDNS()
+	kd.configSync = config.NewMockSync(nil, invalid)
+	kd.startConfigMapSync()
+	checkConfigEqual(t, kd, config.NewDefaultConfig())
+}
+
 func newNodes() *v1.NodeList {
 	return &v1.NodeList{
 		Items: []v1.Node{
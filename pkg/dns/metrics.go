@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/client-go/pkg/util/clock"
+)
+
+const prometheusNamespace = "kubedns"
+
+var (
+	recordCount = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Name:      "records",
+			Help:      "Number of records in the record cache",
+		})
+
+	recordLimitExceeded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "record_limit_exceeded_total",
+			Help:      "Count of service updates whose records were not added because of --max-records",
+		})
+
+	configAge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Name:      "config_age_seconds",
+			Help:      "Seconds since the configuration being served was applied, 0 before the first one",
+		},
+		configApplied.age)
+
+	apiConnected = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Name:      "api_connected",
+			Help:      "1 if the API server is reachable, 0 once its list and watch calls have failed for longer than --api-disconnect-grace",
+		})
+
+	apiServingStale = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Name:      "serving_stale",
+			Help:      "1 while the last known records are served during an API server disconnect, 0 otherwise",
+		})
+)
+
+// configApplied is when the configuration being served was applied.
+var configApplied = &applyTime{}
+
+type applyTime struct {
+	lock    sync.Mutex
+	clock   clock.Clock
+	applied time.Time
+}
+
+// set the apply time to the current time of clock.
+func (a *applyTime) set(clock clock.Clock) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.clock = clock
+	a.applied = clock.Now()
+}
+
+// age returns the seconds since the apply time.
+func (a *applyTime) age() float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.clock == nil {
+		return 0
+	}
+	return a.clock.Since(a.applied).Seconds()
+}
+
+func init() {
+	prometheus.MustRegister(recordCount)
+	prometheus.MustRegister(recordLimitExceeded)
+	prometheus.MustRegister(configAge)
+	prometheus.MustRegister(apiConnected)
+	prometheus.MustRegister(apiServingStale)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// queryACL restricts which sources can query which zones. See
+// config.Config.QueryACLs.
+type queryACL struct {
+	// zones maps each restricted zone (lower-cased, fully qualified) to
+	// the networks allowed to query it.
+	zones map[string][]*net.IPNet
+}
+
+// newQueryACL builds a queryACL from the CIDR -> zones configuration.
+// Invalid CIDRs are skipped; the configuration is validated before it is
+// applied.
+func newQueryACL(acls map[string][]string) *queryACL {
+	acl := &queryACL{zones: make(map[string][]*net.IPNet)}
+	for cidr, zones := range acls {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			glog.Errorf("Invalid query ACL source %q: %v", cidr, err)
+			continue
+		}
+		for _, zone := range zones {
+			zone = strings.ToLower(dns.Fqdn(zone))
+			acl.zones[zone] = append(acl.zones[zone], ipNet)
+		}
+	}
+	return acl
+}
+
+// check returns whether ip may query name. If name is under a restricted
+// zone, the most specific zone is returned as well.
+func (acl *queryACL) check(ip net.IP, name string) (string, bool) {
+	zone, ok := acl.zoneOf(name)
+	if !ok {
+		return "", true
+	}
+	if ip == nil {
+		return zone, false
+	}
+	for _, ipNet := range acl.zones[zone] {
+		if ipNet.Contains(ip) {
+			return zone, true
+		}
+	}
+	return zone, false
+}
+
+// zoneOf returns the most specific restricted zone containing name.
+func (acl *queryACL) zoneOf(name string) (string, bool) {
+	return matchZone(name, func(zone string) bool {
+		_, ok := acl.zones[zone]
+		return ok
+	})
+}
+
+// matchZone returns the most specific zone containing name for which
+// isZone returns true. Zones are lower-cased and fully qualified.
+func matchZone(name string, isZone func(zone string) bool) (string, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	for {
+		if isZone(name) {
+			return name, true
+		}
+		i, end := dns.NextLabel(name, 0)
+		if end {
+			if isZone(".") {
+				return ".", true
+			}
+			return "", false
+		}
+		name = name[i:]
+	}
+}
+
+// remoteIP returns the IP address of addr.
+func remoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
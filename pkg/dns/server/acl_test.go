@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+// staticConfig is a ConfigSource returning a fixed configuration.
+type staticConfig struct {
+	config *config.Config
+}
+
+func (s *staticConfig) Config() *config.Config { return s.config }
+
+func TestQueryACL(t *testing.T) {
+	backend := &fakeBackend{records: map[string]string{
+		"www.acme.local.":            "10.0.0.1",
+		"www.public.acme.local.":     "10.0.0.2",
+		"a.ns.svc.cluster.local.":    "10.0.0.3",
+		"www.globex.local.":          "10.0.0.4",
+		"restricted.globex.local.":   "10.0.0.5",
+		"x.restricted.globex.local.": "10.0.0.6",
+	}}
+	source := &staticConfig{config: &config.Config{
+		QueryACLs: map[string][]string{
+			"10.1.0.0/16":    {"acme.local", "restricted.globex.local."},
+			"10.2.0.0/16":    {"ACME.local."},
+			"192.168.0.1/32": {"public.acme.local"},
+		},
+	}}
+	h := NewHandler(backend, Options{Domain: testDomain, Config: source})
+
+	for _, testCase := range []struct {
+		source  string
+		name    string
+		allowed bool
+	}{
+		{"10.1.2.3", "www.acme.local.", true},
+		{"10.2.2.3", "www.acme.local.", true},
+		{"10.3.2.3", "www.acme.local.", false},
+		{"10.3.2.3", "WWW.Acme.Local.", false},
+		// The most specific zone applies.
+		{"192.168.0.1", "www.public.acme.local.", true},
+		{"192.168.0.1", "www.acme.local.", false},
+		{"10.1.2.3", "www.public.acme.local.", false},
+		{"10.1.2.3", "x.restricted.globex.local.", true},
+		{"10.1.2.3", "restricted.globex.local.", true},
+		{"10.3.2.3", "x.restricted.globex.local.", false},
+		// Zones that are not listed are not restricted.
+		{"10.3.2.3", "www.globex.local.", true},
+		{"10.3.2.3", "a.ns.svc.cluster.local.", true},
+		{"fd00::1", "a.ns.svc.cluster.local.", true},
+		{"fd00::1", "www.acme.local.", false},
+	} {
+		resp := queryFrom(h, testCase.source, testCase.name, dns.TypeA)
+		if testCase.allowed {
+			assert.Equal(t, dns.RcodeSuccess, resp.Rcode, "%+v", testCase)
+			assert.Len(t, resp.Answer, 1, "%+v", testCase)
+		} else {
+			assert.Equal(t, dns.RcodeRefused, resp.Rcode, "%+v", testCase)
+			assert.Empty(t, resp.Answer, "%+v", testCase)
+		}
+	}
+}
+
+func TestQueryACLReload(t *testing.T) {
+	backend := &fakeBackend{records: map[string]string{"www.initech.local.": "10.0.0.1"}}
+	source := &staticConfig{config: config.NewDefaultConfig()}
+	h := NewHandler(backend, Options{Domain: testDomain, Config: source})
+
+	refused := func() float64 {
+		return counterValue(aclRefusedQueries.WithLabelValues("initech.local."))
+	}
+	before := refused()
+
+	assert.Equal(t, dns.RcodeSuccess, queryFrom(h, "10.3.0.1", "www.initech.local.", dns.TypeA).Rcode)
+
+	source.config = &config.Config{
+		QueryACLs: map[string][]string{"10.1.0.0/16": {"initech.local"}},
+	}
+	assert.Equal(t, dns.RcodeRefused, queryFrom(h, "10.3.0.1", "www.initech.local.", dns.TypeA).Rcode)
+	assert.Equal(t, dns.RcodeSuccess, queryFrom(h, "10.1.0.1", "www.initech.local.", dns.TypeA).Rcode)
+	assert.Equal(t, before+1, refused())
+
+	source.config = config.NewDefaultConfig()
+	assert.Equal(t, dns.RcodeSuccess, queryFrom(h, "10.3.0.1", "www.initech.local.", dns.TypeA).Rcode)
+	assert.Equal(t, before+1, refused())
+}
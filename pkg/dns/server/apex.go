@@ -0,0 +1,76 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// apexTTL of the SOA and NS records at the apex, the same as skydns uses
+// for its SOA.
+const apexTTL = 3600
+
+// serveApex answers queries for the apex of the cluster domain and for
+// the "svc" and "pod" names below it, which always exist but have no
+// records of their own. The apex has an SOA and NS records; other types
+// and names get NODATA rather than the NXDOMAIN skydns returns. It
+// returns false for other names.
+func (h *Handler) serveApex(w dns.ResponseWriter, req *dns.Msg) bool {
+	if len(req.Question) == 0 {
+		return false
+	}
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+	if q.Qclass != dns.ClassINET ||
+		(name != h.domain && name != "svc."+h.domain && name != "pod."+h.domain) {
+		return false
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	m.RecursionAvailable = true
+	switch {
+	case name == h.domain && q.Qtype == dns.TypeSOA:
+		m.Answer = append(m.Answer, h.newSOA())
+	case name == h.domain && q.Qtype == dns.TypeNS:
+		for _, ns := range h.soa.NSNames {
+			m.Answer = append(m.Answer, &dns.NS{
+				Hdr: dns.RR_Header{Name: h.domain, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: apexTTL},
+				Ns:  ns,
+			})
+		}
+	default:
+		m.Ns = append(m.Ns, h.newSOA())
+	}
+	w.WriteMsg(m)
+	return true
+}
+
+// newSOA returns the SOA of the cluster domain. The serial changes every
+// hour, like the one from skydns.
+func (h *Handler) newSOA() *dns.SOA {
+	soa := &dns.SOA{
+		Hdr:    dns.RR_Header{Name: h.domain, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: apexTTL},
+		Serial: uint32(time.Now().Truncate(time.Hour).Unix()),
+	}
+	h.updateSOA(soa)
+	return soa
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := runTestServer(ctx, t, Options{
+		SOA: SOAOptions{
+			MName:   "ns1.example.com",
+			NSNames: []string{"ns1.example.com", "ns2.example.com."},
+			Minimum: 5 * time.Second,
+		},
+	})
+	client := &dns.Client{Timeout: 5 * time.Second}
+	exchange := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		resp, _, err := client.Exchange(req, addrs.UDP.String())
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := exchange("Cluster.Local.", dns.TypeSOA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.True(t, resp.Authoritative)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "ns1.example.com.", resp.Answer[0].(*dns.SOA).Ns)
+
+	resp = exchange(testDomain, dns.TypeNS)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.True(t, resp.Authoritative)
+	var nsNames []string
+	for _, rr := range resp.Answer {
+		ns, ok := rr.(*dns.NS)
+		require.True(t, ok, "expected NS, got %v", rr)
+		assert.Equal(t, testDomain, ns.Hdr.Name)
+		nsNames = append(nsNames, ns.Ns)
+	}
+	assert.Equal(t, []string{"ns1.example.com.", "ns2.example.com."}, nsNames)
+
+	// Other types at the apex, and the names below it without records,
+	// have no data.
+	for _, testCase := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{testDomain, dns.TypeA},
+		{testDomain, dns.TypeMX},
+		{"svc." + testDomain, dns.TypeSOA},
+		{"svc." + testDomain, dns.TypeA},
+		{"pod." + testDomain, dns.TypeNS},
+	} {
+		resp := exchange(testCase.name, testCase.qtype)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode, "%+v", testCase)
+		assert.True(t, resp.Authoritative, "%+v", testCase)
+		assert.Empty(t, resp.Answer, "%+v", testCase)
+		require.Len(t, resp.Ns, 1, "%+v", testCase)
+		soa := resp.Ns[0].(*dns.SOA)
+		assert.Equal(t, testDomain, soa.Hdr.Name, "%+v", testCase)
+		assert.Equal(t, uint32(5), soa.Hdr.Ttl, "%+v", testCase)
+	}
+
+	// Names that do not exist are still NXDOMAIN.
+	assert.Equal(t, dns.RcodeNameError, exchange("missing.svc."+testDomain, dns.TypeA).Rcode)
+	assert.Equal(t, dns.RcodeSuccess, exchange("mysvc.default.svc."+testDomain, dns.TypeA).Rcode)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+)
+
+// benchServices is the number of synthetic services the benchmark
+// servers hold, so that lookups go through a populated record tree.
+const benchServices = 1000
+
+// syntheticServices returns count services with a ClusterIP, spread over
+// 10 namespaces: svc-<i>.ns-<i%10>.
+func syntheticServices(count int) []runtime.Object {
+	var objects []runtime.Object
+	for i := 0; i < count; i++ {
+		objects = append(objects, &v1.Service{
+			ObjectMeta: v1.ObjectMeta{
+				Name: fmt.Sprintf("svc-%d", i), Namespace: fmt.Sprintf("ns-%d", i%10)},
+			Spec: v1.ServiceSpec{
+				ClusterIP: fmt.Sprintf("10.2.%d.%d", i/256, i%256),
+				Ports:     []v1.ServicePort{{Name: "http", Port: 80, Protocol: "TCP"}},
+			},
+		})
+	}
+	return objects
+}
+
+// benchmarkQueries sends the query for name and qtype to the server at
+// addr over UDP from parallel clients, and reports the queries per
+// second and the allocations per query of the client and server.
+func benchmarkQueries(b *testing.B, addr, name string, qtype uint16, rcode int) {
+	b.ReportAllocs()
+	b.SetParallelism(8)
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		conn, err := dns.DialTimeout("udp", addr, 5*time.Second)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer conn.Close()
+
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		for pb.Next() {
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteMsg(req); err != nil {
+				b.Fatal(err)
+			}
+			resp, err := conn.ReadMsg()
+			if err != nil && err != dns.ErrTruncated {
+				b.Fatal(err)
+			}
+			if resp.Rcode != rcode {
+				b.Fatalf("unexpected response: %v", resp)
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "queries/s")
+}
+
+func BenchmarkResolveServiceA(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := runTestServer(ctx, b, Options{}, syntheticServices(benchServices)...)
+
+	benchmarkQueries(b, addrs.UDP.String(),
+		"svc-123.ns-3.svc.cluster.local.", dns.TypeA, dns.RcodeSuccess)
+}
+
+func BenchmarkResolveHeadlessSRV(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := runTestServer(ctx, b, Options{},
+		append(syntheticServices(benchServices), headlessService("headless", 5)...)...)
+
+	benchmarkQueries(b, addrs.UDP.String(),
+		"_http._tcp.headless.default.svc.cluster.local.", dns.TypeSRV, dns.RcodeSuccess)
+}
+
+func BenchmarkUpstreamForward(b *testing.B) {
+	upstream, stop := startUpstream(b, "192.0.2.1")
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := runTestServer(ctx, b, Options{Nameservers: []string{upstream}},
+		syntheticServices(benchServices)...)
+
+	benchmarkQueries(b, addrs.UDP.String(), "www.example.com.", dns.TypeA, dns.RcodeSuccess)
+}
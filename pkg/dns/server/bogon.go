@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// bogonWriter rejects forwarded responses with an address in one of the
+// deny networks, e.g. answers from a hijacking resolver pointing external
+// names to loopback or link-local addresses. They are replaced with
+// SERVFAIL rather than passed on to the client.
+type bogonWriter struct {
+	dns.ResponseWriter
+	req  *dns.Msg
+	deny []*net.IPNet
+}
+
+func (w *bogonWriter) WriteMsg(m *dns.Msg) error {
+	for _, rr := range m.Answer {
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		default:
+			continue
+		}
+		for _, network := range w.deny {
+			if network.Contains(ip) {
+				glog.V(2).Infof("Rejecting upstream answer for %q: %v is in %v",
+					w.req.Question[0].Name, ip, network)
+				upstreamRejectedAnswers.Inc()
+				return w.ResponseWriter.WriteMsg(serverFailure(w.req))
+			}
+		}
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamDenyNetworks(t *testing.T) {
+	hijacked, stop := startUpstream(t, "127.0.0.1")
+	defer stop()
+	upstream, stop := startUpstream(t, "192.0.2.1")
+	defer stop()
+
+	var deny []*net.IPNet
+	for _, cidr := range []string{"127.0.0.0/8", "169.254.0.0/16", "::1/128"} {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		deny = append(deny, network)
+	}
+
+	// Without deny networks, the answer is passed on.
+	h := NewHandler(&fakeBackend{}, Options{Domain: testDomain, Nameservers: []string{hijacked}})
+	resp := query(h, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "127.0.0.1", resp.Answer[0].(*dns.A).A.String())
+
+	before := counterValue(upstreamRejectedAnswers)
+	h = NewHandler(&fakeBackend{}, Options{
+		Domain:               testDomain,
+		Nameservers:          []string{hijacked},
+		UpstreamDenyNetworks: deny,
+	})
+	resp = query(h, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+	assert.Equal(t, float64(1), counterValue(upstreamRejectedAnswers)-before)
+
+	// Other addresses are not affected.
+	h = NewHandler(&fakeBackend{}, Options{
+		Domain:               testDomain,
+		Nameservers:          []string{upstream},
+		UpstreamDenyNetworks: deny,
+	})
+	resp = query(h, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "192.0.2.1", resp.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, float64(1), counterValue(upstreamRejectedAnswers)-before)
+}
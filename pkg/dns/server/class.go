@@ -0,0 +1,53 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// supportedClass returns whether queries of class qclass are answered.
+// IN queries are answered from the records or forwarded upstream, and
+// CHAOS queries (e.g. version.bind) are answered locally if enabled.
+func (h *Handler) supportedClass(qclass uint16) bool {
+	switch qclass {
+	case dns.ClassINET:
+		return true
+	case dns.ClassCHAOS:
+		return h.options.Chaos
+	}
+	return false
+}
+
+// checkQueryClass returns whether the class of the query is supported.
+// Queries of other classes (e.g. HESIOD or ANY) are answered with
+// REFUSED.
+func (h *Handler) checkQueryClass(w dns.ResponseWriter, req *dns.Msg) bool {
+	q := req.Question[0]
+	if h.supportedClass(q.Qclass) {
+		return true
+	}
+
+	glog.V(3).Infof("Refusing query for %q of class %v from %v",
+		q.Name, dns.Class(q.Qclass), w.RemoteAddr())
+	refusedClassQueries.Inc()
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeRefused)
+	w.WriteMsg(m)
+	return false
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryClass(t *testing.T) {
+	for _, chaos := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		addrs := runTestServer(ctx, t, Options{Chaos: chaos})
+
+		exchange := func(name string, qtype, qclass uint16) *dns.Msg {
+			req := new(dns.Msg)
+			req.Question = []dns.Question{{Name: name, Qtype: qtype, Qclass: qclass}}
+			client := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+			resp, _, err := client.Exchange(req, addrs.UDP.String())
+			require.NoError(t, err)
+			return resp
+		}
+		before := counterValue(refusedClassQueries)
+
+		resp := exchange("mysvc.default.svc.cluster.local.", dns.TypeA, dns.ClassINET)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Len(t, resp.Answer, 1)
+
+		resp = exchange("version.bind.", dns.TypeTXT, dns.ClassCHAOS)
+		if chaos {
+			assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+			assert.Len(t, resp.Answer, 1)
+		} else {
+			assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+		}
+
+		for _, qclass := range []uint16{dns.ClassHESIOD, dns.ClassANY, 42} {
+			resp = exchange("mysvc.default.svc.cluster.local.", dns.TypeA, qclass)
+			assert.Equal(t, dns.RcodeRefused, resp.Rcode, "class %v", dns.Class(qclass))
+			assert.Empty(t, resp.Answer)
+		}
+
+		refused := 3.0
+		if !chaos {
+			refused++
+		}
+		assert.Equal(t, refused, counterValue(refusedClassQueries)-before)
+	}
+}
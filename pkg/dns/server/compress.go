@@ -0,0 +1,36 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+// compressWriter writes the responses with name compression, whichever
+// part of the request path they come from, and counts the size of the
+// responses with and without compression.
+type compressWriter struct {
+	dns.ResponseWriter
+}
+
+func (w *compressWriter) WriteMsg(m *dns.Msg) error {
+	m.Compress = false
+	responseBytes.WithLabelValues("uncompressed").Add(float64(m.Len()))
+	m.Compress = true
+	responseBytes.WithLabelValues("compressed").Add(float64(m.Len()))
+	return w.ResponseWriter.WriteMsg(m)
+}
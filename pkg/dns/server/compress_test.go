@@ -0,0 +1,59 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCompression(t *testing.T) {
+	compressed := responseBytes.WithLabelValues("compressed")
+	uncompressed := responseBytes.WithLabelValues("uncompressed")
+	compressedBefore, uncompressedBefore := counterValue(compressed), counterValue(uncompressed)
+
+	// The 50 answers have the same name.
+	h := NewHandler(largeBackend, Options{Domain: testDomain})
+	resp := query(h, "a.ns1.svc.cluster.local.", dns.TypeA)
+	require.Len(t, resp.Answer, 50)
+	assert.True(t, resp.Compress)
+
+	packed, err := resp.Pack()
+	require.NoError(t, err)
+	resp.Compress = false
+	unpacked, err := resp.Pack()
+	require.NoError(t, err)
+	assert.True(t, len(packed) < len(unpacked)/2, "%d bytes compressed, %d uncompressed",
+		len(packed), len(unpacked))
+
+	parsed := new(dns.Msg)
+	require.NoError(t, parsed.Unpack(packed))
+	require.Len(t, parsed.Answer, 50)
+	for i, rr := range parsed.Answer {
+		assert.Equal(t, "a.ns1.svc.cluster.local.", rr.Header().Name)
+		assert.Equal(t, resp.Answer[i].(*dns.A).A.String(), rr.(*dns.A).A.String())
+	}
+
+	compressedBytes := counterValue(compressed) - compressedBefore
+	uncompressedBytes := counterValue(uncompressed) - uncompressedBefore
+	assert.True(t, compressedBytes > 0)
+	assert.True(t, compressedBytes < uncompressedBytes/2, "%v bytes compressed, %v uncompressed",
+		compressedBytes, uncompressedBytes)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// clientCookieLength is the length in bytes of a client cookie.
+	clientCookieLength = 8
+	// serverCookieLength is the length in bytes of the server cookies
+	// generated by kube-dns.
+	serverCookieLength = 8
+	// minServerCookieLength and maxServerCookieLength bound the length
+	// of the server cookies sent by clients.
+	minServerCookieLength = 8
+	maxServerCookieLength = 32
+)
+
+// requestCookie is the DNS cookie (RFC 7873) of a request.
+type requestCookie struct {
+	client []byte
+	// server is nil if the client did not send a server cookie.
+	server []byte
+}
+
+// parseCookie returns the cookie of req, or nil if it has none. It
+// returns false if the cookie is malformed.
+func parseCookie(req *dns.Msg) (*requestCookie, bool) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return nil, true
+	}
+	for _, option := range opt.Option {
+		cookie, ok := option.(*dns.EDNS0_COOKIE)
+		if !ok {
+			continue
+		}
+		b, err := hex.DecodeString(cookie.Cookie)
+		if err != nil || len(b) < clientCookieLength {
+			return nil, false
+		}
+		c := &requestCookie{client: b[:clientCookieLength]}
+		if server := b[clientCookieLength:]; len(server) > 0 {
+			if len(server) < minServerCookieLength || len(server) > maxServerCookieLength {
+				return nil, false
+			}
+			c.server = server
+		}
+		return c, true
+	}
+	return nil, true
+}
+
+// serverCookie returns the server cookie for the client cookie of a
+// client with address ip: an HMAC of both with the cookie secret.
+func (h *Handler) serverCookie(client []byte, ip net.IP) []byte {
+	mac := hmac.New(sha256.New, []byte(h.options.CookieSecret))
+	mac.Write(client)
+	mac.Write(ip)
+	return mac.Sum(nil)[:serverCookieLength]
+}
+
+// cookieWriter adds the DNS cookie to the responses to a request. If
+// CookieEnforceSize is set, larger UDP responses to clients without a
+// valid server cookie are truncated so that the client retries over TCP,
+// where the source address cannot be spoofed.
+type cookieWriter struct {
+	dns.ResponseWriter
+	h *Handler
+	// response is the cookie sent in the responses, hex encoded. It is
+	// empty if the request had no cookie.
+	response string
+	// valid is whether the request had a valid server cookie.
+	valid bool
+}
+
+// newCookieWriter returns a cookieWriter for the responses to a request
+// with cookie, which may be nil.
+func (h *Handler) newCookieWriter(w dns.ResponseWriter, cookie *requestCookie) *cookieWriter {
+	cw := &cookieWriter{ResponseWriter: w, h: h}
+	if cookie == nil {
+		return cw
+	}
+	response := cookie.client
+	if h.options.CookieSecret != "" {
+		server := h.serverCookie(cookie.client, remoteIP(w.RemoteAddr()))
+		cw.valid = hmac.Equal(server, cookie.server)
+		response = append(append([]byte(nil), cookie.client...), server...)
+	}
+	cw.response = hex.EncodeToString(response)
+	return cw
+}
+
+func (w *cookieWriter) WriteMsg(m *dns.Msg) error {
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp && !w.valid &&
+		w.h.options.CookieEnforceSize > 0 && m.Len() > w.h.options.CookieEnforceSize {
+		cookieTruncatedResponses.Inc()
+		m = truncated(m)
+	}
+	if w.response != "" {
+		m = withCookie(m, w.response)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// withCookie returns a copy of m whose OPT record has the cookie option
+// set to cookie, replacing any cookie from an upstream nameserver. The
+// records of m are not modified.
+func withCookie(m *dns.Msg, cookie string) *dns.Msg {
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(dns.DefaultMsgSize)
+	resp := *m
+	resp.Extra = nil
+	for _, rr := range m.Extra {
+		if o, ok := rr.(*dns.OPT); ok {
+			opt.Hdr = o.Hdr
+			for _, option := range o.Option {
+				if option.Option() != dns.EDNS0COOKIE {
+					opt.Option = append(opt.Option, option)
+				}
+			}
+			continue
+		}
+		resp.Extra = append(resp.Extra, rr)
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+	resp.Extra = append(resp.Extra, opt)
+	return &resp
+}
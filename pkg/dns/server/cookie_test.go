@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testClientCookie = "0102030405060708"
+
+// queryWithCookie sends an A query for name from 10.1.0.1 over UDP, with
+// cookie if it is not empty.
+func queryWithCookie(h dns.Handler, name, cookie string) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, dns.TypeA)
+	if cookie != "" {
+		req.SetEdns0(dns.DefaultMsgSize, false)
+		opt := req.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+	}
+	w := newFakeWriter("10.1.0.1")
+	h.ServeDNS(w, req)
+	return w.msg
+}
+
+// responseCookie returns the cookie of m, or "" if it has none.
+func responseCookie(m *dns.Msg) string {
+	if opt := m.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if cookie, ok := option.(*dns.EDNS0_COOKIE); ok {
+				return cookie.Cookie
+			}
+		}
+	}
+	return ""
+}
+
+// largeBackend answers A queries with 50 records.
+var largeBackend = dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	for i := 0; i < 50; i++ {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+			A: net.ParseIP(fmt.Sprintf("10.0.0.%d", i+1)),
+		})
+	}
+	w.WriteMsg(m)
+})
+
+func TestCookieEcho(t *testing.T) {
+	h := NewHandler(largeBackend, Options{Domain: testDomain})
+
+	resp := queryWithCookie(h, "a.ns1.svc.cluster.local.", testClientCookie)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Len(t, resp.Answer, 50)
+	assert.Equal(t, testClientCookie, responseCookie(resp))
+
+	// Without a cookie, none is added.
+	resp = queryWithCookie(h, "a.ns1.svc.cluster.local.", "")
+	assert.Len(t, resp.Answer, 50)
+	assert.Nil(t, resp.IsEdns0())
+
+	// Malformed cookies are rejected.
+	for _, cookie := range []string{"0102", testClientCookie + "0102", "not hex!"} {
+		resp = queryWithCookie(h, "a.ns1.svc.cluster.local.", cookie)
+		assert.Equal(t, dns.RcodeFormatError, resp.Rcode, cookie)
+	}
+}
+
+func TestCookieEnforcement(t *testing.T) {
+	h := NewHandler(largeBackend, Options{
+		Domain:            testDomain,
+		CookieSecret:      "secret",
+		CookieEnforceSize: 512,
+	})
+	before := counterValue(cookieTruncatedResponses)
+
+	// Without a cookie, the large response is truncated.
+	resp := queryWithCookie(h, "a.ns1.svc.cluster.local.", "")
+	assert.True(t, resp.Truncated)
+	assert.Empty(t, resp.Answer)
+
+	// A client cookie alone gets a truncated response with the server
+	// cookie.
+	resp = queryWithCookie(h, "a.ns1.svc.cluster.local.", testClientCookie)
+	assert.True(t, resp.Truncated)
+	assert.Empty(t, resp.Answer)
+	cookie := responseCookie(resp)
+	require.Len(t, cookie, 2*(clientCookieLength+serverCookieLength))
+	assert.Equal(t, testClientCookie, cookie[:2*clientCookieLength])
+	assert.Equal(t, float64(2), counterValue(cookieTruncatedResponses)-before)
+
+	// With the server cookie, the full response is sent.
+	resp = queryWithCookie(h, "a.ns1.svc.cluster.local.", cookie)
+	assert.False(t, resp.Truncated)
+	assert.Len(t, resp.Answer, 50)
+	assert.Equal(t, cookie, responseCookie(resp))
+
+	// A server cookie for another client cookie is not valid.
+	resp = queryWithCookie(h, "a.ns1.svc.cluster.local.", "0807060504030201"+cookie[2*clientCookieLength:])
+	assert.True(t, resp.Truncated)
+
+	// Small responses are not truncated.
+	h = NewHandler(&fakeBackend{records: map[string]string{"a.ns1.svc.cluster.local.": "10.0.0.1"}},
+		Options{Domain: testDomain, CookieSecret: "secret", CookieEnforceSize: 512})
+	resp = queryWithCookie(h, "a.ns1.svc.cluster.local.", "")
+	assert.False(t, resp.Truncated)
+	assert.Len(t, resp.Answer, 1)
+}
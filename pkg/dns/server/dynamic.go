@@ -0,0 +1,117 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+// dynamicConfig is the part of the request path that is built from the
+// dynamic configuration.
+type dynamicConfig struct {
+	// acl is nil if there are no query ACLs.
+	acl *queryACL
+	// stubDomains maps each (lower-cased, fully qualified) stub domain
+	// to its forwarder.
+	stubDomains map[string]*forwarder
+	// upstream is nil if the configuration has no upstream nameservers.
+	upstream *forwarder
+	// fallback is nil if the configuration has no fallback upstream
+	// nameservers.
+	fallback *forwarder
+	// recordTTLs maps record types to their TTL. It is nil if there are
+	// no record TTLs.
+	recordTTLs map[uint16]uint32
+	// views is nil if there are no views.
+	views *views
+}
+
+// newDynamicConfig builds the dynamicConfig for cfg. The forwarders use
+// protocol and pool for upstream queries.
+func newDynamicConfig(cfg *config.Config, protocol UpstreamProtocol, pool *tcpPool) *dynamicConfig {
+	dynamic := &dynamicConfig{stubDomains: make(map[string]*forwarder)}
+	if cfg == nil {
+		return dynamic
+	}
+
+	if len(cfg.QueryACLs) > 0 {
+		dynamic.acl = newQueryACL(cfg.QueryACLs)
+	}
+	if len(cfg.Views) > 0 {
+		dynamic.views = newViews(cfg.Views)
+	}
+	for name, ttl := range cfg.RecordTTLs {
+		if dynamic.recordTTLs == nil {
+			dynamic.recordTTLs = make(map[uint16]uint32)
+		}
+		qtype, _ := config.RecordType(name)
+		dynamic.recordTTLs[qtype] = ttl
+	}
+	for domain, nameservers := range cfg.StubDomains {
+		domain = strings.ToLower(dns.Fqdn(domain))
+		dynamic.stubDomains[domain] = newForwarder(
+			nameserverAddrs(nameservers), defaultForwardTimeout, protocol, pool)
+	}
+	if len(cfg.UpstreamNameservers) > 0 {
+		dynamic.upstream = newForwarder(
+			nameserverAddrs(cfg.UpstreamNameservers), defaultForwardTimeout, protocol, pool)
+	}
+	if len(cfg.FallbackUpstreamNameservers) > 0 {
+		dynamic.fallback = newForwarder(
+			nameserverAddrs(cfg.FallbackUpstreamNameservers), defaultFallbackForwardTimeout, protocol, pool)
+	}
+	return dynamic
+}
+
+// stubZoneOf returns the most specific stub domain containing name.
+func (dynamic *dynamicConfig) stubZoneOf(name string) (string, bool) {
+	return matchZone(name, func(zone string) bool {
+		_, ok := dynamic.stubDomains[zone]
+		return ok
+	})
+}
+
+func nameserverAddrs(nameservers []string) []string {
+	var addrs []string
+	for _, nameserver := range nameservers {
+		addrs = append(addrs, config.NameserverAddr(nameserver))
+	}
+	return addrs
+}
+
+// dynamicConfig returns the dynamicConfig for the current configuration.
+// It is only rebuilt when the configuration changes.
+func (h *Handler) dynamicConfig() *dynamicConfig {
+	var cfg *config.Config
+	if h.options.Config != nil {
+		cfg = h.options.Config.Config()
+	}
+
+	h.dynamicLock.Lock()
+	defer h.dynamicLock.Unlock()
+	if h.dynamic == nil || cfg != h.dynamicSource {
+		glog.V(2).Infof("Applying configuration to the request path")
+		h.dynamicSource = cfg
+		h.dynamic = newDynamicConfig(cfg, h.options.UpstreamProtocol, h.tcpPool)
+	}
+	return h.dynamic
+}
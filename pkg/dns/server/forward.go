@@ -0,0 +1,305 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"k8s.io/client-go/pkg/util/clock"
+)
+
+const (
+	// defaultForwardTimeout for each exchange with an upstream nameserver.
+	defaultForwardTimeout = 2 * time.Second
+	// defaultFallbackForwardTimeout for each exchange with a fallback
+	// upstream nameserver, which is typically further away.
+	defaultFallbackForwardTimeout = 5 * time.Second
+	// defaultMarkDownPeriod for which a nameserver that failed to answer
+	// is only tried after the others.
+	defaultMarkDownPeriod = 10 * time.Second
+	// minForwardLabels is the minimum number of labels in a name for it to
+	// be forwarded. This matches the skydns ndots default.
+	minForwardLabels = 2
+)
+
+// UpstreamProtocol is the transport used for the first attempt to
+// forward a query upstream.
+type UpstreamProtocol string
+
+const (
+	// UpstreamProtocolUDP forwards over the transport the query was
+	// received on. Queries whose UDP response is truncated are retried
+	// over TCP to the same nameserver.
+	UpstreamProtocolUDP UpstreamProtocol = "udp"
+	// UpstreamProtocolTCP always forwards over TCP.
+	UpstreamProtocolTCP UpstreamProtocol = "tcp"
+	// UpstreamProtocolPreferTCP forwards over TCP, and over UDP to the
+	// same nameserver if the TCP exchange fails.
+	UpstreamProtocolPreferTCP UpstreamProtocol = "prefer-tcp"
+)
+
+// ValidateUpstreamProtocol returns an error if protocol is not one of the
+// UpstreamProtocol values.
+func ValidateUpstreamProtocol(protocol string) error {
+	switch UpstreamProtocol(protocol) {
+	case UpstreamProtocolUDP, UpstreamProtocolTCP, UpstreamProtocolPreferTCP:
+		return nil
+	}
+	return fmt.Errorf("invalid upstream protocol %q, must be %q, %q or %q",
+		protocol, UpstreamProtocolUDP, UpstreamProtocolTCP, UpstreamProtocolPreferTCP)
+}
+
+// forwarder sends queries for names outside of the cluster domain to the
+// upstream nameservers. Nameservers are tried in order until one of them
+// answers. A nameserver that fails is marked down for markDownPeriod,
+// during which it is only tried once the others have failed.
+type forwarder struct {
+	nameservers []string
+	protocol    UpstreamProtocol
+	// timeout for each exchange with a nameserver.
+	timeout   time.Duration
+	udpClient *dns.Client
+	tcpClient *dns.Client
+	// tcpPool is used for TCP queries instead of tcpClient if set.
+	tcpPool *tcpPool
+
+	markDownPeriod time.Duration
+	// clock the mark downs are timed with.
+	clock clock.Clock
+	// downLock protects downUntil.
+	downLock sync.Mutex
+	// downUntil is the time until which each nameserver is marked down.
+	downUntil map[string]time.Time
+}
+
+// newForwarder returns a forwarder for nameservers. TCP queries are sent
+// over the connections in pool; if pool is nil, a new connection is
+// opened for each query. An empty protocol is UpstreamProtocolUDP.
+func newForwarder(nameservers []string, timeout time.Duration, protocol UpstreamProtocol, pool *tcpPool) *forwarder {
+	if protocol == "" {
+		protocol = UpstreamProtocolUDP
+	}
+	return &forwarder{
+		nameservers:    nameservers,
+		protocol:       protocol,
+		timeout:        timeout,
+		tcpPool:        pool,
+		markDownPeriod: defaultMarkDownPeriod,
+		clock:          clock.RealClock{},
+		downUntil:      make(map[string]time.Time),
+		udpClient: &dns.Client{
+			Net: "udp", ReadTimeout: timeout, WriteTimeout: timeout, SingleInflight: true},
+		tcpClient: &dns.Client{
+			Net: "tcp", ReadTimeout: timeout, WriteTimeout: timeout, SingleInflight: true},
+	}
+}
+
+// ServeDNS implements dns.Handler.
+func (f *forwarder) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	f.serve(context.Background(), w, req, nil)
+}
+
+// serve forwards req, giving up with SERVFAIL once ctx is done. If no
+// nameserver answers, or the answer is SERVFAIL, req is forwarded to
+// fallback if it is not nil.
+func (f *forwarder) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg, fallback *forwarder) {
+	name := req.Question[0].Name
+	if dns.CountLabel(name) < minForwardLabels {
+		glog.V(4).Infof("Not forwarding %q, name has less than %d labels", name, minForwardLabels)
+		w.WriteMsg(serverFailure(req))
+		return
+	}
+
+	_, clientTCP := w.RemoteAddr().(*net.TCPAddr)
+	tcp := clientTCP || f.protocol != UpstreamProtocolUDP
+	resp, err := f.exchange(ctx, req, tcp)
+	if fallback != nil && ctx.Err() == nil && (err != nil || resp.Rcode == dns.RcodeServerFailure) {
+		glog.V(3).Infof("Forwarding %q to the fallback nameservers", name)
+		upstreamFallbackQueries.Inc()
+		resp, err = fallback.exchange(ctx, req, tcp)
+	}
+	if err != nil {
+		glog.V(2).Infof("Error forwarding %q: %v", name, err)
+		w.WriteMsg(serverFailure(req))
+		return
+	}
+	resp.Compress = true
+	resp.Id = req.Id
+	if !clientTCP && resp.Len() > maxResponseSize(req, false) {
+		// The response was received over TCP and does not fit in the
+		// client's UDP buffer: let the client retry over TCP.
+		resp = truncated(resp)
+	}
+	w.WriteMsg(resp)
+}
+
+// exchange sends req to the nameservers in order, returning the first
+// response. It gives up once ctx is done, without marking the nameserver
+// being tried down.
+func (f *forwarder) exchange(ctx context.Context, req *dns.Msg, tcp bool) (*dns.Msg, error) {
+	var lastErr error
+	for _, nameserver := range f.order() {
+		resp, err := f.exchangeContext(ctx, req, nameserver, tcp)
+		if err == nil && resp.Rcode == dns.RcodeServerFailure {
+			// Retry once on the same nameserver.
+			resp, err = f.exchangeContext(ctx, req, nameserver, tcp)
+		}
+		if ctx.Err() != nil {
+			upstreamDeadlineExceeded.Inc()
+			return nil, fmt.Errorf("gave up forwarding: %v", ctx.Err())
+		}
+		if err == nil {
+			// The label values are bounded by the configured
+			// nameservers.
+			upstreamQueries.WithLabelValues(nameserver).Inc()
+			f.markUp(nameserver)
+			return resp, nil
+		}
+		glog.V(3).Infof("Error forwarding to %v: %v", nameserver, err)
+		f.markDown(nameserver)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("no nameserver answered (last error: %v)", lastErr)
+}
+
+// order returns the nameservers in the order they are tried: those that
+// are up, followed by those that are marked down.
+func (f *forwarder) order() []string {
+	f.downLock.Lock()
+	defer f.downLock.Unlock()
+	if len(f.downUntil) == 0 {
+		return f.nameservers
+	}
+
+	now := f.clock.Now()
+	up := make([]string, 0, len(f.nameservers))
+	var down []string
+	for _, nameserver := range f.nameservers {
+		if until, ok := f.downUntil[nameserver]; ok && now.Before(until) {
+			down = append(down, nameserver)
+		} else {
+			up = append(up, nameserver)
+		}
+	}
+	return append(up, down...)
+}
+
+func (f *forwarder) markDown(nameserver string) {
+	f.downLock.Lock()
+	defer f.downLock.Unlock()
+	now := f.clock.Now()
+	if until, ok := f.downUntil[nameserver]; !ok || now.After(until) {
+		glog.V(2).Infof("Marking nameserver %v down for %v", nameserver, f.markDownPeriod)
+	}
+	f.downUntil[nameserver] = now.Add(f.markDownPeriod)
+}
+
+func (f *forwarder) markUp(nameserver string) {
+	f.downLock.Lock()
+	defer f.downLock.Unlock()
+	if _, ok := f.downUntil[nameserver]; ok {
+		glog.V(2).Infof("Nameserver %v is up", nameserver)
+		delete(f.downUntil, nameserver)
+	}
+}
+
+// exchangeContext is exchangeWith, returning early once ctx is done.
+// The abandoned exchange completes in the background, bounded by the
+// forwarder timeout.
+func (f *forwarder) exchangeContext(ctx context.Context, req *dns.Msg, nameserver string, tcp bool) (*dns.Msg, error) {
+	if ctx.Done() == nil {
+		return f.exchangeWith(req, nameserver, tcp)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := f.exchangeWith(req, nameserver, tcp)
+		done <- result{resp, err}
+	}()
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// exchangeWith sends req to nameserver over TCP if tcp is set, and over
+// UDP otherwise. A truncated UDP response is retried over TCP; if that
+// fails, the truncated response is returned. With
+// UpstreamProtocolPreferTCP, a failed TCP exchange is retried over UDP.
+func (f *forwarder) exchangeWith(req *dns.Msg, nameserver string, tcp bool) (*dns.Msg, error) {
+	if !tcp {
+		resp, _, err := f.udpClient.Exchange(req, nameserver)
+		// The client reports truncated responses as ErrTruncated.
+		if err != nil && err != dns.ErrTruncated || err == nil && !resp.Truncated {
+			return resp, err
+		}
+		glog.V(4).Infof("Truncated response from %v, retrying over TCP", nameserver)
+		upstreamTruncatedRetries.Inc()
+		tcpResp, tcpErr := f.exchangeTCP(req, nameserver)
+		if tcpErr != nil {
+			glog.V(4).Infof("Error retrying over TCP to %v: %v", nameserver, tcpErr)
+			return resp, nil
+		}
+		return tcpResp, nil
+	}
+	resp, err := f.exchangeTCP(req, nameserver)
+	if err != nil && f.protocol == UpstreamProtocolPreferTCP {
+		glog.V(4).Infof("Error forwarding to %v over TCP, retrying over UDP: %v", nameserver, err)
+		resp, _, err = f.udpClient.Exchange(req, nameserver)
+	}
+	return resp, err
+}
+
+func (f *forwarder) exchangeTCP(req *dns.Msg, nameserver string) (*dns.Msg, error) {
+	if f.tcpPool != nil {
+		return f.tcpPool.exchange(req, nameserver, f.timeout)
+	}
+	resp, _, err := f.tcpClient.Exchange(req, nameserver)
+	return resp, err
+}
+
+// truncated returns an empty truncated copy of resp.
+func truncated(resp *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.MsgHdr = resp.MsgHdr
+	m.Question = resp.Question
+	m.Truncated = true
+	return m
+}
+
+func serverFailure(req *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeServerFailure)
+	m.RecursionAvailable = true
+	return m
+}
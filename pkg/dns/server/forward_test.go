@@ -0,0 +1,491 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/pkg/util/clock"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+// startUpstream runs a UDP nameserver answering every A query with ip.
+// Call the returned func to stop it.
+func startUpstream(t testing.TB, ip string) (string, func()) {
+	return startUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+			A: net.ParseIP(ip),
+		})
+		w.WriteMsg(m)
+	}))
+}
+
+// startUpstreamHandler runs a UDP nameserver serving handler.
+func startUpstreamHandler(t testing.TB, handler dns.Handler) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &dns.Server{PacketConn: conn, Handler: handler}
+	require.NoError(t, activate(srv))
+	return conn.LocalAddr().String(), func() { srv.Shutdown() }
+}
+
+// unusedAddr returns an address with nothing listening on it.
+func unusedAddr(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestForwarder(t *testing.T) {
+	upstream, stop := startUpstream(t, "192.0.2.1")
+	defer stop()
+
+	f := newForwarder([]string{unusedAddr(t), upstream}, 100*time.Millisecond, UpstreamProtocolUDP, nil)
+
+	// The first nameserver does not answer, the second does.
+	resp := query(f, "www.example.com.", dns.TypeA)
+	require.NotNil(t, resp)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "192.0.2.1", resp.Answer[0].(*dns.A).A.String())
+
+	// Single label names are not forwarded.
+	resp = query(f, "localhost.", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+
+	// No nameserver answers.
+	f = newForwarder([]string{unusedAddr(t)}, 100*time.Millisecond, UpstreamProtocolUDP, nil)
+	resp = query(f, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+}
+
+func TestForwardingConfig(t *testing.T) {
+	defaultUpstream, stop := startUpstream(t, "192.0.2.1")
+	defer stop()
+	configUpstream, stop := startUpstream(t, "192.0.2.2")
+	defer stop()
+	stub, stop := startUpstream(t, "192.0.2.3")
+	defer stop()
+
+	source := &staticConfig{config: config.NewDefaultConfig()}
+	h := NewHandler(&fakeBackend{}, Options{
+		Domain:      testDomain,
+		Nameservers: []string{defaultUpstream},
+		Config:      source,
+	})
+	resolve := func(name string) string {
+		resp := query(h, name, dns.TypeA)
+		require.Len(t, resp.Answer, 1, name)
+		return resp.Answer[0].(*dns.A).A.String()
+	}
+
+	assert.Equal(t, "192.0.2.1", resolve("www.example.com."))
+	assert.Equal(t, "192.0.2.1", resolve("www.acme.local."))
+
+	source.config = &config.Config{
+		StubDomains:         map[string][]string{"acme.local": {stub}},
+		UpstreamNameservers: []string{configUpstream},
+	}
+	assert.Equal(t, "192.0.2.2", resolve("www.example.com."))
+	assert.Equal(t, "192.0.2.3", resolve("www.acme.local."))
+	assert.Equal(t, "192.0.2.3", resolve("ACME.local."))
+	assert.Equal(t, "192.0.2.2", resolve("notacme.local."))
+}
+
+func TestForwarderMarkDown(t *testing.T) {
+	var dropped int32
+	unresponsive, stop := startUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&dropped, 1)
+	}))
+	defer stop()
+	upstream, stop := startUpstream(t, "192.0.2.1")
+	defer stop()
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	f := newForwarder([]string{unresponsive, upstream}, 100*time.Millisecond, UpstreamProtocolUDP, nil)
+	f.markDownPeriod = 10 * time.Second
+	f.clock = fakeClock
+
+	assert.Equal(t, dns.RcodeSuccess, query(f, "www.example.com.", dns.TypeA).Rcode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dropped))
+
+	// The unresponsive nameserver is marked down, so the next query goes
+	// to the second one first.
+	assert.Equal(t, dns.RcodeSuccess, query(f, "www.example.com.", dns.TypeA).Rcode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dropped))
+
+	fakeClock.Step(9 * time.Second)
+	assert.Equal(t, dns.RcodeSuccess, query(f, "www.example.com.", dns.TypeA).Rcode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dropped))
+
+	// It is tried again once the mark down expires.
+	fakeClock.Step(2 * time.Second)
+	assert.Equal(t, dns.RcodeSuccess, query(f, "www.example.com.", dns.TypeA).Rcode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dropped))
+}
+
+func TestUpstreamQueries(t *testing.T) {
+	var down int32
+	first, stop := startUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if atomic.LoadInt32(&down) != 0 {
+			return
+		}
+		m := new(dns.Msg)
+		m.SetReply(req)
+		w.WriteMsg(m)
+	}))
+	defer stop()
+	second, stop := startUpstream(t, "192.0.2.2")
+	defer stop()
+	count := func(upstream string) float64 {
+		return counterValue(upstreamQueries.WithLabelValues(upstream))
+	}
+	beforeFirst, beforeSecond := count(first), count(second)
+
+	f := newForwarder([]string{first, second}, 100*time.Millisecond, UpstreamProtocolUDP, nil)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, dns.RcodeSuccess, query(f, "www.example.com.", dns.TypeA).Rcode)
+	}
+	assert.Equal(t, beforeFirst+3, count(first))
+	assert.Equal(t, beforeSecond, count(second))
+
+	// Once the first nameserver stops answering, the queries are counted
+	// for the second one.
+	atomic.StoreInt32(&down, 1)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, dns.RcodeSuccess, query(f, "www.example.com.", dns.TypeA).Rcode)
+	}
+	assert.Equal(t, beforeFirst+3, count(first))
+	assert.Equal(t, beforeSecond+2, count(second))
+}
+
+func TestUpstreamDeadline(t *testing.T) {
+	var dropped [2]int32
+	var nameservers []string
+	for i := range dropped {
+		i := i
+		nameserver, stop := startUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			atomic.AddInt32(&dropped[i], 1)
+		}))
+		defer stop()
+		nameservers = append(nameservers, nameserver)
+	}
+	h := NewHandler(&fakeBackend{}, Options{
+		Domain:           testDomain,
+		Nameservers:      nameservers,
+		UpstreamDeadline: 200 * time.Millisecond,
+	})
+	before := counterValue(upstreamDeadlineExceeded)
+
+	// Forwarding is abandoned at the deadline, before the first
+	// nameserver times out (defaultForwardTimeout) and the second one is
+	// tried.
+	start := time.Now()
+	resp := query(h, "www.example.com.", dns.TypeA)
+	elapsed := time.Since(start)
+	assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+	assert.True(t, elapsed < defaultForwardTimeout, "took %v", elapsed)
+	assert.True(t, elapsed >= 200*time.Millisecond, "took %v", elapsed)
+	assert.Equal(t, before+1, counterValue(upstreamDeadlineExceeded))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dropped[0]))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&dropped[1]))
+
+	// The nameserver is not marked down for running out of time.
+	assert.Equal(t, nameservers, h.forwarder.order())
+}
+
+func TestUpstreamFallback(t *testing.T) {
+	var servfails int32
+	failing, stop := startUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&servfails, 1)
+		w.WriteMsg(serverFailure(req))
+	}))
+	defer stop()
+	fallback, stop := startUpstream(t, "192.0.2.9")
+	defer stop()
+	stub, stop := startUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		w.WriteMsg(serverFailure(req))
+	}))
+	defer stop()
+
+	source := &staticConfig{config: &config.Config{
+		StubDomains:                 map[string][]string{"acme.local": {stub}},
+		UpstreamNameservers:         []string{unusedAddr(t), failing},
+		FallbackUpstreamNameservers: []string{fallback},
+	}}
+	h := NewHandler(&fakeBackend{}, Options{Domain: testDomain, Config: source})
+	before := counterValue(upstreamFallbackQueries)
+
+	// All primaries fail, the fallback answers.
+	resp := query(h, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "192.0.2.9", resp.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, before+1, counterValue(upstreamFallbackQueries))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&servfails))
+
+	// Stub domains do not fall back.
+	resp = query(h, "www.acme.local.", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+	assert.Equal(t, before+1, counterValue(upstreamFallbackQueries))
+
+	// The fallback is not consulted while a primary answers.
+	primary, stop := startUpstream(t, "192.0.2.1")
+	defer stop()
+	source.config = &config.Config{
+		UpstreamNameservers:         []string{primary},
+		FallbackUpstreamNameservers: []string{fallback},
+	}
+	resp = query(h, "www.example.com.", dns.TypeA)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "192.0.2.1", resp.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, before+1, counterValue(upstreamFallbackQueries))
+}
+
+func TestUpstreamFallbackTCPTimeout(t *testing.T) {
+	// The fallback answers after the primary timeout but within the
+	// fallback timeout.
+	fallback, stop := startDualUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		time.Sleep(defaultForwardTimeout + 500*time.Millisecond)
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+			A: net.ParseIP("192.0.2.9"),
+		})
+		w.WriteMsg(m)
+	}))
+	defer stop()
+
+	source := &staticConfig{config: &config.Config{
+		UpstreamNameservers:         []string{unusedAddr(t)},
+		FallbackUpstreamNameservers: []string{fallback},
+	}}
+	h := NewHandler(&fakeBackend{}, Options{
+		Domain:           testDomain,
+		Config:           source,
+		UpstreamProtocol: UpstreamProtocolTCP,
+	})
+
+	resp := query(h, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "192.0.2.9", resp.Answer[0].(*dns.A).A.String())
+}
+
+func TestStubDomainFailover(t *testing.T) {
+	stub, stop := startUpstream(t, "192.0.2.3")
+	defer stop()
+
+	source := &staticConfig{config: &config.Config{
+		StubDomains: map[string][]string{"acme.local": {unusedAddr(t), stub}},
+	}}
+	h := NewHandler(&fakeBackend{}, Options{Domain: testDomain, Config: source})
+
+	for i := 0; i < 2; i++ {
+		resp := query(h, "www.acme.local.", dns.TypeA)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		require.Len(t, resp.Answer, 1)
+		assert.Equal(t, "192.0.2.3", resp.Answer[0].(*dns.A).A.String())
+	}
+}
+
+func TestMaxConcurrentUpstream(t *testing.T) {
+	const limit = 5
+	var inflight, maxInflight int32
+	upstream, stop := startUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		m := new(dns.Msg)
+		m.SetReply(req)
+		w.WriteMsg(m)
+	}))
+	defer stop()
+
+	h := NewHandler(&fakeBackend{}, Options{
+		Domain:                testDomain,
+		Nameservers:           []string{upstream},
+		MaxConcurrentUpstream: limit,
+	})
+
+	refusedBefore := counterValue(upstreamRefusedQueries)
+	var wg sync.WaitGroup
+	var answered, refused int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Distinct names so that the exchanges are not merged.
+			resp := query(h, fmt.Sprintf("host%d.example.com.", i), dns.TypeA)
+			switch resp.Rcode {
+			case dns.RcodeSuccess:
+				atomic.AddInt32(&answered, 1)
+			case dns.RcodeRefused:
+				atomic.AddInt32(&refused, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	max := atomic.LoadInt32(&maxInflight)
+	assert.True(t, max <= limit, "%d upstream queries in flight, limit is %d", max, limit)
+	assert.True(t, answered > 0)
+	assert.True(t, refused > 0)
+	assert.Equal(t, int32(50), answered+refused)
+	assert.Equal(t, float64(refused), counterValue(upstreamRefusedQueries)-refusedBefore)
+
+	// Slots are released once the queries are answered.
+	assert.Equal(t, dns.RcodeSuccess, query(h, "www.example.com.", dns.TypeA).Rcode)
+}
+
+// startDualUpstream runs a nameserver on the same port over UDP and TCP.
+// The transport of each query received is sent on the returned channel.
+func startDualUpstream(t *testing.T) (string, <-chan string, func()) {
+	transports := make(chan string, 10)
+	addr, stop := startDualUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		transports <- w.RemoteAddr().Network()
+		m := new(dns.Msg)
+		m.SetReply(req)
+		w.WriteMsg(m)
+	}))
+	return addr, transports, stop
+}
+
+// startDualUpstreamHandler runs a nameserver serving handler on the same
+// port over UDP and TCP.
+func startDualUpstreamHandler(t *testing.T, handler dns.Handler) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	require.NoError(t, err)
+
+	udp := &dns.Server{PacketConn: conn, Handler: handler}
+	require.NoError(t, activate(udp))
+	tcp := &dns.Server{Listener: listener, Handler: handler}
+	require.NoError(t, activate(tcp))
+	return listener.Addr().String(), func() {
+		udp.Shutdown()
+		tcp.Shutdown()
+	}
+}
+
+func TestUpstreamProtocol(t *testing.T) {
+	upstream, transports, stop := startDualUpstream(t)
+	defer stop()
+
+	for _, tc := range []struct {
+		protocol UpstreamProtocol
+		expected string
+	}{
+		{"", "udp"},
+		{UpstreamProtocolUDP, "udp"},
+		{UpstreamProtocolTCP, "tcp"},
+		{UpstreamProtocolPreferTCP, "tcp"},
+	} {
+		h := NewHandler(&fakeBackend{}, Options{
+			Domain:           testDomain,
+			Nameservers:      []string{upstream},
+			UpstreamProtocol: tc.protocol,
+		})
+		// The client queries over UDP.
+		assert.Equal(t, dns.RcodeSuccess, query(h, "www.example.com.", dns.TypeA).Rcode, tc.protocol)
+		assert.Equal(t, tc.expected, <-transports, tc.protocol)
+	}
+
+	// Queries received over TCP are forwarded over TCP.
+	f := newForwarder([]string{upstream}, time.Second, UpstreamProtocolUDP, nil)
+	assert.Equal(t, dns.RcodeSuccess, tcpQuery(f, "www.example.com.").Rcode)
+	assert.Equal(t, "tcp", <-transports)
+}
+
+func TestUpstreamProtocolFallback(t *testing.T) {
+	// The upstream only answers over UDP.
+	upstream, stop := startUpstream(t, "192.0.2.1")
+	defer stop()
+
+	f := newForwarder([]string{upstream}, 100*time.Millisecond, UpstreamProtocolTCP, nil)
+	assert.Equal(t, dns.RcodeServerFailure, query(f, "www.example.com.", dns.TypeA).Rcode)
+
+	f = newForwarder([]string{upstream}, 100*time.Millisecond, UpstreamProtocolPreferTCP, nil)
+	resp := query(f, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "192.0.2.1", resp.Answer[0].(*dns.A).A.String())
+}
+
+func TestUpstreamTruncatedRetry(t *testing.T) {
+	// The upstream truncates its answers over UDP.
+	upstream, stop := startDualUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+			m.Truncated = true
+			w.WriteMsg(m)
+			return
+		}
+		for i := 0; i < 3; i++ {
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{
+					Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+				A: net.ParseIP(fmt.Sprintf("192.0.2.%d", i+1)),
+			})
+		}
+		w.WriteMsg(m)
+	}))
+	defer stop()
+	before := counterValue(upstreamTruncatedRetries)
+
+	f := newForwarder([]string{upstream}, time.Second, UpstreamProtocolUDP, nil)
+	resp := query(f, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.False(t, resp.Truncated)
+	assert.Len(t, resp.Answer, 3)
+	assert.Equal(t, before+1, counterValue(upstreamTruncatedRetries))
+}
+
+func TestValidateUpstreamProtocol(t *testing.T) {
+	for _, protocol := range []string{"udp", "tcp", "prefer-tcp"} {
+		assert.NoError(t, ValidateUpstreamProtocol(protocol))
+	}
+	for _, protocol := range []string{"", "UDP", "tls"} {
+		assert.Error(t, ValidateUpstreamProtocol(protocol))
+	}
+}
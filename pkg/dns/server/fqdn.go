@@ -0,0 +1,67 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// fqdnRequest returns req with a fully qualified question name and w
+// restoring the name as queried in the responses, if the question name of
+// req has no trailing dot. Names in queries received over the network are
+// always fully qualified, but those of requests passed to the Handler
+// in-process may not be.
+func fqdnRequest(w dns.ResponseWriter, req *dns.Msg) (dns.ResponseWriter, *dns.Msg) {
+	if len(req.Question) == 0 || dns.IsFqdn(req.Question[0].Name) {
+		return w, req
+	}
+	name := req.Question[0].Name
+	req = req.Copy()
+	req.Question[0].Name = dns.Fqdn(name)
+	return &relativeNameWriter{ResponseWriter: w, name: name}, req
+}
+
+// relativeNameWriter replaces the fully qualified question name by the
+// name as queried, without the trailing dot, in the question and the
+// answer records of the responses.
+type relativeNameWriter struct {
+	dns.ResponseWriter
+	name string
+}
+
+func (w *relativeNameWriter) WriteMsg(m *dns.Msg) error {
+	fqdn := dns.Fqdn(w.name)
+	resp := *m
+	resp.Question = make([]dns.Question, len(m.Question))
+	for i, q := range m.Question {
+		if strings.EqualFold(q.Name, fqdn) {
+			q.Name = w.name
+		}
+		resp.Question[i] = q
+	}
+	resp.Answer = nil
+	for _, rr := range m.Answer {
+		if strings.EqualFold(rr.Header().Name, fqdn) {
+			rr = dns.Copy(rr)
+			rr.Header().Name = w.name
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return w.ResponseWriter.WriteMsg(&resp)
+}
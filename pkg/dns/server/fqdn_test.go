@@ -0,0 +1,70 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrailingDot(t *testing.T) {
+	h := NewHandler(&fakeBackend{records: map[string]string{"a.ns1.svc.cluster.local.": "10.0.0.1"}},
+		Options{Domain: testDomain})
+
+	fqdn := query(h, "a.ns1.svc.cluster.local.", dns.TypeA)
+	relative := query(h, "a.ns1.svc.cluster.local", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, fqdn.Rcode)
+	assert.Equal(t, dns.RcodeSuccess, relative.Rcode)
+	require.Len(t, fqdn.Answer, 1)
+	require.Len(t, relative.Answer, 1)
+	assert.Equal(t, "10.0.0.1", fqdn.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, "10.0.0.1", relative.Answer[0].(*dns.A).A.String())
+
+	// The responses echo the name as queried.
+	assert.Equal(t, "a.ns1.svc.cluster.local.", fqdn.Question[0].Name)
+	assert.Equal(t, "a.ns1.svc.cluster.local.", fqdn.Answer[0].Header().Name)
+	assert.Equal(t, "a.ns1.svc.cluster.local", relative.Question[0].Name)
+	assert.Equal(t, "a.ns1.svc.cluster.local", relative.Answer[0].Header().Name)
+
+	relative = query(h, "missing.ns1.svc.cluster.local", dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, relative.Rcode)
+	assert.Equal(t, "missing.ns1.svc.cluster.local", relative.Question[0].Name)
+}
+
+func TestResponseNameCase(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := runTestServer(ctx, t, Options{})
+
+	dnsClient := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+	for _, name := range []string{"mysvc.default.svc.cluster.local.", "MySvc.Default.SVC.cluster.local."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		resp, _, err := dnsClient.Exchange(req, addrs.UDP.String())
+		require.NoError(t, err)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode, name)
+		assert.Equal(t, name, resp.Question[0].Name)
+		require.Len(t, resp.Answer, 1, name)
+		assert.Equal(t, name, resp.Answer[0].Header().Name)
+		assert.Equal(t, "10.0.0.10", resp.Answer[0].(*dns.A).A.String())
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// addGlue adds the A and AAAA records of the SRV targets in the cluster
+// domain to the additional section of m, unless they are already there.
+// If the glue does not fit in size, it is left out and, for UDP, the
+// response is marked as truncated so that the client can retry over TCP.
+func (h *Handler) addGlue(m *dns.Msg, size int, tcp bool) {
+	if h.options.Backend == nil {
+		return
+	}
+
+	have := make(map[string]bool)
+	for _, rr := range m.Extra {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+			have[strings.ToLower(rr.Header().Name)] = true
+		}
+	}
+
+	var glue []dns.RR
+	for _, rr := range m.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		target := strings.ToLower(srv.Target)
+		if have[target] || !strings.HasSuffix(target, "."+h.domain) {
+			continue
+		}
+		have[target] = true
+
+		records, err := h.options.Backend.Records(target, false)
+		if err != nil {
+			continue
+		}
+		hdr := dns.RR_Header{Name: srv.Target, Class: dns.ClassINET, Ttl: srv.Hdr.Ttl}
+		for _, record := range records {
+			ip := net.ParseIP(record.Host)
+			switch {
+			case ip == nil:
+			case ip.To4() != nil:
+				hdr.Rrtype = dns.TypeA
+				glue = append(glue, &dns.A{Hdr: hdr, A: ip.To4()})
+			default:
+				hdr.Rrtype = dns.TypeAAAA
+				glue = append(glue, &dns.AAAA{Hdr: hdr, AAAA: ip})
+			}
+		}
+	}
+	if len(glue) == 0 {
+		return
+	}
+
+	m.Extra = append(m.Extra, glue...)
+	if m.Len() > size {
+		m.Extra = m.Extra[:len(m.Extra)-len(glue)]
+		if !tcp {
+			m.Truncated = true
+		}
+	}
+}
+
+// maxResponseSize returns the maximum size of the response to req.
+func maxResponseSize(req *dns.Msg, tcp bool) int {
+	if tcp {
+		return dns.MaxMsgSize
+	}
+	size := dns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = int(opt.UDPSize())
+	}
+	return size
+}
+
+// glueWriter adds glue for SRV targets to the response.
+type glueWriter struct {
+	dns.ResponseWriter
+	h   *Handler
+	req *dns.Msg
+}
+
+func (w *glueWriter) WriteMsg(m *dns.Msg) error {
+	if m.Rcode == dns.RcodeSuccess && !m.Truncated {
+		_, tcp := w.RemoteAddr().(*net.TCPAddr)
+		w.h.addGlue(m, maxResponseSize(w.req, tcp), tcp)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	skymsg "github.com/skynetservices/skydns/msg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+)
+
+// headlessService returns a headless service and its endpoints with the
+// given number of named endpoints.
+func headlessService(name string, endpoints int) []runtime.Object {
+	meta := v1.ObjectMeta{Name: name, Namespace: "default"}
+	subset := v1.EndpointSubset{Ports: []v1.EndpointPort{{Name: "http", Port: 80, Protocol: "TCP"}}}
+	for i := 0; i < endpoints; i++ {
+		subset.Addresses = append(subset.Addresses, v1.EndpointAddress{
+			IP: fmt.Sprintf("10.1.%d.%d", i/256, i%256), Hostname: fmt.Sprintf("ep-%d", i)})
+	}
+	return []runtime.Object{
+		&v1.Service{
+			ObjectMeta: meta,
+			Spec: v1.ServiceSpec{
+				ClusterIP: v1.ClusterIPNone,
+				Ports:     []v1.ServicePort{{Name: "http", Port: 80, Protocol: "TCP"}},
+			},
+		},
+		&v1.Endpoints{ObjectMeta: meta, Subsets: []v1.EndpointSubset{subset}},
+	}
+}
+
+func TestSRVGlue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := runTestServer(ctx, t, Options{},
+		append(headlessService("small", 3), headlessService("large", 50)...)...)
+
+	exchange := func(proto, addr, name string) *dns.Msg {
+		dnsClient := &dns.Client{Net: proto, Timeout: 5 * time.Second}
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeSRV)
+		resp, _, err := dnsClient.Exchange(req, addr)
+		if err == dns.ErrTruncated {
+			// The response is still usable.
+			err = nil
+		}
+		require.NoError(t, err, proto)
+		require.Equal(t, dns.RcodeSuccess, resp.Rcode, proto)
+		return resp
+	}
+	glue := func(resp *dns.Msg) map[string]string {
+		ips := make(map[string]string)
+		for _, rr := range resp.Extra {
+			if a, ok := rr.(*dns.A); ok {
+				ips[strings.SplitN(a.Hdr.Name, ".", 2)[0]] = a.A.String()
+			}
+		}
+		return ips
+	}
+
+	for proto, addr := range map[string]string{
+		"udp": addrs.UDP.String(),
+		"tcp": addrs.TCP.String(),
+	} {
+		resp := exchange(proto, addr, "_http._tcp.small.default.svc.cluster.local.")
+		assert.Len(t, resp.Answer, 3, proto)
+		assert.False(t, resp.Truncated, proto)
+		assert.Equal(t, map[string]string{
+			"ep-0": "10.1.0.0",
+			"ep-1": "10.1.0.1",
+			"ep-2": "10.1.0.2",
+		}, glue(resp), proto)
+	}
+
+	// The glue for the large service does not fit in a UDP response.
+	resp := exchange("udp", addrs.UDP.String(), "_http._tcp.large.default.svc.cluster.local.")
+	assert.True(t, resp.Truncated)
+	assert.Empty(t, glue(resp))
+
+	resp = exchange("tcp", addrs.TCP.String(), "_http._tcp.large.default.svc.cluster.local.")
+	assert.Len(t, resp.Answer, 50)
+	assert.Len(t, glue(resp), 50)
+}
+
+// addressBackend is a skydns Backend with address records only.
+type addressBackend map[string]string
+
+func (b addressBackend) Records(name string, exact bool) ([]skymsg.Service, error) {
+	if host, ok := b[name]; ok {
+		return []skymsg.Service{{Host: host}}, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func (b addressBackend) ReverseRecord(name string) (*skymsg.Service, error) {
+	return nil, fmt.Errorf("not found")
+}
+
+func TestAddGlue(t *testing.T) {
+	backend := addressBackend{}
+	h := NewHandler(&fakeBackend{}, Options{Domain: testDomain, Backend: backend})
+
+	newResponse := func(targets ...string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("_http._tcp.svc.default.svc.cluster.local.", dns.TypeSRV)
+		m := new(dns.Msg)
+		m.SetReply(req)
+		for _, target := range targets {
+			m.Answer = append(m.Answer, &dns.SRV{
+				Hdr: dns.RR_Header{
+					Name: req.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 30},
+				Port:   80,
+				Target: target,
+			})
+		}
+		return m
+	}
+
+	backend["a.svc.default.svc.cluster.local."] = "10.0.0.1"
+	backend["b.svc.default.svc.cluster.local."] = "fd00::2"
+	backend["www.example.com."] = "192.0.2.1"
+	m := newResponse("a.svc.default.svc.cluster.local.", "b.svc.default.svc.cluster.local.",
+		"c.svc.default.svc.cluster.local.", "www.example.com.")
+	h.addGlue(m, dns.MinMsgSize, false)
+	assert.False(t, m.Truncated)
+	require.Len(t, m.Extra, 2)
+	assert.Equal(t, &dns.A{
+		Hdr: dns.RR_Header{
+			Name: "a.svc.default.svc.cluster.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+		A: net.ParseIP("10.0.0.1").To4(),
+	}, m.Extra[0])
+	assert.Equal(t, dns.TypeAAAA, m.Extra[1].Header().Rrtype)
+
+	// Glue that is already present is not added again.
+	h.addGlue(m, dns.MinMsgSize, false)
+	assert.Len(t, m.Extra, 2)
+
+	// Glue that does not fit is dropped and the response truncated.
+	var targets []string
+	for i := 0; i < 8; i++ {
+		target := fmt.Sprintf("ep-%d.svc.default.svc.cluster.local.", i)
+		backend[target] = fmt.Sprintf("10.0.1.%d", i)
+		targets = append(targets, target)
+	}
+	m = newResponse(targets...)
+	size := m.Len() + 10
+	h.addGlue(m, size, false)
+	assert.True(t, m.Truncated)
+	assert.Empty(t, m.Extra)
+	assert.Len(t, m.Answer, 8)
+
+	// Over TCP the response is not truncated.
+	m = newResponse(targets...)
+	h.addGlue(m, size, true)
+	assert.False(t, m.Truncated)
+	assert.Empty(t, m.Extra)
+	m = newResponse(targets...)
+	h.addGlue(m, dns.MaxMsgSize, true)
+	assert.Len(t, m.Extra, 8)
+}
@@ -0,0 +1,408 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+	skyserver "github.com/skynetservices/skydns/server"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+const (
+	// A subdomain added to the cluster domain for all services.
+	serviceSubdomain = "svc"
+)
+
+// Options for the kube-dns request path.
+type Options struct {
+	// Domain is the cluster domain, e.g. "cluster.local.".
+	Domain string
+	// NamespaceMetricsLimit is the maximum number of namespaces that
+	// get their own label in the per-namespace query metric.
+	NamespaceMetricsLimit int
+	// SOA of the cluster domain.
+	SOA SOAOptions
+	// Chaos enables answering CHAOS class queries (e.g. version.bind).
+	// If disabled, they are refused like those of the other classes but
+	// IN.
+	Chaos bool
+	// Config is the source of the dynamic configuration (e.g. query
+	// ACLs). May be nil.
+	Config ConfigSource
+
+	// Nameservers (host:port) to forward queries outside of the cluster
+	// domain to. If empty, these queries are passed to the next handler.
+	Nameservers []string
+	// MaxConcurrentUpstream bounds the number of queries in flight to the
+	// upstream nameservers and stub domains. Queries beyond the limit are
+	// answered with REFUSED. 0 means no limit.
+	MaxConcurrentUpstream int
+	// UpstreamDeadline bounds the time spent forwarding a query, across
+	// all nameservers and retries. It should be the time after which
+	// clients give up on (or retry) a query. 0 means no deadline.
+	UpstreamDeadline time.Duration
+	// UpstreamProtocol is the transport of the first attempt to forward
+	// a query. Defaults to UpstreamProtocolUDP.
+	UpstreamProtocol UpstreamProtocol
+	// UpstreamDenyNetworks, if set, are the networks that addresses in
+	// forwarded answers must not be in, e.g. loopback and link-local
+	// addresses returned by hijacking resolvers. Responses with such
+	// addresses are answered with SERVFAIL.
+	UpstreamDenyNetworks []*net.IPNet
+	// NoUpstream disables forwarding to the upstream nameservers, e.g. in
+	// isolated clusters. Queries for names outside of the cluster domain
+	// and stub domains get the NoUpstreamResponse right away.
+	NoUpstream bool
+	// NoUpstreamResponse to queries for external names if NoUpstream is
+	// set. Defaults to NoUpstreamNXDomain.
+	NoUpstreamResponse NoUpstreamResponse
+	// MinimalAny answers ANY queries for names in the cluster domain with
+	// a single synthesized HINFO record (RFC 8482) rather than refusing
+	// them.
+	MinimalAny bool
+	// MinimalResponses leaves the authority section and the additional
+	// records out of positive responses, so that they only have an
+	// answer section.
+	MinimalResponses bool
+	// TransferAllowNetworks are the networks of the clients allowed to
+	// transfer (AXFR over TCP) the cluster domain and the reverse zones,
+	// e.g. for migration tooling. If empty, zone transfers are disabled.
+	TransferAllowNetworks []*net.IPNet
+	// RRLResponsesPerSecond limits the rate of identical responses sent
+	// over UDP to a client subnet. 0 disables response rate limiting.
+	RRLResponsesPerSecond int
+	// RRLSlip is the ratio of the rate limited responses that are sent as
+	// empty truncated responses (so that clients can retry over TCP)
+	// rather than dropped, e.g. 2 for every other one. 0 drops all of
+	// them.
+	RRLSlip int
+	// CookieSecret is the secret server cookies (RFC 7873) are derived
+	// from. If empty, the client cookies are echoed without a server
+	// cookie.
+	CookieSecret string
+	// CookieEnforceSize, if set, is the size above which UDP responses to
+	// clients without a valid server cookie are truncated, so that they
+	// retry over TCP. It requires a CookieSecret.
+	CookieEnforceSize int
+	// AnswerOrder of the records in each answer RRset. Defaults to
+	// AnswerOrderStable.
+	AnswerOrder AnswerOrder
+	// Backend is the record source. It is used to tell whether reverse
+	// queries can be answered locally. If it also implements Starter
+	// (e.g. *dns.KubeDNS), Server.Run() starts it once the listeners are
+	// bound. If it implements TXTBackend, its TXT records are served. If
+	// it implements TransferBackend, its records are served in zone
+	// transfers.
+	Backend skyserver.Backend
+}
+
+// ConfigSource provides the current kube-dns configuration.
+type ConfigSource interface {
+	// Config returns the current configuration. The Config is
+	// replaced and not modified when the configuration changes.
+	Config() *config.Config
+}
+
+// Handler is the dns.Handler for kube-dns. It performs kube-dns
+// specific processing of each request, forwards queries for external
+// names upstream and delegates resolution of cluster names to the next
+// handler (the skydns server).
+type Handler struct {
+	options Options
+	next    dns.Handler
+	// forwarder is nil if there are no nameservers.
+	forwarder *forwarder
+	// upstreamSlots holds a token for each query in flight upstream. It
+	// is nil if there is no limit.
+	upstreamSlots chan struct{}
+	// tcpPool of connections shared by all forwarders.
+	tcpPool *tcpPool
+	// rrl is nil if response rate limiting is disabled.
+	rrl *rateLimiter
+	// answerRotation is incremented for each response with
+	// AnswerOrderRoundRobin. It is accessed atomically.
+	answerRotation uint32
+
+	// domain is the lower-cased, fully qualified cluster domain.
+	domain string
+	// soa is options.SOA with the defaults filled in.
+	soa SOAOptions
+
+	// namespacesLock protects namespaces.
+	namespacesLock sync.Mutex
+	// namespaces that are tracked with their own metric label.
+	namespaces map[string]bool
+
+	// dynamicLock protects dynamicSource and dynamic.
+	dynamicLock sync.Mutex
+	// dynamicSource is the config that dynamic was built from.
+	dynamicSource *config.Config
+	dynamic       *dynamicConfig
+}
+
+var _ dns.Handler = (*Handler)(nil)
+
+// NewHandler returns a Handler that passes requests on to next.
+func NewHandler(next dns.Handler, options Options) *Handler {
+	domain := strings.ToLower(dns.Fqdn(options.Domain))
+	h := &Handler{
+		options:    options,
+		next:       next,
+		domain:     domain,
+		soa:        options.SOA.withDefaults(domain),
+		namespaces: make(map[string]bool),
+		tcpPool:    newTCPPool(defaultTCPIdleTimeout, defaultTCPIdlePerUpstream),
+	}
+	if len(options.Nameservers) > 0 {
+		h.forwarder = newForwarder(
+			options.Nameservers, defaultForwardTimeout, options.UpstreamProtocol, h.tcpPool)
+	}
+	if options.MaxConcurrentUpstream > 0 {
+		h.upstreamSlots = make(chan struct{}, options.MaxConcurrentUpstream)
+	}
+	if options.RRLResponsesPerSecond > 0 {
+		h.rrl = newRateLimiter(options.RRLResponsesPerSecond, options.RRLSlip)
+	}
+	return h
+}
+
+// ServeDNS implements dns.Handler.
+func (h *Handler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	w, req = fqdnRequest(w, req)
+	if h.serveTransfer(w, req) {
+		return
+	}
+	w = &compressWriter{ResponseWriter: w}
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp && h.rrl != nil {
+		w = &rrlWriter{ResponseWriter: w, rrl: h.rrl}
+	}
+	cookie, ok := parseCookie(req)
+	if !ok {
+		glog.V(3).Infof("Malformed cookie in query from %v", w.RemoteAddr())
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeFormatError)
+		w.WriteMsg(m)
+		return
+	}
+	if cookie != nil || h.options.CookieEnforceSize > 0 {
+		w = h.newCookieWriter(w, cookie)
+	}
+	if len(req.Question) > 0 && (!h.checkQueryClass(w, req) || !h.checkQueryACL(w, req)) {
+		return
+	}
+
+	switch h.options.AnswerOrder {
+	case AnswerOrderSorted, AnswerOrderRoundRobin:
+		w = &orderWriter{ResponseWriter: w, h: h}
+	}
+	w = &soaWriter{ResponseWriter: w, h: h}
+	if h.options.MinimalResponses {
+		w = &minimalWriter{ResponseWriter: w}
+	}
+	rw := &recordingWriter{ResponseWriter: w}
+	if h.serveView(rw, req) {
+		glog.V(4).Infof("Served %q from a view", req.Question[0].Name)
+	} else if f, fallback := h.forwarderFor(req); f != nil {
+		glog.V(4).Infof("Forwarding %q upstream", req.Question[0].Name)
+		cacheMisses.Inc()
+		h.forward(f, fallback, rw, req)
+	} else if h.serveNoUpstream(rw, req) {
+		glog.V(4).Infof("Not forwarding %q, upstream forwarding is disabled", req.Question[0].Name)
+	} else {
+		glog.V(4).Infof("Serving %v from the record cache", req.Question)
+		cacheHits.Inc()
+		var lw dns.ResponseWriter = rw
+		if ttls := h.dynamicConfig().recordTTLs; ttls != nil {
+			lw = &ttlWriter{ResponseWriter: lw, h: h, ttls: ttls}
+		}
+		if !h.serveApex(lw, req) && !h.serveTXT(lw, req) && !h.serveMinimalAny(lw, req) {
+			if !h.options.MinimalResponses {
+				lw = &glueWriter{ResponseWriter: lw, h: h, req: req}
+			}
+			h.next.ServeDNS(lw, req)
+		}
+	}
+
+	if len(req.Question) > 0 && rw.msg != nil {
+		h.recordNamespaceQuery(req.Question[0].Name, rw.msg)
+	}
+}
+
+// forwarderFor returns the forwarder for req, or nil if req is answered
+// by the next handler, and the fallback forwarder for req, which is nil
+// if there is none. Stub domains have no fallback.
+func (h *Handler) forwarderFor(req *dns.Msg) (*forwarder, *forwarder) {
+	if len(req.Question) == 0 || h.isLocal(req.Question[0]) {
+		return nil, nil
+	}
+	dynamic := h.dynamicConfig()
+	if zone, ok := dynamic.stubZoneOf(req.Question[0].Name); ok {
+		return dynamic.stubDomains[zone], nil
+	}
+	if h.options.NoUpstream {
+		return nil, nil
+	}
+	if dynamic.upstream != nil {
+		return dynamic.upstream, dynamic.fallback
+	}
+	if h.forwarder != nil {
+		return h.forwarder, dynamic.fallback
+	}
+	return nil, nil
+}
+
+// forward passes req to f, with fallback as its fallback forwarder, if
+// there is a free upstream slot, and refuses it otherwise.
+func (h *Handler) forward(f, fallback *forwarder, w dns.ResponseWriter, req *dns.Msg) {
+	if h.upstreamSlots != nil {
+		select {
+		case h.upstreamSlots <- struct{}{}:
+			defer func() { <-h.upstreamSlots }()
+		default:
+			glog.V(3).Infof("Refusing %q, %d upstream queries in flight",
+				req.Question[0].Name, cap(h.upstreamSlots))
+			upstreamRefusedQueries.Inc()
+			m := new(dns.Msg)
+			m.SetRcode(req, dns.RcodeRefused)
+			w.WriteMsg(m)
+			return
+		}
+	}
+	if len(h.options.UpstreamDenyNetworks) > 0 {
+		w = &bogonWriter{ResponseWriter: w, req: req, deny: h.options.UpstreamDenyNetworks}
+	}
+	ctx := context.Background()
+	if h.options.UpstreamDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.options.UpstreamDeadline)
+		defer cancel()
+	}
+	f.serve(ctx, w, req, fallback)
+}
+
+// isLocal returns whether q is answered from the local records rather
+// than by an upstream nameserver. As in skydns, reverse queries are only
+// forwarded if there is no local record.
+func (h *Handler) isLocal(q dns.Question) bool {
+	if q.Qclass == dns.ClassCHAOS {
+		return true
+	}
+	name := strings.ToLower(q.Name)
+	if name == h.domain || strings.HasSuffix(name, "."+h.domain) {
+		return true
+	}
+	if q.Qtype == dns.TypePTR && strings.HasSuffix(name, ".in-addr.arpa.") ||
+		strings.HasSuffix(name, ".ip6.arpa.") {
+		if h.options.Backend == nil {
+			return true
+		}
+		_, err := h.options.Backend.ReverseRecord(name)
+		return err == nil
+	}
+	return false
+}
+
+// checkQueryACL returns whether the query is allowed by the query ACLs.
+// Disallowed queries are answered with REFUSED.
+func (h *Handler) checkQueryACL(w dns.ResponseWriter, req *dns.Msg) bool {
+	acl := h.dynamicConfig().acl
+	if acl == nil {
+		return true
+	}
+
+	name := req.Question[0].Name
+	ip := remoteIP(w.RemoteAddr())
+	zone, ok := acl.check(ip, name)
+	if ok {
+		return true
+	}
+
+	glog.V(3).Infof("Refusing query for %q from %v (zone %q)", name, ip, zone)
+	aclRefusedQueries.WithLabelValues(zone).Inc()
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeRefused)
+	w.WriteMsg(m)
+	return false
+}
+
+// recordNamespaceQuery increments the per-namespace query count for
+// queries of the form *.<ns>.svc.<domain>. To bound the number of
+// labels, a namespace is only tracked individually once a query under
+// it has resolved (so garbage queries cannot create labels), and only
+// up to NamespaceMetricsLimit namespaces. Everything else is counted
+// under the "other" label.
+func (h *Handler) recordNamespaceQuery(name string, resp *dns.Msg) {
+	namespace, ok := h.namespaceOf(name)
+	if !ok {
+		return
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		return
+	}
+
+	h.namespacesLock.Lock()
+	tracked := h.namespaces[namespace]
+	if !tracked && resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0 &&
+		len(h.namespaces) < h.options.NamespaceMetricsLimit {
+		glog.V(3).Infof("Tracking query metrics for namespace %q", namespace)
+		h.namespaces[namespace] = true
+		tracked = true
+	}
+	h.namespacesLock.Unlock()
+
+	if !tracked {
+		namespace = otherNamespaceLabel
+	}
+	queriesByNamespace.WithLabelValues(namespace).Inc()
+}
+
+// namespaceOf returns the namespace for a name of the form
+// *.<ns>.svc.<domain>.
+func (h *Handler) namespaceOf(name string) (string, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	if !strings.HasSuffix(name, "."+h.domain) {
+		return "", false
+	}
+	labels := dns.SplitDomainName(strings.TrimSuffix(name, "."+h.domain))
+	if len(labels) < 2 || labels[len(labels)-1] != serviceSubdomain {
+		return "", false
+	}
+	return labels[len(labels)-2], true
+}
+
+// recordingWriter remembers the message written to the client so that it
+// can be inspected after the next handler has returned.
+type recordingWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *recordingWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return w.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	skymsg "github.com/skynetservices/skydns/msg"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDomain = "cluster.local."
+
+// fakeWriter is a dns.ResponseWriter that stores the written message.
+type fakeWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func newFakeWriter(remoteIP string) *fakeWriter {
+	return &fakeWriter{remote: &net.UDPAddr{IP: net.ParseIP(remoteIP), Port: 4321}}
+}
+
+func (w *fakeWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+}
+func (w *fakeWriter) RemoteAddr() net.Addr        { return w.remote }
+func (w *fakeWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *fakeWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *fakeWriter) Close() error                { return nil }
+func (w *fakeWriter) TsigStatus() error           { return nil }
+func (w *fakeWriter) TsigTimersOnly(bool)         {}
+func (w *fakeWriter) Hijack()                     {}
+
+// fakeBackend answers A queries for the names in records and NXDOMAINs
+// everything else.
+type fakeBackend struct {
+	records map[string]string
+}
+
+func (b *fakeBackend) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	name := req.Question[0].Name
+	if ip, ok := b.records[name]; ok {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+			A:   net.ParseIP(ip),
+		})
+	} else {
+		m.Rcode = dns.RcodeNameError
+	}
+	w.WriteMsg(m)
+}
+
+func query(h dns.Handler, name string, qtype uint16) *dns.Msg {
+	return queryFrom(h, "10.1.0.1", name, qtype)
+}
+
+func queryFrom(h dns.Handler, remoteIP string, name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	w := newFakeWriter(remoteIP)
+	h.ServeDNS(w, req)
+	return w.msg
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	c.Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func namespaceCount(namespace string) float64 {
+	return counterValue(queriesByNamespace.WithLabelValues(namespace))
+}
+
+func TestQueriesByNamespace(t *testing.T) {
+	backend := &fakeBackend{records: map[string]string{
+		"a.ns1.svc.cluster.local.": "10.0.0.1",
+		"b.ns2.svc.cluster.local.": "10.0.0.2",
+	}}
+	h := NewHandler(backend, Options{Domain: testDomain, NamespaceMetricsLimit: 10})
+
+	ns1, ns2, other := namespaceCount("ns1"), namespaceCount("ns2"), namespaceCount(otherNamespaceLabel)
+
+	query(h, "a.ns1.svc.cluster.local.", dns.TypeA)
+	query(h, "a.ns1.svc.cluster.local.", dns.TypeA)
+	query(h, "b.ns2.svc.cluster.local.", dns.TypeA)
+	// NXDOMAIN in a tracked namespace.
+	query(h, "missing.ns2.svc.cluster.local.", dns.TypeA)
+	// NXDOMAIN in a namespace that has never resolved.
+	query(h, "x.garbage.svc.cluster.local.", dns.TypeA)
+	// Names outside of the service subdomain are not counted.
+	query(h, "example.com.", dns.TypeA)
+
+	assert.Equal(t, ns1+2, namespaceCount("ns1"))
+	assert.Equal(t, ns2+2, namespaceCount("ns2"))
+	assert.Equal(t, other+1, namespaceCount(otherNamespaceLabel))
+}
+
+func TestQueriesByNamespaceLimit(t *testing.T) {
+	backend := &fakeBackend{records: map[string]string{
+		"a.limited1.svc.cluster.local.": "10.0.0.1",
+		"a.limited2.svc.cluster.local.": "10.0.0.2",
+	}}
+	h := NewHandler(backend, Options{Domain: testDomain, NamespaceMetricsLimit: 1})
+
+	other := namespaceCount(otherNamespaceLabel)
+	query(h, "a.limited1.svc.cluster.local.", dns.TypeA)
+	query(h, "a.limited2.svc.cluster.local.", dns.TypeA)
+
+	assert.Equal(t, float64(1), namespaceCount("limited1"))
+	assert.Equal(t, float64(0), namespaceCount("limited2"))
+	assert.Equal(t, other+1, namespaceCount(otherNamespaceLabel))
+}
+
+func TestNamespaceOf(t *testing.T) {
+	h := NewHandler(&fakeBackend{}, Options{Domain: testDomain})
+	for _, testCase := range []struct {
+		name      string
+		namespace string
+		ok        bool
+	}{
+		{"a.ns.svc.cluster.local.", "ns", true},
+		{"_http._tcp.a.ns.svc.cluster.local.", "ns", true},
+		{"A.NS.SVC.Cluster.Local.", "ns", true},
+		{"svc.cluster.local.", "", false},
+		{"1-2-3-4.ns.pod.cluster.local.", "", false},
+		{"a.ns.svc.example.com.", "", false},
+	} {
+		namespace, ok := h.namespaceOf(testCase.name)
+		assert.Equal(t, testCase.ok, ok, testCase.name)
+		assert.Equal(t, testCase.namespace, namespace, testCase.name)
+	}
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	upstream, stop := startUpstream(t, "192.0.2.1")
+	defer stop()
+
+	backend := &fakeBackend{records: map[string]string{"a.ns.svc.cluster.local.": "10.0.0.1"}}
+	h := NewHandler(backend, Options{Domain: testDomain, Nameservers: []string{upstream}})
+
+	hits, misses := counterValue(cacheHits), counterValue(cacheMisses)
+
+	// Cluster names are answered from the records.
+	for i := 0; i < 2; i++ {
+		resp := query(h, "a.ns.svc.cluster.local.", dns.TypeA)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Equal(t, hits+float64(i+1), counterValue(cacheHits))
+		assert.Equal(t, misses, counterValue(cacheMisses))
+	}
+
+	// Other names are forwarded.
+	resp := query(h, "www.example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Equal(t, "192.0.2.1", resp.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, hits+2, counterValue(cacheHits))
+	assert.Equal(t, misses+1, counterValue(cacheMisses))
+}
+
+// reverseBackend is a skydns Backend with reverse records only.
+type reverseBackend map[string]string
+
+func (b reverseBackend) Records(name string, exact bool) ([]skymsg.Service, error) {
+	return nil, fmt.Errorf("not found")
+}
+
+func (b reverseBackend) ReverseRecord(name string) (*skymsg.Service, error) {
+	if host, ok := b[name]; ok {
+		return &skymsg.Service{Host: host}, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func TestIsLocal(t *testing.T) {
+	h := NewHandler(&fakeBackend{}, Options{
+		Domain:  testDomain,
+		Backend: reverseBackend{"10.0.0.10.in-addr.arpa.": "a.ns.svc.cluster.local."},
+	})
+	for _, testCase := range []struct {
+		name  string
+		qtype uint16
+		local bool
+	}{
+		{"a.ns.svc.cluster.local.", dns.TypeA, true},
+		{"A.NS.SVC.CLUSTER.LOCAL.", dns.TypeA, true},
+		{"cluster.local.", dns.TypeSOA, true},
+		{"www.example.com.", dns.TypeA, false},
+		{"notcluster.local.", dns.TypeA, false},
+		{"10.0.0.10.in-addr.arpa.", dns.TypePTR, true},
+		{"8.8.8.8.in-addr.arpa.", dns.TypePTR, false},
+	} {
+		q := dns.Question{Name: testCase.name, Qtype: testCase.qtype, Qclass: dns.ClassINET}
+		assert.Equal(t, testCase.local, h.isLocal(q), "%+v", testCase)
+	}
+}
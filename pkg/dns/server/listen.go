@@ -0,0 +1,72 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// TCPOptions tune the TCP listener. The zero value gives the listener
+// of net.Listen().
+type TCPOptions struct {
+	// DisableReuseAddr clears SO_REUSEADDR, which the Go runtime sets on
+	// listeners so that they can be bound while connections of a previous
+	// instance are in TIME_WAIT.
+	DisableReuseAddr bool
+	// KeepAlivePeriod enables TCP keepalives with this period on the
+	// accepted connections. 0 leaves keepalives disabled.
+	KeepAlivePeriod time.Duration
+	// FastOpenQueueLength enables TCP Fast Open (RFC 7413) with this
+	// maximum number of pending Fast Open requests. 0 disables it. It is
+	// only supported on Linux.
+	FastOpenQueueLength int
+}
+
+// listenTCP returns a TCP listener on addr with options applied.
+func listenTCP(addr string, options TCPOptions) (net.Listener, error) {
+	var l net.Listener
+	var err error
+	if options.DisableReuseAddr || options.FastOpenQueueLength > 0 {
+		l, err = listenTCPWithSocketOptions(addr, options)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if options.KeepAlivePeriod > 0 {
+		l = &keepAliveListener{TCPListener: l.(*net.TCPListener), period: options.KeepAlivePeriod}
+	}
+	return l, nil
+}
+
+// keepAliveListener enables TCP keepalives on the accepted connections.
+type keepAliveListener struct {
+	*net.TCPListener
+	period time.Duration
+}
+
+func (l *keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetKeepAlive(true)
+	conn.SetKeepAlivePeriod(l.period)
+	return conn, nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// tcpFastOpen is the TCP_FASTOPEN socket option, which the syscall
+// package does not define.
+const tcpFastOpen = 0x17
+
+// listenTCPWithSocketOptions creates the listening socket for addr
+// itself, so that the socket options are set before it is bound. The
+// socket is IPv4 or IPv6 depending on the address; unspecified addresses
+// are taken as 0.0.0.0.
+func listenTCPWithSocketOptions(addr string, options TCPOptions) (net.Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	family := syscall.AF_INET
+	var sockaddr syscall.Sockaddr
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil || tcpAddr.IP == nil {
+		sa := &syscall.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa.Addr[:], ip4)
+		sockaddr = sa
+	} else {
+		family = syscall.AF_INET6
+		sa := &syscall.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa.Addr[:], tcpAddr.IP.To16())
+		sockaddr = sa
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_TCP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	// net.FileListener() uses a duplicate of the descriptor.
+	file := os.NewFile(uintptr(fd), "tcp:"+addr)
+	defer file.Close()
+
+	reuseAddr := 1
+	if options.DisableReuseAddr {
+		reuseAddr = 0
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, reuseAddr); err != nil {
+		return nil, os.NewSyscallError("setsockopt SO_REUSEADDR", err)
+	}
+	if options.FastOpenQueueLength > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, tcpFastOpen, options.FastOpenQueueLength); err != nil {
+			return nil, os.NewSyscallError("setsockopt TCP_FASTOPEN", err)
+		}
+	}
+	if err := syscall.Bind(fd, sockaddr); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		return nil, os.NewSyscallError("listen", err)
+	}
+	return net.FileListener(file)
+}
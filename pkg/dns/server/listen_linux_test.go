@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sockopt returns the value of a socket option of conn, which must be a
+// *net.TCPListener or *net.TCPConn.
+func sockopt(t *testing.T, conn interface{}, level, opt int) int {
+	var f interface {
+		Fd() uintptr
+		Close() error
+	}
+	var err error
+	switch conn := conn.(type) {
+	case *net.TCPListener:
+		f, err = conn.File()
+	case *net.TCPConn:
+		f, err = conn.File()
+	default:
+		t.Fatalf("unexpected %T", conn)
+	}
+	require.NoError(t, err)
+	defer f.Close()
+	value, err := syscall.GetsockoptInt(int(f.Fd()), level, opt)
+	require.NoError(t, err)
+	return value
+}
+
+func TestListenTCP(t *testing.T) {
+	// The defaults are those of net.Listen().
+	l, err := listenTCP("127.0.0.1:0", TCPOptions{})
+	require.NoError(t, err)
+	defer l.Close()
+	require.IsType(t, &net.TCPListener{}, l)
+	assert.Equal(t, 1, sockopt(t, l, syscall.SOL_SOCKET, syscall.SO_REUSEADDR))
+	assert.Equal(t, 0, sockopt(t, l, syscall.IPPROTO_TCP, tcpFastOpen))
+
+	for _, addr := range []string{"127.0.0.1:0", "[::1]:0"} {
+		l, err = listenTCP(addr, TCPOptions{
+			DisableReuseAddr:    true,
+			KeepAlivePeriod:     30 * time.Second,
+			FastOpenQueueLength: 16,
+		})
+		if err != nil && addr == "[::1]:0" {
+			t.Logf("Skipping IPv6: %v", err)
+			continue
+		}
+		require.NoError(t, err, addr)
+		defer l.Close()
+		require.IsType(t, &keepAliveListener{}, l, addr)
+		tcpListener := l.(*keepAliveListener).TCPListener
+		assert.Equal(t, 0, sockopt(t, tcpListener, syscall.SOL_SOCKET, syscall.SO_REUSEADDR), addr)
+		assert.Equal(t, 16, sockopt(t, tcpListener, syscall.IPPROTO_TCP, tcpFastOpen), addr)
+
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := l.Accept()
+			assert.NoError(t, err)
+			accepted <- conn
+		}()
+		client, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err, addr)
+		defer client.Close()
+		conn := <-accepted
+		require.NotNil(t, conn, addr)
+		defer conn.Close()
+		assert.Equal(t, 1, sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE), addr)
+		assert.Equal(t, 30, sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE), addr)
+	}
+}
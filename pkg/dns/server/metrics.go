@@ -0,0 +1,212 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// prometheusNamespace for all of the metrics exported by kube-dns.
+	prometheusNamespace = "kubedns"
+
+	// otherNamespaceLabel is used for queries whose namespace is not
+	// tracked individually.
+	otherNamespaceLabel = "other"
+)
+
+var (
+	queriesByNamespace = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "queries_by_namespace_total",
+			Help:      "Count of queries for names under <namespace>.svc.<domain>",
+		},
+		[]string{"namespace"})
+
+	aclRefusedQueries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "acl_refused_queries_total",
+			Help:      "Count of queries refused by the query ACLs, by zone",
+		},
+		[]string{"zone"})
+
+	noUpstreamQueries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "no_upstream_queries_total",
+			Help:      "Count of queries for external names answered without forwarding because upstream forwarding is disabled",
+		})
+
+	refusedClassQueries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "refused_class_queries_total",
+			Help:      "Count of queries refused because their class is not supported",
+		})
+
+	cacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "cache_hit_total",
+			Help:      "Count of queries answered from the in-memory records",
+		})
+
+	cacheMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "cache_miss_total",
+			Help:      "Count of queries forwarded to an upstream nameserver",
+		})
+
+	responseBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "response_bytes_total",
+			Help:      "Total size of the responses, with and without name compression",
+		},
+		[]string{"compression"})
+
+	zoneTransfers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "zone_transfers_total",
+			Help:      "Count of zone transfer requests, by result (transferred, refused or failed)",
+		},
+		[]string{"result"})
+
+	upstreamQueries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "upstream_queries_total",
+			Help:      "Count of forwarded queries, by the upstream nameserver that answered them",
+		},
+		[]string{"upstream"})
+
+	upstreamRefusedQueries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "upstream_refused_queries_total",
+			Help:      "Count of queries refused because too many upstream queries were in flight",
+		})
+
+	upstreamDeadlineExceeded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "upstream_deadline_exceeded_total",
+			Help:      "Count of queries whose forwarding was abandoned at the upstream deadline",
+		})
+
+	upstreamFallbackQueries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "upstream_fallback_queries_total",
+			Help:      "Count of queries forwarded to the fallback upstream nameservers",
+		})
+
+	upstreamTruncatedRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "upstream_truncated_retries_total",
+			Help:      "Count of queries retried over TCP because the upstream UDP response was truncated",
+		})
+
+	upstreamRejectedAnswers = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "upstream_rejected_answers_total",
+			Help:      "Count of upstream responses rejected because an address in the answer is in the deny networks",
+		})
+
+	upstreamTCPExchanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "upstream_tcp_exchanges_total",
+			Help:      "Count of upstream TCP exchanges, by whether the connection was new or reused",
+		},
+		[]string{"connection"})
+
+	upstreamTCPPoolSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Name:      "upstream_tcp_pool_idle_connections",
+			Help:      "Number of idle upstream TCP connections kept for reuse",
+		})
+
+	watchdogStalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "watchdog_stalls_total",
+			Help:      "Count of times queries stopped being processed, by protocol",
+		},
+		[]string{"protocol"})
+
+	rrlLimitedResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "rrl_limited_responses_total",
+			Help:      "Count of responses limited by response rate limiting, by action (dropped or slipped)",
+		},
+		[]string{"action"})
+
+	cookieTruncatedResponses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      "cookie_truncated_responses_total",
+			Help:      "Count of UDP responses truncated because the client had no valid server cookie",
+		})
+
+	selfResolveOK = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Name:      "self_resolve_ok",
+			Help:      "1 if the last self check query resolved, 0 otherwise",
+		})
+
+	handlerQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Name:      "handler_queue_depth",
+			Help:      "Number of queries waiting for a free handler worker",
+		},
+		[]string{"protocol"})
+)
+
+func init() {
+	prometheus.MustRegister(queriesByNamespace)
+	prometheus.MustRegister(aclRefusedQueries)
+	prometheus.MustRegister(refusedClassQueries)
+	prometheus.MustRegister(noUpstreamQueries)
+	prometheus.MustRegister(cacheHits)
+	prometheus.MustRegister(cacheMisses)
+	prometheus.MustRegister(responseBytes)
+	prometheus.MustRegister(zoneTransfers)
+	prometheus.MustRegister(upstreamQueries)
+	prometheus.MustRegister(upstreamRefusedQueries)
+	prometheus.MustRegister(upstreamDeadlineExceeded)
+	prometheus.MustRegister(upstreamFallbackQueries)
+	prometheus.MustRegister(upstreamTruncatedRetries)
+	prometheus.MustRegister(upstreamRejectedAnswers)
+	prometheus.MustRegister(upstreamTCPExchanges)
+	prometheus.MustRegister(upstreamTCPPoolSize)
+	prometheus.MustRegister(handlerQueueDepth)
+	prometheus.MustRegister(watchdogStalls)
+	prometheus.MustRegister(selfResolveOK)
+	prometheus.MustRegister(rrlLimitedResponses)
+	prometheus.MustRegister(cookieTruncatedResponses)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+// minimalWriter strips the authority section and the additional records
+// (but for the OPT record) from positive responses, for clients that
+// only handle the answer section. Negative responses keep their SOA,
+// which clients need to cache them.
+type minimalWriter struct {
+	dns.ResponseWriter
+}
+
+func (w *minimalWriter) WriteMsg(m *dns.Msg) error {
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) == 0 {
+		return w.ResponseWriter.WriteMsg(m)
+	}
+	resp := *m
+	resp.Ns = nil
+	resp.Extra = nil
+	for _, rr := range m.Extra {
+		if _, ok := rr.(*dns.OPT); ok {
+			resp.Extra = append(resp.Extra, rr)
+		}
+	}
+	return w.ResponseWriter.WriteMsg(&resp)
+}
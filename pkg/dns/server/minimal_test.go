@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verboseBackend answers A queries for a.ns1.svc.cluster.local. with an
+// SOA in the authority section and an additional record, and NXDOMAINs
+// everything else.
+var verboseBackend = dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	name := req.Question[0].Name
+	soa := &dns.SOA{
+		Hdr: dns.RR_Header{Name: testDomain, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:  "ns.dns." + testDomain, Mbox: "hostmaster." + testDomain,
+	}
+	if name == "a.ns1.svc.cluster.local." {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+			A:   net.ParseIP("10.0.0.1"),
+		})
+		m.Ns = append(m.Ns, soa)
+		m.Extra = append(m.Extra, &dns.A{
+			Hdr: dns.RR_Header{Name: "ns.dns." + testDomain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+			A:   net.ParseIP("10.0.0.53"),
+		})
+	} else {
+		m.Rcode = dns.RcodeNameError
+		m.Ns = append(m.Ns, soa)
+	}
+	if opt := req.IsEdns0(); opt != nil {
+		m.SetEdns0(opt.UDPSize(), false)
+	}
+	w.WriteMsg(m)
+})
+
+func TestMinimalResponses(t *testing.T) {
+	exchange := func(h dns.Handler, name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		req.SetEdns0(dns.DefaultMsgSize, false)
+		w := newFakeWriter("10.1.0.1")
+		h.ServeDNS(w, req)
+		return w.msg
+	}
+
+	// By default, the authority and additional sections are kept.
+	h := NewHandler(verboseBackend, Options{Domain: testDomain})
+	resp := exchange(h, "a.ns1.svc.cluster.local.")
+	require.Len(t, resp.Answer, 1)
+	assert.Len(t, resp.Ns, 1)
+	assert.Len(t, resp.Extra, 2)
+
+	h = NewHandler(verboseBackend, Options{Domain: testDomain, MinimalResponses: true})
+	resp = exchange(h, "a.ns1.svc.cluster.local.")
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "10.0.0.1", resp.Answer[0].(*dns.A).A.String())
+	assert.Empty(t, resp.Ns)
+	// Only the OPT record is left.
+	require.Len(t, resp.Extra, 1)
+	assert.NotNil(t, resp.IsEdns0())
+
+	// Negative responses keep their SOA.
+	resp = exchange(h, "missing.ns1.svc.cluster.local.")
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+	require.Len(t, resp.Ns, 1)
+	assert.Equal(t, dns.TypeSOA, resp.Ns[0].Header().Rrtype)
+}
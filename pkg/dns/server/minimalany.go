@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+// minimalAnyCPU is the CPU field of the synthesized HINFO record, as
+// suggested by RFC 8482.
+const minimalAnyCPU = "RFC8482"
+
+// serveMinimalAny answers req with a single synthesized HINFO record (RFC
+// 8482) if MinimalAny is set and req is an ANY query for a name with
+// records in the backend. It returns false if req is left to the next
+// handler, which refuses ANY queries.
+func (h *Handler) serveMinimalAny(w dns.ResponseWriter, req *dns.Msg) bool {
+	if !h.options.MinimalAny || len(req.Question) == 0 || h.options.Backend == nil {
+		return false
+	}
+	q := req.Question[0]
+	if q.Qtype != dns.TypeANY || q.Qclass != dns.ClassINET {
+		return false
+	}
+	records, err := h.options.Backend.Records(q.Name, false)
+	if err != nil || len(records) == 0 {
+		return false
+	}
+	ttl := records[0].Ttl
+	for _, record := range records[1:] {
+		if record.Ttl < ttl {
+			ttl = record.Ttl
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	m.RecursionAvailable = true
+	if opt := req.IsEdns0(); opt != nil {
+		m.SetEdns0(opt.UDPSize(), false)
+	}
+	m.Answer = []dns.RR{&dns.HINFO{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: ttl},
+		Cpu: minimalAnyCPU,
+	}}
+	w.WriteMsg(m)
+	return true
+}
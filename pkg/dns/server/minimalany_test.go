@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinimalAny(t *testing.T) {
+	for _, minimalAny := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		addrs := runTestServer(ctx, t, Options{MinimalAny: minimalAny})
+
+		exchange := func(name string) *dns.Msg {
+			dnsClient := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+			req := new(dns.Msg)
+			req.SetQuestion(name, dns.TypeANY)
+			resp, _, err := dnsClient.Exchange(req, addrs.UDP.String())
+			require.NoError(t, err)
+			return resp
+		}
+
+		resp := exchange("mysvc.default.svc.cluster.local.")
+		if !minimalAny {
+			assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+			assert.Empty(t, resp.Answer)
+			continue
+		}
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		require.Len(t, resp.Answer, 1)
+		hinfo, ok := resp.Answer[0].(*dns.HINFO)
+		require.True(t, ok, "%v", resp.Answer[0])
+		assert.Equal(t, "mysvc.default.svc.cluster.local.", hinfo.Hdr.Name)
+		assert.Equal(t, "RFC8482", hinfo.Cpu)
+		assert.Equal(t, "", hinfo.Os)
+		assert.Equal(t, uint32(30), hinfo.Hdr.Ttl)
+
+		// Names without records are left to skydns.
+		resp = exchange("missing.default.svc.cluster.local.")
+		assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+	}
+}
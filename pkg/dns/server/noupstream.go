@@ -0,0 +1,64 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// NoUpstreamResponse is the response to queries for names outside of the
+// cluster domain and stub domains when upstream forwarding is disabled.
+type NoUpstreamResponse string
+
+const (
+	// NoUpstreamNXDomain answers with NXDOMAIN.
+	NoUpstreamNXDomain NoUpstreamResponse = "nxdomain"
+	// NoUpstreamRefused answers with REFUSED.
+	NoUpstreamRefused NoUpstreamResponse = "refused"
+)
+
+// ValidateNoUpstreamResponse returns an error if response is not one of
+// the NoUpstreamResponse values.
+func ValidateNoUpstreamResponse(response string) error {
+	switch NoUpstreamResponse(response) {
+	case NoUpstreamNXDomain, NoUpstreamRefused:
+		return nil
+	}
+	return fmt.Errorf("invalid no upstream response %q, must be %q or %q",
+		response, NoUpstreamNXDomain, NoUpstreamRefused)
+}
+
+// serveNoUpstream answers req with the NoUpstreamResponse if upstream
+// forwarding is disabled and req is for an external name. It returns
+// whether req was answered.
+func (h *Handler) serveNoUpstream(w dns.ResponseWriter, req *dns.Msg) bool {
+	if !h.options.NoUpstream || len(req.Question) == 0 || h.isLocal(req.Question[0]) {
+		return false
+	}
+
+	rcode := dns.RcodeNameError
+	if h.options.NoUpstreamResponse == NoUpstreamRefused {
+		rcode = dns.RcodeRefused
+	}
+	noUpstreamQueries.Inc()
+	m := new(dns.Msg)
+	m.SetRcode(req, rcode)
+	w.WriteMsg(m)
+	return true
+}
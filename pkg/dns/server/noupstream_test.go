@@ -0,0 +1,86 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+func TestNoUpstream(t *testing.T) {
+	var forwarded int32
+	upstream, stop := startUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&forwarded, 1)
+		m := new(dns.Msg)
+		m.SetReply(req)
+		w.WriteMsg(m)
+	}))
+	defer stop()
+	stub, stop := startUpstream(t, "192.0.2.3")
+	defer stop()
+
+	source := &staticConfig{config: &config.Config{
+		StubDomains:         map[string][]string{"acme.local": {stub}},
+		UpstreamNameservers: []string{upstream},
+	}}
+	backend := &fakeBackend{records: map[string]string{"a.ns1.svc.cluster.local.": "10.0.0.1"}}
+
+	for _, testCase := range []struct {
+		response NoUpstreamResponse
+		rcode    int
+	}{
+		{"", dns.RcodeNameError},
+		{NoUpstreamNXDomain, dns.RcodeNameError},
+		{NoUpstreamRefused, dns.RcodeRefused},
+	} {
+		h := NewHandler(backend, Options{
+			Domain:             testDomain,
+			Nameservers:        []string{upstream},
+			Config:             source,
+			NoUpstream:         true,
+			NoUpstreamResponse: testCase.response,
+		})
+		before := counterValue(noUpstreamQueries)
+
+		resp := query(h, "www.example.com.", dns.TypeA)
+		assert.Equal(t, testCase.rcode, resp.Rcode, string(testCase.response))
+		assert.Empty(t, resp.Answer)
+		assert.Equal(t, float64(1), counterValue(noUpstreamQueries)-before)
+
+		// Cluster names and stub domains are still answered.
+		resp = query(h, "a.ns1.svc.cluster.local.", dns.TypeA)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Len(t, resp.Answer, 1)
+		resp = query(h, "www.acme.local.", dns.TypeA)
+		require.Len(t, resp.Answer, 1)
+		assert.Equal(t, "192.0.2.3", resp.Answer[0].(*dns.A).A.String())
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&forwarded))
+}
+
+func TestValidateNoUpstreamResponse(t *testing.T) {
+	assert.NoError(t, ValidateNoUpstreamResponse("nxdomain"))
+	assert.NoError(t, ValidateNoUpstreamResponse("refused"))
+	assert.Error(t, ValidateNoUpstreamResponse(""))
+	assert.Error(t, ValidateNoUpstreamResponse("servfail"))
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// AnswerOrder is the order of the records of each RRset (the records with
+// the same name and type) in the answer section.
+type AnswerOrder string
+
+const (
+	// AnswerOrderStable keeps the order of the record source: the order
+	// of the records from skydns, or from the upstream nameserver.
+	AnswerOrderStable AnswerOrder = "stable"
+	// AnswerOrderSorted sorts each RRset by record data, so that answers
+	// are deterministic.
+	AnswerOrderSorted AnswerOrder = "sorted"
+	// AnswerOrderRoundRobin rotates each RRset by one record on every
+	// query, spreading clients that use the first record.
+	AnswerOrderRoundRobin AnswerOrder = "roundrobin"
+)
+
+// ValidateAnswerOrder returns an error if order is not one of the
+// AnswerOrder values.
+func ValidateAnswerOrder(order string) error {
+	switch AnswerOrder(order) {
+	case AnswerOrderStable, AnswerOrderSorted, AnswerOrderRoundRobin:
+		return nil
+	}
+	return fmt.Errorf("invalid answer order %q, must be %q, %q or %q",
+		order, AnswerOrderStable, AnswerOrderSorted, AnswerOrderRoundRobin)
+}
+
+// orderAnswer reorders the RRsets in the answer section of m. CNAMEs are
+// left in place, as resolvers expect the chain in order. rotation is the
+// number of records each RRset is rotated by for AnswerOrderRoundRobin.
+func orderAnswer(m *dns.Msg, order AnswerOrder, rotation uint32) {
+	for start := 0; start < len(m.Answer); {
+		end := start + 1
+		for end < len(m.Answer) && sameRRset(m.Answer[start], m.Answer[end]) {
+			end++
+		}
+		rrset := m.Answer[start:end]
+		if len(rrset) > 1 && rrset[0].Header().Rrtype != dns.TypeCNAME {
+			switch order {
+			case AnswerOrderSorted:
+				sort.Sort(byData(rrset))
+			case AnswerOrderRoundRobin:
+				rotate(rrset, int(rotation%uint32(len(rrset))))
+			}
+		}
+		start = end
+	}
+}
+
+func sameRRset(a, b dns.RR) bool {
+	return a.Header().Rrtype == b.Header().Rrtype &&
+		a.Header().Class == b.Header().Class &&
+		strings.ToLower(a.Header().Name) == strings.ToLower(b.Header().Name)
+}
+
+// rotate rrs left by n.
+func rotate(rrs []dns.RR, n int) {
+	rotated := append(append([]dns.RR{}, rrs[n:]...), rrs[:n]...)
+	copy(rrs, rotated)
+}
+
+// byData sorts the records of an RRset by their data (everything after
+// the header).
+type byData []dns.RR
+
+func (rrs byData) Len() int      { return len(rrs) }
+func (rrs byData) Swap(i, j int) { rrs[i], rrs[j] = rrs[j], rrs[i] }
+func (rrs byData) Less(i, j int) bool {
+	return rrData(rrs[i]) < rrData(rrs[j])
+}
+
+func rrData(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// orderWriter reorders the answer section of the response.
+type orderWriter struct {
+	dns.ResponseWriter
+	h *Handler
+}
+
+func (w *orderWriter) WriteMsg(m *dns.Msg) error {
+	orderAnswer(m, w.h.options.AnswerOrder, atomic.AddUint32(&w.h.answerRotation, 1))
+	return w.ResponseWriter.WriteMsg(m)
+}
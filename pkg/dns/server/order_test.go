@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// multiRecordBackend answers with a CNAME chain followed by the A records
+// of the target, in the order of ips.
+type multiRecordBackend struct {
+	ips []string
+}
+
+func (b *multiRecordBackend) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	name := req.Question[0].Name
+	for _, prefix := range []string{"b.", "c."} {
+		m.Answer = append(m.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 30},
+			Target: prefix + name,
+		})
+		name = prefix + name
+	}
+	for _, ip := range b.ips {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+			A:   net.ParseIP(ip),
+		})
+	}
+	w.WriteMsg(m)
+}
+
+// answerOrder returns the CNAME targets and addresses in the answer to a
+// query for name.
+func answerOrder(h dns.Handler, name string) []string {
+	var order []string
+	for _, rr := range query(h, name, dns.TypeA).Answer {
+		switch rr := rr.(type) {
+		case *dns.CNAME:
+			order = append(order, rr.Target)
+		case *dns.A:
+			order = append(order, rr.A.String())
+		}
+	}
+	return order
+}
+
+func TestAnswerOrder(t *testing.T) {
+	const name = "a.ns.svc.cluster.local."
+	backend := &multiRecordBackend{ips: []string{"10.0.0.3", "10.0.0.1", "10.0.0.2"}}
+	cnames := []string{"b." + name, "c.b." + name}
+
+	for _, order := range []AnswerOrder{"", AnswerOrderStable} {
+		h := NewHandler(backend, Options{Domain: testDomain, AnswerOrder: order})
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, append(cnames, "10.0.0.3", "10.0.0.1", "10.0.0.2"),
+				answerOrder(h, name), "%q", order)
+		}
+	}
+
+	h := NewHandler(backend, Options{Domain: testDomain, AnswerOrder: AnswerOrderSorted})
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, append(cnames, "10.0.0.1", "10.0.0.2", "10.0.0.3"), answerOrder(h, name))
+	}
+
+	// Each query rotates the addresses by one more record.
+	h = NewHandler(backend, Options{Domain: testDomain, AnswerOrder: AnswerOrderRoundRobin})
+	first := make(map[string]int)
+	var previous []string
+	for i := 0; i < 6; i++ {
+		order := answerOrder(h, name)
+		assert.Equal(t, cnames, order[:2])
+		addrs := order[2:]
+		assert.Len(t, addrs, 3)
+		if previous != nil {
+			assert.Equal(t, append(previous[1:], previous[0]), addrs)
+		}
+		previous = addrs
+		first[addrs[0]]++
+	}
+	assert.Equal(t, map[string]int{"10.0.0.1": 2, "10.0.0.2": 2, "10.0.0.3": 2}, first)
+}
+
+func TestValidateAnswerOrder(t *testing.T) {
+	for _, order := range []string{"stable", "sorted", "roundrobin"} {
+		assert.NoError(t, ValidateAnswerOrder(order), order)
+	}
+	for _, order := range []string{"", "random", "Sorted"} {
+		assert.Error(t, ValidateAnswerOrder(order), order)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+const (
+	// rrlIPv4PrefixLength and rrlIPv6PrefixLength are the sizes of the
+	// client subnets that share a rate limit.
+	rrlIPv4PrefixLength = 24
+	rrlIPv6PrefixLength = 56
+)
+
+type rrlAction int
+
+const (
+	rrlSend rrlAction = iota
+	// rrlSlip sends an empty truncated response instead, so that a
+	// legitimate client can retry over TCP.
+	rrlSlip
+	rrlDrop
+)
+
+// rateLimiter limits the rate of identical responses sent to a client
+// subnet (response rate limiting). Each subnet and response gets a token
+// bucket holding up to one second of responses.
+type rateLimiter struct {
+	rate float64
+	// slip is the ratio of the limited responses that are slipped rather
+	// than dropped, e.g. 2 slips every other one. 0 drops all of them.
+	slip int
+
+	// lock protects buckets and lastSweep.
+	lock      sync.Mutex
+	buckets   map[string]*rrlBucket
+	lastSweep time.Time
+}
+
+type rrlBucket struct {
+	tokens float64
+	last   time.Time
+	// limited is the number of responses that were limited.
+	limited int
+}
+
+func newRateLimiter(responsesPerSecond, slip int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(responsesPerSecond),
+		slip:    slip,
+		buckets: make(map[string]*rrlBucket),
+	}
+}
+
+// check returns what to do with response m to the client ip.
+func (r *rateLimiter) check(ip net.IP, m *dns.Msg, now time.Time) rrlAction {
+	key := rrlKey(ip, m)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sweep(now)
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &rrlBucket{tokens: r.rate}
+		r.buckets[key] = b
+	} else if b.tokens += now.Sub(b.last).Seconds() * r.rate; b.tokens > r.rate {
+		b.tokens = r.rate
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return rrlSend
+	}
+	b.limited++
+	if b.limited == 1 {
+		glog.V(3).Infof("Rate limiting responses %q", key)
+	}
+	if r.slip > 0 && b.limited%r.slip == 0 {
+		return rrlSlip
+	}
+	return rrlDrop
+}
+
+// sweep removes the buckets that have refilled, as they are equivalent to
+// new ones. r.lock must be held.
+func (r *rateLimiter) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < time.Second {
+		return
+	}
+	r.lastSweep = now
+	for key, b := range r.buckets {
+		if now.Sub(b.last) >= time.Second {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// rrlKey identifies the client subnet of ip and the response m.
+func rrlKey(ip net.IP, m *dns.Msg) string {
+	var subnet net.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		subnet = ip4.Mask(net.CIDRMask(rrlIPv4PrefixLength, 32))
+	} else {
+		subnet = ip.Mask(net.CIDRMask(rrlIPv6PrefixLength, 128))
+	}
+	var name string
+	var qtype uint16
+	if len(m.Question) > 0 {
+		name, qtype = strings.ToLower(m.Question[0].Name), m.Question[0].Qtype
+	}
+	return fmt.Sprintf("%v %v %v %v", subnet, name, qtype, m.Rcode)
+}
+
+// rrlWriter applies response rate limiting to the responses to a UDP
+// client.
+type rrlWriter struct {
+	dns.ResponseWriter
+	rrl *rateLimiter
+}
+
+func (w *rrlWriter) WriteMsg(m *dns.Msg) error {
+	switch w.rrl.check(remoteIP(w.RemoteAddr()), m, time.Now()) {
+	case rrlSlip:
+		rrlLimitedResponses.WithLabelValues("slipped").Inc()
+		slipped := new(dns.Msg)
+		slipped.MsgHdr = m.MsgHdr
+		slipped.Question = m.Question
+		slipped.Truncated = true
+		return w.ResponseWriter.WriteMsg(slipped)
+	case rrlDrop:
+		rrlLimitedResponses.WithLabelValues("dropped").Inc()
+		return nil
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
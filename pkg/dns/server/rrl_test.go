@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRRL(t *testing.T) {
+	backend := &fakeBackend{records: map[string]string{"a.ns1.svc.cluster.local.": "10.0.0.1"}}
+	h := NewHandler(backend, Options{
+		Domain:                testDomain,
+		RRLResponsesPerSecond: 5,
+		RRLSlip:               2,
+	})
+
+	dropped := rrlLimitedResponses.WithLabelValues("dropped")
+	slipped := rrlLimitedResponses.WithLabelValues("slipped")
+	droppedBefore, slippedBefore := counterValue(dropped), counterValue(slipped)
+
+	var answered, truncated, none int
+	for i := 0; i < 20; i++ {
+		resp := queryFrom(h, "10.1.0.1", "a.ns1.svc.cluster.local.", dns.TypeA)
+		switch {
+		case resp == nil:
+			none++
+		case resp.Truncated:
+			assert.Empty(t, resp.Answer)
+			truncated++
+		default:
+			assert.Len(t, resp.Answer, 1)
+			answered++
+		}
+	}
+	assert.Equal(t, 5, answered)
+	assert.Equal(t, 7, truncated)
+	assert.Equal(t, 8, none)
+	assert.Equal(t, float64(8), counterValue(dropped)-droppedBefore)
+	assert.Equal(t, float64(7), counterValue(slipped)-slippedBefore)
+
+	// Other subnets, other responses and TCP are not limited.
+	resp := queryFrom(h, "10.1.1.1", "a.ns1.svc.cluster.local.", dns.TypeA)
+	assert.Len(t, resp.Answer, 1)
+	resp = queryFrom(h, "10.1.0.1", "b.ns1.svc.cluster.local.", dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+	w := &fakeWriter{remote: &net.TCPAddr{IP: net.ParseIP("10.1.0.1"), Port: 4321}}
+	req := new(dns.Msg)
+	req.SetQuestion("a.ns1.svc.cluster.local.", dns.TypeA)
+	h.ServeDNS(w, req)
+	assert.Len(t, w.msg.Answer, 1)
+}
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter(2, 0)
+	m := new(dns.Msg)
+	m.SetQuestion("a.ns1.svc.cluster.local.", dns.TypeA)
+	ip := net.ParseIP("10.1.0.1")
+	now := time.Now()
+
+	assert.Equal(t, rrlSend, r.check(ip, m, now))
+	assert.Equal(t, rrlSend, r.check(ip, m, now))
+	assert.Equal(t, rrlDrop, r.check(ip, m, now))
+	// The same /24 shares the limit.
+	assert.Equal(t, rrlDrop, r.check(net.ParseIP("10.1.0.2"), m, now))
+
+	// Tokens come back at the rate.
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, rrlSend, r.check(ip, m, now))
+	assert.Equal(t, rrlDrop, r.check(ip, m, now))
+
+	// Refilled buckets are swept.
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, rrlSend, r.check(net.ParseIP("10.2.0.1"), m, now))
+	assert.Len(t, r.buckets, 1)
+}
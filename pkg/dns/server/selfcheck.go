@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// selfCheck periodically resolves a well-known name through the request
+// path to check that the server can actually answer queries.
+type selfCheck struct {
+	handler dns.Handler
+	name    string
+	// maxFailures is the number of consecutive failures after which the
+	// check is unhealthy. 0 means never.
+	maxFailures int
+
+	// failures is the number of consecutive failed checks.
+	failures int64
+}
+
+func newSelfCheck(handler dns.Handler, name string, maxFailures int) *selfCheck {
+	return &selfCheck{handler: handler, name: dns.Fqdn(name), maxFailures: maxFailures}
+}
+
+// run checks every interval until stop is closed.
+func (c *selfCheck) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.check()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check resolves the name, returning whether it has an address.
+func (c *selfCheck) check() bool {
+	req := new(dns.Msg)
+	req.SetQuestion(c.name, dns.TypeA)
+	w := &loopbackWriter{}
+	c.handler.ServeDNS(w, req)
+
+	if w.msg != nil && w.msg.Rcode == dns.RcodeSuccess && len(w.msg.Answer) > 0 {
+		if atomic.SwapInt64(&c.failures, 0) > 0 {
+			glog.V(0).Infof("Self check for %q succeeded", c.name)
+		}
+		selfResolveOK.Set(1)
+		return true
+	}
+
+	failures := atomic.AddInt64(&c.failures, 1)
+	rcode := "no response"
+	if w.msg != nil {
+		rcode = dns.RcodeToString[w.msg.Rcode]
+	}
+	glog.Warningf("Self check for %q failed (%v), %d times in a row", c.name, rcode, failures)
+	selfResolveOK.Set(0)
+	return false
+}
+
+// healthy returns an error if the check has failed maxFailures times in
+// a row.
+func (c *selfCheck) healthy() error {
+	failures := atomic.LoadInt64(&c.failures)
+	if c.maxFailures > 0 && failures >= int64(c.maxFailures) {
+		return fmt.Errorf("self check for %q failed %d times in a row", c.name, failures)
+	}
+	return nil
+}
+
+// loopbackWriter is a dns.ResponseWriter for queries issued by kube-dns
+// itself.
+type loopbackWriter struct {
+	msg *dns.Msg
+}
+
+var loopbackAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+func (w *loopbackWriter) LocalAddr() net.Addr         { return loopbackAddr }
+func (w *loopbackWriter) RemoteAddr() net.Addr        { return loopbackAddr }
+func (w *loopbackWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *loopbackWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *loopbackWriter) Close() error                { return nil }
+func (w *loopbackWriter) TsigStatus() error           { return nil }
+func (w *loopbackWriter) TsigTimersOnly(bool)         {}
+func (w *loopbackWriter) Hijack()                     {}
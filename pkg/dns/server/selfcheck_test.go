@@ -0,0 +1,50 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfCheck(t *testing.T) {
+	const name = "kubernetes.default.svc.cluster.local"
+	backend := &fakeBackend{}
+	c := newSelfCheck(NewHandler(backend, Options{Domain: testDomain}), name, 2)
+
+	// The backend has no records.
+	assert.False(t, c.check())
+	assert.Equal(t, float64(0), gaugeValue(selfResolveOK))
+	assert.NoError(t, c.healthy())
+	assert.False(t, c.check())
+	assert.Error(t, c.healthy())
+
+	backend.records = map[string]string{name + ".": "10.0.0.1"}
+	assert.True(t, c.check())
+	assert.Equal(t, float64(1), gaugeValue(selfResolveOK))
+	assert.NoError(t, c.healthy())
+
+	// Without a failure limit the check is always healthy.
+	backend.records = nil
+	c = newSelfCheck(NewHandler(backend, Options{Domain: testDomain}), name, 0)
+	for i := 0; i < 5; i++ {
+		assert.False(t, c.check())
+	}
+	assert.Equal(t, float64(0), gaugeValue(selfResolveOK))
+	assert.NoError(t, c.healthy())
+}
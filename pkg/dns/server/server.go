@@ -0,0 +1,249 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+	skyserver "github.com/skynetservices/skydns/server"
+
+	"k8s.io/client-go/pkg/util/clock"
+)
+
+// ServerOptions for a kube-dns Server.
+type ServerOptions struct {
+	// Options for the request path. Options.Domain is also used as the
+	// skydns domain. If Options.Nameservers is empty, the nameservers in
+	// /etc/resolv.conf are used.
+	Options
+
+	// Addr to serve DNS on over TCP and UDP, e.g. "0.0.0.0:53". Use port
+	// 0 to bind to ephemeral ports.
+	Addr string
+	// TCP tunes the TCP listener.
+	TCP TCPOptions
+	// HandlerWorkers is the number of workers processing queries, for
+	// each of TCP and UDP. If 0, DefaultHandlerWorkers() is used.
+	HandlerWorkers int
+
+	// WatchdogInterval after which the server is considered stalled if
+	// queries are in progress but none has completed. 0 disables the
+	// watchdog.
+	WatchdogInterval time.Duration
+	// OnStall is called when the watchdog detects a stall, e.g. to exit
+	// so that the server is restarted. May be nil.
+	OnStall func()
+
+	// SelfCheckName is resolved through the request path every
+	// SelfCheckInterval to check that the server answers queries. 0
+	// disables the check.
+	SelfCheckName     string
+	SelfCheckInterval time.Duration
+	// SelfCheckFailures is the number of consecutive failed self checks
+	// after which Healthy() returns an error. 0 means never.
+	SelfCheckFailures int
+
+	// ReadyTimeout after Run() from which Ready() returns nil even if the
+	// backend has not completed its initial sync. 0 means Ready() waits
+	// for the sync.
+	ReadyTimeout time.Duration
+}
+
+// Starter is implemented by backends that must be started before they
+// can serve records.
+type Starter interface {
+	// Start the backend. Start may block until the backend is ready.
+	Start()
+}
+
+// Syncer is implemented by backends that sync their records from a
+// source, e.g. the API server. The server is not ready until the initial
+// sync is complete.
+type Syncer interface {
+	// HasSynced returns whether the initial sync is complete.
+	HasSynced() bool
+}
+
+// Addrs are the addresses the server is bound to.
+type Addrs struct {
+	TCP net.Addr
+	UDP net.Addr
+}
+
+// Server runs the kube-dns listeners, request path and record backend
+// in-process.
+type Server struct {
+	options ServerOptions
+	clock   clock.Clock
+
+	// lock protects started and selfCheck, which are set by Run().
+	lock sync.Mutex
+	// started is when Run() was called, or zero.
+	started time.Time
+	// selfCheck is nil if the self check is disabled.
+	selfCheck *selfCheck
+	// readyTimeoutWarning is logged once when ReadyTimeout is reached.
+	readyTimeoutWarning sync.Once
+}
+
+// New returns a Server. Call Run() to start serving.
+func New(options ServerOptions) *Server {
+	return &Server{options: options, clock: clock.RealClock{}}
+}
+
+// Run binds the listeners, starts serving and starts the backend. It
+// returns the bound addresses once the server is ready for queries. The
+// server is shut down when ctx is done.
+func (s *Server) Run(ctx context.Context) (Addrs, error) {
+	s.lock.Lock()
+	s.started = s.clock.Now()
+	s.lock.Unlock()
+
+	skydnsConfig := &skyserver.Config{
+		Domain:      s.options.Domain,
+		DnsAddr:     s.options.Addr,
+		Nameservers: s.options.Nameservers,
+	}
+	if err := skyserver.SetDefaults(skydnsConfig); err != nil {
+		return Addrs{}, fmt.Errorf("invalid skydns configuration: %v", err)
+	}
+	options := s.options.Options
+	options.Nameservers = skydnsConfig.Nameservers
+	handler := NewHandler(skyserver.New(options.Backend, skydnsConfig), options)
+
+	udpConn, err := net.ListenPacket("udp", skydnsConfig.DnsAddr)
+	if err != nil {
+		return Addrs{}, err
+	}
+	tcpListener, err := listenTCP(skydnsConfig.DnsAddr, s.options.TCP)
+	if err != nil {
+		udpConn.Close()
+		return Addrs{}, err
+	}
+
+	workers := s.options.HandlerWorkers
+	if workers <= 0 {
+		workers = DefaultHandlerWorkers()
+	}
+	servers := []*dns.Server{
+		{Net: "udp", PacketConn: udpConn},
+		{Net: "tcp", Listener: tcpListener},
+	}
+	for _, srv := range servers {
+		srv.Handler = newWorkerPool(
+			handler, workers, handlerQueueDepth.WithLabelValues(srv.Net), ctx.Done())
+		if s.options.WatchdogInterval > 0 {
+			srv.Handler = newWatchdog(srv.Handler, s.options.WatchdogInterval,
+				watchdogStalls.WithLabelValues(srv.Net), s.options.OnStall, ctx.Done())
+		}
+		if err := activate(srv); err != nil {
+			udpConn.Close()
+			tcpListener.Close()
+			return Addrs{}, err
+		}
+	}
+
+	addrs := Addrs{TCP: tcpListener.Addr(), UDP: udpConn.LocalAddr()}
+	glog.V(0).Infof("Ready for queries on tcp://%v and udp://%v", addrs.TCP, addrs.UDP)
+
+	go func() {
+		<-ctx.Done()
+		glog.V(0).Infof("Shutting down DNS server")
+		for _, srv := range servers {
+			if err := srv.Shutdown(); err != nil {
+				glog.Warningf("Error shutting down %s server: %v", srv.Net, err)
+			}
+		}
+	}()
+
+	if starter, ok := s.options.Backend.(Starter); ok {
+		starter.Start()
+	}
+
+	if s.options.SelfCheckInterval > 0 {
+		selfCheck := newSelfCheck(handler, s.options.SelfCheckName, s.options.SelfCheckFailures)
+		s.lock.Lock()
+		s.selfCheck = selfCheck
+		s.lock.Unlock()
+		go selfCheck.run(s.options.SelfCheckInterval, ctx.Done())
+	}
+
+	return addrs, nil
+}
+
+// Healthy returns an error if the self check has failed
+// SelfCheckFailures times in a row.
+func (s *Server) Healthy() error {
+	s.lock.Lock()
+	selfCheck := s.selfCheck
+	s.lock.Unlock()
+	if selfCheck == nil {
+		return nil
+	}
+	return selfCheck.healthy()
+}
+
+// Ready returns an error until Run() has been called and the backend, if
+// it is a Syncer, has completed its initial sync. Once ReadyTimeout has
+// elapsed since Run(), it returns nil with a warning.
+func (s *Server) Ready() error {
+	s.lock.Lock()
+	started := s.started
+	s.lock.Unlock()
+	if started.IsZero() {
+		return fmt.Errorf("server not started")
+	}
+	syncer, ok := s.options.Backend.(Syncer)
+	if !ok || syncer.HasSynced() {
+		return nil
+	}
+	if s.options.ReadyTimeout > 0 && s.clock.Since(started) >= s.options.ReadyTimeout {
+		s.readyTimeoutWarning.Do(func() {
+			glog.Warningf("Records not synced after %v, reporting ready anyway", s.options.ReadyTimeout)
+		})
+		return nil
+	}
+	return fmt.Errorf("records not synced yet")
+}
+
+// activate starts serving srv in the background and waits for it to
+// be ready.
+func activate(srv *dns.Server) error {
+	started := make(chan struct{})
+	failed := make(chan error, 1)
+	srv.NotifyStartedFunc = func() { close(started) }
+
+	go func() {
+		if err := srv.ActivateAndServe(); err != nil {
+			glog.Errorf("Error serving DNS over %s: %v", srv.Net, err)
+			failed <- err
+		}
+	}()
+
+	select {
+	case <-started:
+		return nil
+	case err := <-failed:
+		return err
+	}
+}
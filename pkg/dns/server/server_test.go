@@ -0,0 +1,227 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/clock"
+
+	kdns "k8s.io/dns/pkg/dns"
+	"k8s.io/dns/pkg/dns/config"
+)
+
+// runTestServer runs a Server backed by a KubeDNS with the service
+// "mysvc.default" (10.0.0.10) and objects until ctx is done.
+func runTestServer(ctx context.Context, t testing.TB, options Options, objects ...runtime.Object) Addrs {
+	kubeClient := fake.NewSimpleClientset(append(objects, &v1.Service{
+		ObjectMeta: v1.ObjectMeta{Name: "mysvc", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.10",
+			Ports:     []v1.ServicePort{{Port: 80, Protocol: "TCP"}},
+		},
+	})...)
+	kd := kdns.NewKubeDNS(kubeClient, testDomain, 10*time.Second,
+		config.NewNopSync(config.NewDefaultConfig()))
+
+	options.Domain = testDomain
+	options.Backend = kd
+	if len(options.Nameservers) == 0 {
+		options.Nameservers = []string{"127.0.0.1:1"}
+	}
+	addrs, err := New(ServerOptions{Options: options, Addr: "127.0.0.1:0"}).Run(ctx)
+	require.NoError(t, err)
+	return addrs
+}
+
+func TestServerRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := runTestServer(ctx, t, Options{})
+
+	for proto, addr := range map[string]string{
+		"udp": addrs.UDP.String(),
+		"tcp": addrs.TCP.String(),
+	} {
+		dnsClient := &dns.Client{Net: proto, Timeout: 5 * time.Second}
+
+		req := new(dns.Msg)
+		req.SetQuestion("mysvc.default.svc.cluster.local.", dns.TypeA)
+		resp, _, err := dnsClient.Exchange(req, addr)
+		require.NoError(t, err, proto)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode, proto)
+		require.Len(t, resp.Answer, 1, proto)
+		assert.Equal(t, "10.0.0.10", resp.Answer[0].(*dns.A).A.String(), proto)
+
+		req = new(dns.Msg)
+		req.SetQuestion("missing.default.svc.cluster.local.", dns.TypeA)
+		resp, _, err = dnsClient.Exchange(req, addr)
+		require.NoError(t, err, proto)
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode, proto)
+	}
+
+	// The listeners are closed once the context is done.
+	cancel()
+	client := &dns.Client{Net: "tcp", Timeout: time.Second}
+	req := new(dns.Msg)
+	req.SetQuestion("mysvc.default.svc.cluster.local.", dns.TypeA)
+	for i := 0; ; i++ {
+		if _, _, err := client.Exchange(req, addrs.TCP.String()); err != nil {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("server still answering after shutdown")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// TestAddressFamilies checks that services only get the records of the
+// address families of their ClusterIP or endpoints, and NODATA for the
+// others. The vendored API predates spec.ipFamilies and spec.clusterIPs,
+// so the families are those of the addresses.
+func TestAddressFamilies(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dual := v1.ObjectMeta{Name: "dual", Namespace: "default"}
+	addrs := runTestServer(ctx, t, Options{},
+		&v1.Service{
+			ObjectMeta: v1.ObjectMeta{Name: "v6", Namespace: "default"},
+			Spec: v1.ServiceSpec{
+				ClusterIP: "fd00::10",
+				Ports:     []v1.ServicePort{{Port: 80, Protocol: "TCP"}},
+			},
+		},
+		&v1.Service{
+			ObjectMeta: dual,
+			Spec: v1.ServiceSpec{
+				ClusterIP: v1.ClusterIPNone,
+				Ports:     []v1.ServicePort{{Port: 80, Protocol: "TCP"}},
+			},
+		},
+		&v1.Endpoints{ObjectMeta: dual, Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "10.1.0.1"}, {IP: "fd00::1:1"}},
+			Ports:     []v1.EndpointPort{{Port: 80, Protocol: "TCP"}},
+		}}},
+	)
+
+	exchange := func(name string, qtype uint16) *dns.Msg {
+		client := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		resp, _, err := client.Exchange(req, addrs.UDP.String())
+		require.NoError(t, err)
+		return resp
+	}
+	addresses := func(resp *dns.Msg) []string {
+		var ips []string
+		for _, rr := range resp.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, rr.A.String())
+			case *dns.AAAA:
+				ips = append(ips, rr.AAAA.String())
+			}
+		}
+		return ips
+	}
+
+	for _, testCase := range []struct {
+		name  string
+		qtype uint16
+		ips   []string
+	}{
+		{"mysvc", dns.TypeA, []string{"10.0.0.10"}},
+		{"mysvc", dns.TypeAAAA, nil},
+		{"v6", dns.TypeA, nil},
+		{"v6", dns.TypeAAAA, []string{"fd00::10"}},
+		{"dual", dns.TypeA, []string{"10.1.0.1"}},
+		{"dual", dns.TypeAAAA, []string{"fd00::1:1"}},
+	} {
+		qtype := dns.TypeToString[testCase.qtype]
+		resp := exchange(testCase.name+".default.svc.cluster.local.", testCase.qtype)
+		// The wrong family gets NODATA, not NXDOMAIN.
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode, "%v %v", testCase.name, qtype)
+		assert.Equal(t, testCase.ips, addresses(resp), "%v %v", testCase.name, qtype)
+	}
+}
+
+// syncingBackend is a Syncer backend without records.
+type syncingBackend struct {
+	reverseBackend
+	synced int32
+}
+
+func (b *syncingBackend) HasSynced() bool {
+	return atomic.LoadInt32(&b.synced) != 0
+}
+
+func TestServerReady(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, readyTimeout := range []time.Duration{0, time.Minute} {
+		backend := &syncingBackend{}
+		fakeClock := clock.NewFakeClock(time.Now())
+		s := New(ServerOptions{
+			Options: Options{
+				Domain:      testDomain,
+				Nameservers: []string{"127.0.0.1:1"},
+				Backend:     backend,
+			},
+			Addr:         "127.0.0.1:0",
+			ReadyTimeout: readyTimeout,
+		})
+		s.clock = fakeClock
+		assert.Error(t, s.Ready(), "not started")
+
+		_, err := s.Run(ctx)
+		require.NoError(t, err)
+		assert.Error(t, s.Ready(), "not synced")
+		fakeClock.Step(time.Minute)
+		if readyTimeout > 0 {
+			assert.NoError(t, s.Ready(), "ready timeout")
+		} else {
+			assert.Error(t, s.Ready(), "not synced")
+		}
+
+		atomic.StoreInt32(&backend.synced, 1)
+		assert.NoError(t, s.Ready(), "synced")
+	}
+
+	// Backends that do not sync are ready once the server is started.
+	s := New(ServerOptions{
+		Options: Options{Domain: testDomain, Nameservers: []string{"127.0.0.1:1"}},
+		Addr:    "127.0.0.1:0",
+	})
+	assert.Error(t, s.Ready())
+	_, err := s.Run(ctx)
+	require.NoError(t, err)
+	assert.NoError(t, s.Ready())
+}
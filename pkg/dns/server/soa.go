@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SOAOptions for the SOA record of the cluster domain. Zero values are
+// replaced by the defaults below, which match the values used by skydns.
+type SOAOptions struct {
+	// MName is the primary nameserver. Defaults to "ns.dns.<domain>".
+	MName string
+	// RName is the mailbox of the person responsible for the zone, either
+	// as "hostmaster.example.com." or "hostmaster@example.com". Defaults
+	// to "hostmaster.<domain>".
+	RName string
+
+	Refresh time.Duration
+	Retry   time.Duration
+	Expire  time.Duration
+	// Minimum is the TTL of the SOA in negative (NXDOMAIN and NODATA)
+	// responses, which resolvers use as the negative caching TTL.
+	Minimum time.Duration
+
+	// NSNames are the nameservers in the NS records at the apex of the
+	// cluster domain. Defaults to MName.
+	NSNames []string
+}
+
+const (
+	defaultSOARefresh = 28800 * time.Second
+	defaultSOARetry   = 7200 * time.Second
+	defaultSOAExpire  = 604800 * time.Second
+	defaultSOAMinimum = 60 * time.Second
+)
+
+// withDefaults returns a copy of options with the defaults filled in for
+// domain.
+func (options SOAOptions) withDefaults(domain string) SOAOptions {
+	if options.MName == "" {
+		options.MName = "ns.dns." + domain
+	}
+	if options.RName == "" {
+		options.RName = "hostmaster." + domain
+	}
+	// SOA mailboxes cannot contain "@".
+	options.MName = dns.Fqdn(options.MName)
+	options.RName = dns.Fqdn(strings.Replace(options.RName, "@", ".", -1))
+	if len(options.NSNames) == 0 {
+		options.NSNames = []string{options.MName}
+	}
+	var nsNames []string
+	for _, name := range options.NSNames {
+		nsNames = append(nsNames, dns.Fqdn(name))
+	}
+	options.NSNames = nsNames
+
+	if options.Refresh == 0 {
+		options.Refresh = defaultSOARefresh
+	}
+	if options.Retry == 0 {
+		options.Retry = defaultSOARetry
+	}
+	if options.Expire == 0 {
+		options.Expire = defaultSOAExpire
+	}
+	if options.Minimum == 0 {
+		options.Minimum = defaultSOAMinimum
+	}
+	return options
+}
+
+// rewriteSOA replaces the fields of the cluster domain SOA records in m
+// with the configured values. The serial is left as set by skydns.
+func (h *Handler) rewriteSOA(m *dns.Msg) {
+	for _, rr := range m.Answer {
+		h.updateSOA(rr)
+	}
+	for _, rr := range m.Ns {
+		if h.updateSOA(rr) {
+			// The TTL of the SOA in the authority section is used for
+			// negative caching (RFC 2308).
+			rr.Header().Ttl = seconds(h.soa.Minimum)
+		}
+	}
+}
+
+func (h *Handler) updateSOA(rr dns.RR) bool {
+	soa, ok := rr.(*dns.SOA)
+	if !ok || strings.ToLower(soa.Hdr.Name) != h.domain {
+		return false
+	}
+	soa.Ns = h.soa.MName
+	soa.Mbox = h.soa.RName
+	soa.Refresh = seconds(h.soa.Refresh)
+	soa.Retry = seconds(h.soa.Retry)
+	soa.Expire = seconds(h.soa.Expire)
+	soa.Minttl = seconds(h.soa.Minimum)
+	return true
+}
+
+func seconds(d time.Duration) uint32 {
+	return uint32(d / time.Second)
+}
+
+// soaWriter rewrites the cluster domain SOA records in the response.
+type soaWriter struct {
+	dns.ResponseWriter
+	h *Handler
+}
+
+func (w *soaWriter) WriteMsg(m *dns.Msg) error {
+	w.h.rewriteSOA(m)
+	return w.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSOA(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := runTestServer(ctx, t, Options{
+		SOA: SOAOptions{
+			MName:   "ns1.example.com",
+			RName:   "dns-admin@example.com",
+			Refresh: time.Hour,
+			Retry:   10 * time.Minute,
+			Expire:  24 * time.Hour,
+			Minimum: 5 * time.Second,
+		},
+	})
+	client := &dns.Client{Timeout: 5 * time.Second}
+
+	assertSOA := func(rr dns.RR, ttl uint32) {
+		soa, ok := rr.(*dns.SOA)
+		require.True(t, ok, "expected SOA, got %v", rr)
+		assert.Equal(t, testDomain, soa.Hdr.Name)
+		assert.Equal(t, "ns1.example.com.", soa.Ns)
+		assert.Equal(t, "dns-admin.example.com.", soa.Mbox)
+		assert.Equal(t, uint32(3600), soa.Refresh)
+		assert.Equal(t, uint32(600), soa.Retry)
+		assert.Equal(t, uint32(86400), soa.Expire)
+		assert.Equal(t, uint32(5), soa.Minttl)
+		assert.Equal(t, ttl, soa.Hdr.Ttl)
+	}
+
+	// Direct SOA query.
+	req := new(dns.Msg)
+	req.SetQuestion(testDomain, dns.TypeSOA)
+	resp, _, err := client.Exchange(req, addrs.UDP.String())
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assertSOA(resp.Answer[0], 3600)
+
+	// NXDOMAIN carries the SOA in the authority section, with the minimum
+	// as TTL.
+	req = new(dns.Msg)
+	req.SetQuestion("missing.default.svc."+testDomain, dns.TypeA)
+	resp, _, err = client.Exchange(req, addrs.UDP.String())
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+	require.Len(t, resp.Ns, 1)
+	assertSOA(resp.Ns[0], 5)
+}
+
+func TestSOADefaults(t *testing.T) {
+	soa := SOAOptions{}.withDefaults(testDomain)
+	assert.Equal(t, "ns.dns."+testDomain, soa.MName)
+	assert.Equal(t, "hostmaster."+testDomain, soa.RName)
+	assert.Equal(t, defaultSOARefresh, soa.Refresh)
+	assert.Equal(t, defaultSOARetry, soa.Retry)
+	assert.Equal(t, defaultSOAExpire, soa.Expire)
+	assert.Equal(t, defaultSOAMinimum, soa.Minimum)
+	assert.Equal(t, []string{"ns.dns." + testDomain}, soa.NSNames)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultTCPIdleTimeout after which idle upstream connections are
+	// closed. Nameservers close idle connections after a few seconds
+	// (e.g. 8s for miekg/dns based servers), so this is kept shorter.
+	defaultTCPIdleTimeout = 5 * time.Second
+	// defaultTCPIdlePerUpstream is the maximum number of idle connections
+	// kept for each upstream nameserver.
+	defaultTCPIdlePerUpstream = 16
+)
+
+// tcpPool keeps idle TCP connections to the upstream nameservers so that
+// they can be reused across queries.
+type tcpPool struct {
+	idleTimeout time.Duration
+	maxIdle     int
+
+	// lock protects idle.
+	lock sync.Mutex
+	// idle connections by upstream address, least recently used first.
+	idle map[string][]*pooledConn
+}
+
+type pooledConn struct {
+	*dns.Conn
+	idleSince time.Time
+}
+
+// newTCPPool returns a tcpPool. Idle connections are closed after
+// idleTimeout, and at most maxIdle are kept for each upstream.
+func newTCPPool(idleTimeout time.Duration, maxIdle int) *tcpPool {
+	return &tcpPool{
+		idleTimeout: idleTimeout,
+		maxIdle:     maxIdle,
+		idle:        make(map[string][]*pooledConn),
+	}
+}
+
+// exchange sends req to addr over a pooled connection. Connections are
+// only returned to the pool after a successful exchange. If a reused
+// connection fails, the exchange is retried once on a new connection as
+// the upstream may have closed it in the meantime. timeout applies to
+// dialing and to each attempt, so that forwarders with different timeouts
+// can share the pool.
+func (p *tcpPool) exchange(req *dns.Msg, addr string, timeout time.Duration) (*dns.Msg, error) {
+	conn := p.get(addr)
+	if conn != nil {
+		upstreamTCPExchanges.WithLabelValues("reused").Inc()
+		resp, err := p.exchangeConn(conn, req, timeout)
+		if err == nil {
+			p.put(addr, conn)
+			return resp, nil
+		}
+		glog.V(4).Infof("Error on reused connection to %v, retrying: %v", addr, err)
+		conn.Close()
+	}
+
+	conn, err := dns.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	upstreamTCPExchanges.WithLabelValues("new").Inc()
+	resp, err := p.exchangeConn(conn, req, timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p.put(addr, conn)
+	return resp, nil
+}
+
+func (p *tcpPool) exchangeConn(conn *dns.Conn, req *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := conn.WriteMsg(req); err != nil {
+		return nil, err
+	}
+	resp, err := conn.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Id != req.Id {
+		return nil, dns.ErrId
+	}
+	return resp, nil
+}
+
+// get returns the most recently used idle connection to addr, or nil if
+// there is none.
+func (p *tcpPool) get(addr string) *dns.Conn {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.evict(time.Now())
+
+	conns := p.idle[addr]
+	if len(conns) == 0 {
+		return nil
+	}
+	conn := conns[len(conns)-1]
+	if len(conns) == 1 {
+		delete(p.idle, addr)
+	} else {
+		p.idle[addr] = conns[:len(conns)-1]
+	}
+	upstreamTCPPoolSize.Dec()
+	return conn.Conn
+}
+
+// put returns conn to the idle connections of addr, closing it if there
+// are already maxIdle.
+func (p *tcpPool) put(addr string, conn *dns.Conn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	p.evict(now)
+
+	if len(p.idle[addr]) >= p.maxIdle {
+		conn.Close()
+		return
+	}
+	p.idle[addr] = append(p.idle[addr], &pooledConn{Conn: conn, idleSince: now})
+	upstreamTCPPoolSize.Inc()
+}
+
+// evict closes the connections that have been idle for longer than
+// idleTimeout. p.lock must be held.
+func (p *tcpPool) evict(now time.Time) {
+	for addr, conns := range p.idle {
+		i := 0
+		for ; i < len(conns) && now.Sub(conns[i].idleSince) > p.idleTimeout; i++ {
+			conns[i].Close()
+			upstreamTCPPoolSize.Dec()
+		}
+		if i == len(conns) {
+			delete(p.idle, addr)
+		} else if i > 0 {
+			p.idle[addr] = conns[i:]
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTCPUpstream runs a TCP nameserver answering every query with an
+// empty NOERROR response. It closes connections that are idle for longer
+// than idleTimeout.
+func startTCPUpstream(tb testing.TB, idleTimeout time.Duration) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	srv := &dns.Server{
+		Listener: listener,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			w.WriteMsg(m)
+		}),
+		IdleTimeout: func() time.Duration { return idleTimeout },
+	}
+	require.NoError(tb, activate(srv))
+	return listener.Addr().String(), func() { srv.Shutdown() }
+}
+
+func tcpQuery(f *forwarder, name string) *dns.Msg {
+	w := &fakeWriter{remote: &net.TCPAddr{IP: net.ParseIP("10.1.0.1"), Port: 4321}}
+	req := new(dns.Msg)
+	req.SetQuestion(name, dns.TypeA)
+	f.ServeDNS(w, req)
+	return w.msg
+}
+
+func TestTCPPool(t *testing.T) {
+	upstream, stop := startTCPUpstream(t, 100*time.Millisecond)
+	defer stop()
+
+	reused := upstreamTCPExchanges.WithLabelValues("reused")
+	created := upstreamTCPExchanges.WithLabelValues("new")
+	reusedBefore, createdBefore := counterValue(reused), counterValue(created)
+	idleBefore := gaugeValue(upstreamTCPPoolSize)
+
+	pool := newTCPPool(time.Minute, defaultTCPIdlePerUpstream)
+	f := newForwarder([]string{upstream}, time.Second, UpstreamProtocolUDP, pool)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, dns.RcodeSuccess, tcpQuery(f, "www.example.com.").Rcode)
+	}
+	assert.Equal(t, float64(1), counterValue(created)-createdBefore)
+	assert.Equal(t, float64(2), counterValue(reused)-reusedBefore)
+	assert.Equal(t, float64(1), gaugeValue(upstreamTCPPoolSize)-idleBefore)
+
+	// The upstream closes the idle connection. The exchange fails on it
+	// and is retried on a new connection, which replaces it in the pool.
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, dns.RcodeSuccess, tcpQuery(f, "www.example.com.").Rcode)
+	assert.Equal(t, float64(2), counterValue(created)-createdBefore)
+	assert.Equal(t, float64(3), counterValue(reused)-reusedBefore)
+	assert.Equal(t, float64(1), gaugeValue(upstreamTCPPoolSize)-idleBefore)
+}
+
+func TestTCPPoolIdleTimeout(t *testing.T) {
+	upstream, stop := startTCPUpstream(t, time.Minute)
+	defer stop()
+
+	idleBefore := gaugeValue(upstreamTCPPoolSize)
+	pool := newTCPPool(50*time.Millisecond, defaultTCPIdlePerUpstream)
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+
+	_, err := pool.exchange(req, upstream, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), gaugeValue(upstreamTCPPoolSize)-idleBefore)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, pool.get(upstream))
+	assert.Equal(t, float64(0), gaugeValue(upstreamTCPPoolSize)-idleBefore)
+}
+
+func BenchmarkTCPForward(b *testing.B) {
+	upstream, stop := startTCPUpstream(b, time.Minute)
+	defer stop()
+
+	for _, bm := range []struct {
+		name string
+		pool *tcpPool
+	}{
+		{"unpooled", nil},
+		{"pooled", newTCPPool(time.Minute, defaultTCPIdlePerUpstream)},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			f := newForwarder([]string{upstream}, time.Second, UpstreamProtocolUDP, bm.pool)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if resp := tcpQuery(f, "www.example.com."); resp.Rcode != dns.RcodeSuccess {
+					b.Fatalf("unexpected response: %v", resp)
+				}
+			}
+		})
+	}
+}
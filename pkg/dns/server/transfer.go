@@ -0,0 +1,115 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// transferChunkSize is the number of records in each message of a zone
+// transfer.
+const transferChunkSize = 100
+
+// TransferBackend is implemented by backends that can list their records
+// for zone transfers (e.g. *dns.KubeDNS).
+type TransferBackend interface {
+	// TransferRecords returns the records of the cluster domain and the
+	// PTR records of the reverse zones.
+	TransferRecords() []dns.RR
+}
+
+// serveTransfer answers req if zone transfers are enabled and it is an
+// AXFR query for the cluster domain or a reverse zone. Transfers are
+// only sent over TCP to the clients in TransferAllowNetworks, and refused
+// otherwise. It returns false for other queries.
+func (h *Handler) serveTransfer(w dns.ResponseWriter, req *dns.Msg) bool {
+	if len(h.options.TransferAllowNetworks) == 0 || len(req.Question) == 0 {
+		return false
+	}
+	q := req.Question[0]
+	zone := strings.ToLower(q.Name)
+	if q.Qtype != dns.TypeAXFR || q.Qclass != dns.ClassINET ||
+		(zone != h.domain && !dns.IsSubDomain("in-addr.arpa.", zone) && !dns.IsSubDomain("ip6.arpa.", zone)) {
+		return false
+	}
+
+	_, tcp := w.RemoteAddr().(*net.TCPAddr)
+	if !tcp || !inNetworks(remoteIP(w.RemoteAddr()), h.options.TransferAllowNetworks) {
+		glog.V(2).Infof("Refusing transfer of %q to %v", zone, w.RemoteAddr())
+		zoneTransfers.WithLabelValues("refused").Inc()
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return true
+	}
+
+	soa := h.newSOA()
+	soa.Hdr.Name = q.Name
+	records := []dns.RR{soa}
+	if zone == h.domain {
+		for _, ns := range h.soa.NSNames {
+			records = append(records, &dns.NS{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: apexTTL},
+				Ns:  ns,
+			})
+		}
+	}
+	if backend, ok := h.options.Backend.(TransferBackend); ok {
+		for _, rr := range backend.TransferRecords() {
+			if dns.IsSubDomain(zone, strings.ToLower(rr.Header().Name)) {
+				records = append(records, rr)
+			}
+		}
+	}
+	records = append(records, soa)
+
+	// The envelopes are queued up front so that nothing is left blocked
+	// if the client goes away.
+	ch := make(chan *dns.Envelope, (len(records)+transferChunkSize-1)/transferChunkSize)
+	for len(records) > 0 {
+		n := transferChunkSize
+		if n > len(records) {
+			n = len(records)
+		}
+		ch <- &dns.Envelope{RR: records[:n]}
+		records = records[n:]
+	}
+	close(ch)
+
+	glog.V(2).Infof("Transferring %q to %v", zone, w.RemoteAddr())
+	if err := new(dns.Transfer).Out(w, req, ch); err != nil {
+		glog.V(2).Infof("Error transferring %q to %v: %v", zone, w.RemoteAddr(), err)
+		zoneTransfers.WithLabelValues("failed").Inc()
+		return true
+	}
+	zoneTransfers.WithLabelValues("transferred").Inc()
+	return true
+}
+
+// inNetworks returns whether ip is in one of networks.
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// transfer returns the records of an AXFR of zone from addr.
+func transfer(t *testing.T, addr, zone string) []string {
+	req := new(dns.Msg)
+	req.SetAxfr(zone)
+	envelopes, err := new(dns.Transfer).In(req, addr)
+	require.NoError(t, err)
+	var records []string
+	for envelope := range envelopes {
+		require.NoError(t, envelope.Error)
+		for _, rr := range envelope.RR {
+			records = append(records, rr.String())
+		}
+	}
+	return records
+}
+
+func TestZoneTransfer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	web := v1.ObjectMeta{Name: "web", Namespace: "default"}
+	addrs := runTestServer(ctx, t, Options{TransferAllowNetworks: []*net.IPNet{loopback}},
+		&v1.Service{
+			ObjectMeta: web,
+			Spec: v1.ServiceSpec{
+				ClusterIP: v1.ClusterIPNone,
+				Ports:     []v1.ServicePort{{Name: "http", Port: 80, Protocol: "TCP"}},
+			},
+		},
+		&v1.Endpoints{ObjectMeta: web, Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "10.1.0.1", Hostname: "web-0"}},
+			Ports:     []v1.EndpointPort{{Name: "http", Port: 8080, Protocol: "TCP"}},
+		}}},
+		&v1.Service{
+			ObjectMeta: v1.ObjectMeta{Name: "ext", Namespace: "default"},
+			Spec: v1.ServiceSpec{
+				Type:         v1.ServiceTypeExternalName,
+				ExternalName: "example.com",
+			},
+		},
+	)
+
+	records := transfer(t, addrs.TCP.String(), "cluster.local.")
+	require.True(t, len(records) > 2, "%v", records)
+	// The transfer starts and ends with the SOA.
+	assert.Contains(t, records[0], "cluster.local.\t3600\tIN\tSOA\tns.dns.cluster.local. hostmaster.cluster.local.")
+	assert.Equal(t, records[0], records[len(records)-1])
+	assert.Equal(t, []string{
+		"cluster.local.\t3600\tIN\tNS\tns.dns.cluster.local.",
+		"_http._tcp.web.default.svc.cluster.local.\t30\tIN\tSRV\t10 10 8080 web-0.web.default.svc.cluster.local.",
+		"ext.default.svc.cluster.local.\t30\tIN\tCNAME\texample.com.",
+		"mysvc.default.svc.cluster.local.\t30\tIN\tA\t10.0.0.10",
+		"web-0.web.default.svc.cluster.local.\t30\tIN\tA\t10.1.0.1",
+		"web.default.svc.cluster.local.\t30\tIN\tA\t10.1.0.1",
+	}, records[1:len(records)-1])
+
+	records = transfer(t, addrs.TCP.String(), "in-addr.arpa.")
+	require.Len(t, records, 4)
+	assert.Equal(t, []string{
+		"1.0.1.10.in-addr.arpa.\t30\tIN\tPTR\tweb-0.web.default.svc.cluster.local.",
+		"10.0.0.10.in-addr.arpa.\t30\tIN\tPTR\tmysvc.default.svc.cluster.local.",
+	}, records[1:3])
+
+	// Transfers are refused over UDP, and to clients outside of the
+	// allowed networks.
+	refused := func(proto, addr string) {
+		req := new(dns.Msg)
+		req.SetAxfr("cluster.local.")
+		client := &dns.Client{Net: proto, Timeout: 5 * time.Second}
+		resp, _, err := client.Exchange(req, addr)
+		require.NoError(t, err, proto)
+		assert.Equal(t, dns.RcodeRefused, resp.Rcode, proto)
+	}
+	before := counterValue(zoneTransfers.WithLabelValues("refused"))
+	refused("udp", addrs.UDP.String())
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+	addrs = runTestServer(ctx, t, Options{TransferAllowNetworks: []*net.IPNet{other}})
+	refused("tcp", addrs.TCP.String())
+	assert.Equal(t, before+2, counterValue(zoneTransfers.WithLabelValues("refused")))
+}
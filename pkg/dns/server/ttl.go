@@ -0,0 +1,49 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ttlWriter sets the TTL of the records in the cluster domain to the TTL
+// configured for their type. The records of the other types keep the TTL
+// they were generated with.
+type ttlWriter struct {
+	dns.ResponseWriter
+	h    *Handler
+	ttls map[uint16]uint32
+}
+
+func (w *ttlWriter) WriteMsg(m *dns.Msg) error {
+	for _, section := range [][]dns.RR{m.Answer, m.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			ttl, ok := w.ttls[hdr.Rrtype]
+			if !ok {
+				continue
+			}
+			name := strings.ToLower(hdr.Name)
+			if name == w.h.domain || strings.HasSuffix(name, "."+w.h.domain) {
+				hdr.Ttl = ttl
+			}
+		}
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+func TestRecordTTLs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := &staticConfig{config: &config.Config{
+		RecordTTLs: map[string]uint32{"A": 5, "SRV": 3600},
+	}}
+	addrs := runTestServer(ctx, t, Options{Config: source}, headlessService("ttl", 1)...)
+
+	exchange := func(name string, qtype uint16) *dns.Msg {
+		dnsClient := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		resp, _, err := dnsClient.Exchange(req, addrs.TCP.String())
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		return resp
+	}
+
+	resp := exchange("ttl.default.svc.cluster.local.", dns.TypeA)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, uint32(5), resp.Answer[0].Header().Ttl)
+
+	resp = exchange("_http._tcp.ttl.default.svc.cluster.local.", dns.TypeSRV)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, uint32(3600), resp.Answer[0].Header().Ttl)
+	// The glue is an A record.
+	require.Len(t, resp.Extra, 1)
+	assert.Equal(t, uint32(5), resp.Extra[0].Header().Ttl)
+
+	// Without record TTLs, the records have the default TTL.
+	source.config = config.NewDefaultConfig()
+	resp = exchange("ttl.default.svc.cluster.local.", dns.TypeA)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, uint32(30), resp.Answer[0].Header().Ttl)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	skymsg "github.com/skynetservices/skydns/msg"
+)
+
+// TXTBackend is implemented by backends that serve TXT records for names
+// in the cluster domain (e.g. *dns.KubeDNS).
+type TXTBackend interface {
+	// TXTRecords returns the TXT records of name, or nil if it has none.
+	TXTRecords(name string) []skymsg.Service
+}
+
+// serveTXT answers req if it is a TXT query for a name with TXT records
+// in the backend. It returns false if req is left to the next handler.
+func (h *Handler) serveTXT(w dns.ResponseWriter, req *dns.Msg) bool {
+	if len(req.Question) == 0 {
+		return false
+	}
+	q := req.Question[0]
+	if q.Qtype != dns.TypeTXT || q.Qclass != dns.ClassINET {
+		return false
+	}
+	backend, ok := h.options.Backend.(TXTBackend)
+	if !ok {
+		return false
+	}
+	records := backend.TXTRecords(q.Name)
+	if len(records) == 0 {
+		return false
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	m.RecursionAvailable = true
+	m.Compress = true
+	if opt := req.IsEdns0(); opt != nil {
+		m.SetEdns0(opt.UDPSize(), false)
+	}
+	for i := range records {
+		m.Answer = append(m.Answer, records[i].NewTXT(q.Name))
+	}
+	// The records are dropped if they do not fit, so that the client
+	// retries over TCP.
+	if _, tcp := w.RemoteAddr().(*net.TCPAddr); m.Len() > maxResponseSize(req, tcp) {
+		m.Answer = nil
+		m.Truncated = true
+	}
+	w.WriteMsg(m)
+	return true
+}
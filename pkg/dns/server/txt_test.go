@@ -0,0 +1,82 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	kdns "k8s.io/dns/pkg/dns"
+)
+
+func TestTXTRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	long := strings.Repeat("a", 300)
+	addrs := runTestServer(ctx, t, Options{}, &v1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        "owned",
+			Namespace:   "default",
+			Annotations: map[string]string{kdns.TXTAnnotation: "owner=team-a\n" + long},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.11",
+			Ports:     []v1.ServicePort{{Port: 80, Protocol: "TCP"}},
+		},
+	})
+
+	exchange := func(name string, qtype uint16) *dns.Msg {
+		dnsClient := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		resp, _, err := dnsClient.Exchange(req, addrs.UDP.String())
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := exchange("owned.default.svc.cluster.local.", dns.TypeTXT)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 2)
+	txt := map[string][]string{}
+	for _, rr := range resp.Answer {
+		strs := rr.(*dns.TXT).Txt
+		txt[strs[0][:5]] = strs
+	}
+	assert.Equal(t, map[string][]string{
+		"owner": {"owner=team-a"},
+		"aaaaa": {long[:255], long[255:]},
+	}, txt)
+
+	// The other records of the service are unaffected.
+	resp = exchange("owned.default.svc.cluster.local.", dns.TypeA)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "10.0.0.11", resp.Answer[0].(*dns.A).A.String())
+
+	// Services without the annotation have no TXT records.
+	resp = exchange("mysvc.default.svc.cluster.local.", dns.TypeTXT)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+}
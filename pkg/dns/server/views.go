@@ -0,0 +1,126 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+// viewTTL of the records in views, the same as the default TTL of the
+// cluster records.
+const viewTTL = 30
+
+// views are the record overrides by client network. See
+// config.Config.Views.
+type views struct {
+	// views ordered from the most to the least specific network.
+	views []*view
+}
+
+type view struct {
+	network *net.IPNet
+	// records maps (lower-cased, fully qualified) names to addresses.
+	records map[string][]net.IP
+}
+
+// newViews builds the views from the CIDR -> view configuration.
+// Invalid entries are skipped; the configuration is validated before it
+// is applied.
+func newViews(cfg map[string]config.View) *views {
+	v := &views{}
+	for cidr, cfgView := range cfg {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			glog.Errorf("Invalid view %q: %v", cidr, err)
+			continue
+		}
+		view := &view{network: network, records: make(map[string][]net.IP)}
+		for name, addresses := range cfgView.Records {
+			name = strings.ToLower(dns.Fqdn(name))
+			for _, address := range addresses {
+				if ip := net.ParseIP(address); ip != nil {
+					view.records[name] = append(view.records[name], ip)
+				}
+			}
+		}
+		v.views = append(v.views, view)
+	}
+	sort.Slice(v.views, func(i, j int) bool {
+		ones, _ := v.views[i].network.Mask.Size()
+		otherOnes, _ := v.views[j].network.Mask.Size()
+		return ones > otherOnes
+	})
+	return v
+}
+
+// lookup returns the addresses of name in the most specific view of ip
+// that has it.
+func (v *views) lookup(ip net.IP, name string) ([]net.IP, bool) {
+	if ip == nil {
+		return nil, false
+	}
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, view := range v.views {
+		if !view.network.Contains(ip) {
+			continue
+		}
+		if addresses, ok := view.records[name]; ok {
+			return addresses, true
+		}
+	}
+	return nil, false
+}
+
+// serveView answers req from the views if it is an address query for a
+// name the client has a view of. It returns false otherwise.
+func (h *Handler) serveView(w dns.ResponseWriter, req *dns.Msg) bool {
+	views := h.dynamicConfig().views
+	if views == nil || len(req.Question) == 0 {
+		return false
+	}
+	q := req.Question[0]
+	if q.Qclass != dns.ClassINET || (q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA) {
+		return false
+	}
+	addresses, ok := views.lookup(remoteIP(w.RemoteAddr()), q.Name)
+	if !ok {
+		return false
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	m.RecursionAvailable = true
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: viewTTL}
+	for _, ip := range addresses {
+		switch {
+		case q.Qtype == dns.TypeA && ip.To4() != nil:
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: ip.To4()})
+		case q.Qtype == dns.TypeAAAA && ip.To4() == nil:
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	w.WriteMsg(m)
+	return true
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/dns/pkg/dns/config"
+)
+
+func answerIP(t *testing.T, resp *dns.Msg) string {
+	require.Len(t, resp.Answer, 1)
+	a, ok := resp.Answer[0].(*dns.A)
+	require.True(t, ok, "%v", resp.Answer[0])
+	return a.A.String()
+}
+
+func TestViews(t *testing.T) {
+	backend := &fakeBackend{records: map[string]string{
+		"api.acme.local.":         "10.0.0.1",
+		"a.ns.svc.cluster.local.": "10.0.0.2",
+	}}
+	source := &staticConfig{config: &config.Config{
+		Views: map[string]config.View{
+			"10.2.0.0/16": {Records: map[string][]string{
+				"api.acme.local": {"10.3.0.1", "fd00::1"},
+			}},
+			"10.4.0.0/16": {Records: map[string][]string{
+				"API.acme.local.": {"10.5.0.1"},
+			}},
+			// The more specific view applies.
+			"10.4.1.0/24": {Records: map[string][]string{
+				"api.acme.local": {"10.5.1.1"},
+			}},
+		},
+	}}
+	h := NewHandler(backend, Options{Domain: testDomain, Config: source})
+
+	assert.Equal(t, "10.3.0.1", answerIP(t, queryFrom(h, "10.2.0.1", "api.acme.local.", dns.TypeA)))
+	assert.Equal(t, "10.5.0.1", answerIP(t, queryFrom(h, "10.4.0.1", "Api.Acme.Local.", dns.TypeA)))
+	assert.Equal(t, "10.5.1.1", answerIP(t, queryFrom(h, "10.4.1.1", "api.acme.local.", dns.TypeA)))
+	// Clients without a view get the default records.
+	assert.Equal(t, "10.0.0.1", answerIP(t, queryFrom(h, "10.1.0.1", "api.acme.local.", dns.TypeA)))
+	// So do names the view does not override.
+	assert.Equal(t, "10.0.0.2", answerIP(t, queryFrom(h, "10.2.0.1", "a.ns.svc.cluster.local.", dns.TypeA)))
+
+	resp := queryFrom(h, "10.2.0.1", "api.acme.local.", dns.TypeAAAA)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "fd00::1", resp.Answer[0].(*dns.AAAA).AAAA.String())
+	assert.True(t, resp.Authoritative)
+
+	// Overridden names without addresses of the type have no data.
+	resp = queryFrom(h, "10.4.0.1", "api.acme.local.", dns.TypeAAAA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+}
+
+func TestViewsReload(t *testing.T) {
+	backend := &fakeBackend{records: map[string]string{"api.acme.local.": "10.0.0.1"}}
+	source := &staticConfig{config: config.NewDefaultConfig()}
+	h := NewHandler(backend, Options{Domain: testDomain, Config: source})
+
+	assert.Equal(t, "10.0.0.1", answerIP(t, queryFrom(h, "10.2.0.1", "api.acme.local.", dns.TypeA)))
+
+	source.config = &config.Config{
+		Views: map[string]config.View{
+			"10.2.0.0/16": {Records: map[string][]string{"api.acme.local": {"10.3.0.1"}}},
+		},
+	}
+	assert.Equal(t, "10.3.0.1", answerIP(t, queryFrom(h, "10.2.0.1", "api.acme.local.", dns.TypeA)))
+
+	source.config = config.NewDefaultConfig()
+	assert.Equal(t, "10.0.0.1", answerIP(t, queryFrom(h, "10.2.0.1", "api.acme.local.", dns.TypeA)))
+}
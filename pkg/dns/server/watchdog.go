@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// watchdog is a dns.Handler that detects when queries stop being
+// processed. It fires if there are queries in progress and none of them
+// has completed for interval.
+type watchdog struct {
+	next     dns.Handler
+	interval time.Duration
+	stalls   prometheus.Counter
+	onStall  func()
+
+	// pending is the number of queries in progress.
+	pending int64
+	// progress is the time (in UnixNano) a query last completed, or
+	// arrived while none was pending.
+	progress int64
+}
+
+// newWatchdog checks for stalls in next until stop is closed. When a
+// stall is detected, stalls is incremented and onStall (if not nil) is
+// called.
+func newWatchdog(next dns.Handler, interval time.Duration, stalls prometheus.Counter, onStall func(), stop <-chan struct{}) *watchdog {
+	w := &watchdog{
+		next:     next,
+		interval: interval,
+		stalls:   stalls,
+		onStall:  onStall,
+		progress: time.Now().UnixNano(),
+	}
+	go w.run(stop)
+	return w
+}
+
+// ServeDNS implements dns.Handler.
+func (w *watchdog) ServeDNS(rw dns.ResponseWriter, req *dns.Msg) {
+	if atomic.AddInt64(&w.pending, 1) == 1 {
+		atomic.StoreInt64(&w.progress, time.Now().UnixNano())
+	}
+	defer func() {
+		atomic.StoreInt64(&w.progress, time.Now().UnixNano())
+		atomic.AddInt64(&w.pending, -1)
+	}()
+	w.next.ServeDNS(rw, req)
+}
+
+func (w *watchdog) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.interval / 4)
+	defer ticker.Stop()
+
+	// stalled is the progress time of the last stall reported, so each
+	// stall is only reported once.
+	var stalled int64
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		pending := atomic.LoadInt64(&w.pending)
+		progress := atomic.LoadInt64(&w.progress)
+		since := time.Since(time.Unix(0, progress))
+		if pending == 0 || since < w.interval || progress == stalled {
+			continue
+		}
+		stalled = progress
+		glog.Errorf("No DNS query completed in %v with %d queries in progress", since, pending)
+		w.stalls.Inc()
+		if w.onStall != nil {
+			w.onStall()
+		}
+	}
+}
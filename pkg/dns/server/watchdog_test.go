@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdog(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	stalls := watchdogStalls.WithLabelValues("test")
+	stallsBefore := counterValue(stalls)
+	fired := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// Slow queries that keep completing do not fire the watchdog.
+	handler := &blockingHandler{serveDuration: interval / 4}
+	w := newWatchdog(handler, interval, stalls, func() { fired <- struct{}{} }, stop)
+	for i := 0; i < 10; i++ {
+		query(w, "www.example.com.", dns.TypeA)
+	}
+	// Neither does being idle.
+	time.Sleep(2 * interval)
+	select {
+	case <-fired:
+		t.Fatalf("watchdog fired without a stall")
+	default:
+	}
+
+	// A stalled handler does.
+	handler.release = make(chan struct{})
+	go query(w, "www.example.com.", dns.TypeA)
+	select {
+	case <-fired:
+	case <-time.After(10 * interval):
+		t.Fatalf("watchdog did not fire")
+	}
+	assert.Equal(t, float64(1), counterValue(stalls)-stallsBefore)
+
+	// The stall is only reported once.
+	time.Sleep(2 * interval)
+	assert.Equal(t, float64(1), counterValue(stalls)-stallsBefore)
+	close(handler.release)
+}
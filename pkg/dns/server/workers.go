@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"runtime"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// workersPerCPU is the default number of handler workers per CPU. Workers
+// block while waiting for upstream nameservers, so there are many more
+// workers than CPUs.
+const workersPerCPU = 64
+
+// DefaultHandlerWorkers returns the default size of the worker pool for
+// each protocol.
+func DefaultHandlerWorkers() int {
+	return workersPerCPU * runtime.GOMAXPROCS(0)
+}
+
+// workerPool is a dns.Handler that processes requests on a fixed number
+// of workers. Requests wait for a free worker before being passed to
+// next.
+type workerPool struct {
+	next  dns.Handler
+	queue chan *workerRequest
+	stop  <-chan struct{}
+	depth prometheus.Gauge
+}
+
+type workerRequest struct {
+	w    dns.ResponseWriter
+	req  *dns.Msg
+	done chan struct{}
+}
+
+// newWorkerPool starts workers processing requests for next until stop
+// is closed. depth tracks the number of requests waiting for a worker.
+func newWorkerPool(next dns.Handler, workers int, depth prometheus.Gauge, stop <-chan struct{}) *workerPool {
+	p := &workerPool{
+		next:  next,
+		queue: make(chan *workerRequest),
+		stop:  stop,
+		depth: depth,
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// ServeDNS implements dns.Handler. It returns once the request has been
+// processed as the dns.ResponseWriter is only valid until then.
+func (p *workerPool) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	r := &workerRequest{w: w, req: req, done: make(chan struct{})}
+
+	p.depth.Inc()
+	select {
+	case p.queue <- r:
+		p.depth.Dec()
+	case <-p.stop:
+		p.depth.Dec()
+		return
+	}
+	<-r.done
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case r := <-p.queue:
+			p.next.ServeDNS(r.w, r.req)
+			close(r.done)
+		case <-p.stop:
+			return
+		}
+	}
+}
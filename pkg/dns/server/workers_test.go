@@ -0,0 +1,145 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingHandler counts concurrent requests and blocks each of them
+// until release is closed.
+type blockingHandler struct {
+	lock          sync.Mutex
+	active        int
+	maxActive     int
+	release       chan struct{}
+	serveDuration time.Duration
+}
+
+func (h *blockingHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	h.lock.Lock()
+	h.active++
+	if h.active > h.maxActive {
+		h.maxActive = h.active
+	}
+	h.lock.Unlock()
+
+	if h.release != nil {
+		<-h.release
+	}
+	time.Sleep(h.serveDuration)
+
+	h.lock.Lock()
+	h.active--
+	h.lock.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	w.WriteMsg(m)
+}
+
+func gaugeValue(g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	g.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestWorkerPool(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	next := &blockingHandler{release: make(chan struct{})}
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_depth"})
+	pool := newWorkerPool(next, 2, depth, stop)
+
+	var wg sync.WaitGroup
+	responses := make(chan *dns.Msg, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses <- query(pool, "a.ns.svc.cluster.local.", dns.TypeA)
+		}()
+	}
+
+	// Two requests are being processed, the other three are queued.
+	for i := 0; gaugeValue(depth) != 3; i++ {
+		if i == 100 {
+			t.Fatalf("expected queue depth 3, got %v", gaugeValue(depth))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(next.release)
+	wg.Wait()
+	close(responses)
+
+	count := 0
+	for resp := range responses {
+		assert.NotNil(t, resp)
+		count++
+	}
+	assert.Equal(t, 5, count)
+	assert.Equal(t, 2, next.maxActive)
+	assert.Equal(t, float64(0), gaugeValue(depth))
+}
+
+func TestWorkerPoolStop(t *testing.T) {
+	stop := make(chan struct{})
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_depth"})
+	pool := newWorkerPool(&blockingHandler{}, 1, depth, stop)
+	close(stop)
+
+	// Requests do not block once the pool is stopped.
+	done := make(chan *dns.Msg)
+	go func() { done <- query(pool, "a.ns.svc.cluster.local.", dns.TypeA) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request blocked on a stopped pool")
+	}
+}
+
+// BenchmarkWorkerPool shows how throughput scales with the number of
+// workers when each query waits (e.g. on an upstream nameserver).
+func BenchmarkWorkerPool(b *testing.B) {
+	for _, workers := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			stop := make(chan struct{})
+			defer close(stop)
+
+			depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "bench_depth"})
+			pool := newWorkerPool(
+				&blockingHandler{serveDuration: time.Millisecond}, workers, depth, stop)
+
+			b.SetParallelism(64)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					query(pool, "a.ns.svc.cluster.local.", dns.TypeA)
+				}
+			})
+		})
+	}
+}
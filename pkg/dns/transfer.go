@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	skymsg "github.com/skynetservices/skydns/msg"
+)
+
+// TransferRecords returns the records of the cluster domain and the PTR
+// records of the service and named endpoint IPs, for zone transfers. Pod
+// records, which are synthesized from the query name, and federation
+// records are not included.
+func (kd *KubeDNS) TransferRecords() []dns.RR {
+	kd.cacheLock.RLock()
+	defer kd.cacheLock.RUnlock()
+
+	seen := make(map[string]bool)
+	var records []dns.RR
+	add := func(rr dns.RR) {
+		if key := rr.String(); !seen[key] {
+			seen[key] = true
+			records = append(records, rr)
+		}
+	}
+
+	for _, record := range kd.cache.Values() {
+		name := skymsg.Domain(record.Key)
+		// The last label of the key of a service or endpoint record is
+		// its hash or endpoint name; that of an ExternalName service is
+		// the service itself.
+		parent := name
+		if labels := dns.SplitDomainName(name); len(labels) > 1 {
+			parent = dns.Fqdn(strings.Join(labels[1:], "."))
+		}
+		ip := net.ParseIP(record.Host)
+		switch {
+		case record.Port != 0:
+			add(record.NewSRV(parent, uint16(record.Weight)))
+		case ip == nil:
+			add(record.NewCNAME(name, dns.Fqdn(record.Host)))
+		default:
+			names := []string{parent}
+			if kd.isHeadlessServiceRecord(record) {
+				// Endpoints of headless services also have their
+				// own name.
+				names = append(names, name)
+			}
+			for _, n := range names {
+				if ip.To4() != nil {
+					add(record.NewA(n, ip.To4()))
+				} else {
+					add(record.NewAAAA(n, ip))
+				}
+			}
+		}
+	}
+
+	for name, texts := range kd.serviceTXT {
+		for i := range texts {
+			add(texts[i].NewTXT(name))
+		}
+	}
+
+	for ip, record := range kd.reverseRecordMap {
+		if name, err := dns.ReverseAddr(ip); err == nil {
+			add(record.NewPTR(name, record.Ttl))
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].String() < records[j].String()
+	})
+	return records
+}
@@ -48,6 +48,12 @@ type TreeCache interface {
 	// DeletePath removes all entries associated with a given path.
 	DeletePath(path ...string) bool
 
+	// Size returns the number of entries in the tree.
+	Size() int
+
+	// Values returns the values of all entries in the tree.
+	Values() []*skymsg.Service
+
 	// Serialize dumps a JSON representation of the cache.
 	Serialize() (string, error)
 }
@@ -188,6 +194,24 @@ func (cache *treeCache) DeletePath(path ...string) bool {
 	return false
 }
 
+func (cache *treeCache) Size() int {
+	size := len(cache.Entries)
+	for _, node := range cache.ChildNodes {
+		size += node.Size()
+	}
+	return size
+}
+
+func (cache *treeCache) Values() []*skymsg.Service {
+	ref := [][]interface{}{{}}
+	cache.appendValues(true, ref)
+	values := make([]*skymsg.Service, 0, len(ref[0]))
+	for _, value := range ref[0] {
+		values = append(values, value.(*skymsg.Service))
+	}
+	return values
+}
+
 func (cache *treeCache) appendValues(recursive bool, ref [][]interface{}) {
 	for _, value := range cache.Entries {
 		ref[0] = append(ref[0], value)
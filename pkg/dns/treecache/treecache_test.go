@@ -17,6 +17,8 @@ limitations under the License.
 package treecache
 
 import (
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/skynetservices/skydns/msg"
@@ -130,6 +132,47 @@ func TestTreeCacheSetSubCache(t *testing.T) {
 	}
 }
 
+func TestTreeCacheSize(t *testing.T) {
+	tc := NewTreeCache()
+	if size := tc.Size(); size != 0 {
+		t.Errorf("size of empty cache = %v, want 0", size)
+	}
+
+	tc.SetEntry("key1", &msg.Service{}, "key1.p1.", "p1")
+	branch := NewTreeCache()
+	branch.SetEntry("key2", &msg.Service{}, "key2", "p2")
+	branch.SetEntry("key3", &msg.Service{}, "key3", "p2", "p3")
+	tc.SetSubCache("p1", branch, "p0")
+	if size := tc.Size(); size != 3 {
+		t.Errorf("size = %v, want 3", size)
+	}
+
+	tc.DeletePath("p0", "p1")
+	if size := tc.Size(); size != 1 {
+		t.Errorf("size after delete = %v, want 1", size)
+	}
+}
+
+func TestTreeCacheValues(t *testing.T) {
+	tc := NewTreeCache()
+	if values := tc.Values(); len(values) != 0 {
+		t.Errorf("values of empty cache = %v, want none", values)
+	}
+
+	tc.SetEntry("key1", &msg.Service{Host: "1.1.1.1"}, "key1.p1.", "p1")
+	branch := NewTreeCache()
+	branch.SetEntry("key2", &msg.Service{Host: "2.2.2.2"}, "key2", "p2")
+	tc.SetSubCache("p1", branch, "p0")
+	var hosts []string
+	for _, value := range tc.Values() {
+		hosts = append(hosts, value.Host)
+	}
+	sort.Strings(hosts)
+	if !reflect.DeepEqual(hosts, []string{"1.1.1.1", "2.2.2.2"}) {
+		t.Errorf("hosts of values = %v, want [1.1.1.1 2.2.2.2]", hosts)
+	}
+}
+
 func TestTreeCacheSerialize(t *testing.T) {
 	tc := NewTreeCache()
 	tc.SetEntry("key1", &msg.Service{}, "key1.p2.p1.", "p1", "p2")
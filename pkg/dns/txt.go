@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	skymsg "github.com/skynetservices/skydns/msg"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/dns/pkg/dns/util"
+)
+
+const (
+	// TXTAnnotation on a service holds the TXT records of
+	// <service>.<namespace>.svc.<domain>, one record per line.
+	TXTAnnotation = "dns.alpha.kubernetes.io/txt"
+
+	// maxTXTString is the maximum length of a character-string in a TXT
+	// record. Longer records are split into several strings.
+	maxTXTString = 255
+	// maxTXTSize is the maximum size in bytes of the TXT records of a
+	// service, including the length octet of each string.
+	maxTXTSize = 4096
+)
+
+// parseTXTAnnotation returns the TXT records in the value of
+// TXTAnnotation, skipping empty lines.
+func parseTXTAnnotation(value string) ([]string, error) {
+	var records []string
+	size := 0
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		strs := (len(line) + maxTXTString - 1) / maxTXTString
+		size += len(line) + strs
+		if size > maxTXTSize {
+			return nil, fmt.Errorf("TXT records are larger than %d bytes", maxTXTSize)
+		}
+		records = append(records, line)
+	}
+	return records, nil
+}
+
+// setServiceTXT updates the TXT records of service from its annotation.
+func (kd *KubeDNS) setServiceTXT(service *v1.Service) {
+	fqdn := strings.ToLower(kd.fqdn(service))
+	var records []skymsg.Service
+	if value, ok := service.Annotations[TXTAnnotation]; ok {
+		texts, err := parseTXTAnnotation(value)
+		if err != nil {
+			glog.Warningf("Ignoring %v annotation of service %v/%v: %v",
+				TXTAnnotation, service.Namespace, service.Name, err)
+		}
+		for _, text := range texts {
+			record := util.NewServiceRecord("", 0)
+			record.Text = text
+			records = append(records, *record)
+		}
+	}
+
+	kd.cacheLock.Lock()
+	defer kd.cacheLock.Unlock()
+	if len(records) == 0 {
+		delete(kd.serviceTXT, fqdn)
+		return
+	}
+	glog.V(2).Infof("Added %d TXT records for %v", len(records), fqdn)
+	kd.serviceTXT[fqdn] = records
+}
+
+// removeServiceTXT removes the TXT records of service. Assumes that we
+// already have the cacheLock.
+func (kd *KubeDNS) removeServiceTXT(service *v1.Service) {
+	delete(kd.serviceTXT, strings.ToLower(kd.fqdn(service)))
+}
+
+// TXTRecords returns the TXT records of name, or nil if it has none.
+func (kd *KubeDNS) TXTRecords(name string) []skymsg.Service {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	kd.cacheLock.RLock()
+	defer kd.cacheLock.RUnlock()
+	return kd.serviceTXT[name]
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTXTAnnotation(t *testing.T) {
+	for _, testCase := range []struct {
+		value    string
+		expected []string
+		hasError bool
+	}{
+		{value: "", expected: nil},
+		{value: "owner=team-a", expected: []string{"owner=team-a"}},
+		{
+			value:    "owner=team-a\n\n  contact=team-a@example.com  \n",
+			expected: []string{"owner=team-a", "contact=team-a@example.com"},
+		},
+		{
+			// Long records are split into several strings.
+			value:    strings.Repeat("a", 1000),
+			expected: []string{strings.Repeat("a", 1000)},
+		},
+		{
+			// 4080 bytes plus 16 length octets.
+			value:    strings.Repeat("a", 4080),
+			expected: []string{strings.Repeat("a", 4080)},
+		},
+		{value: strings.Repeat("a", 4081), hasError: true},
+		{value: strings.Repeat(strings.Repeat("a", 99)+"\n", 41), hasError: true},
+	} {
+		records, err := parseTXTAnnotation(testCase.value)
+		if testCase.hasError {
+			assert.Error(t, err, "%.20q", testCase.value)
+			continue
+		}
+		assert.NoError(t, err, "%.20q", testCase.value)
+		assert.Equal(t, testCase.expected, records, "%.20q", testCase.value)
+	}
+}
+
+func TestServiceTXT(t *testing.T) {
+	kd := newKubeDNS()
+	name := testService + "." + testNamespace + ".svc." + testDomain
+
+	s := newService(testNamespace, testService, "1.2.3.4", "http", 80)
+	s.Annotations = map[string]string{TXTAnnotation: "owner=team-a\ncontact=team-a@example.com"}
+	kd.newService(s)
+
+	records := kd.TXTRecords(strings.ToUpper(name))
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "owner=team-a", records[0].Text)
+		assert.Equal(t, "contact=team-a@example.com", records[1].Text)
+		assert.Equal(t, uint32(30), records[0].Ttl)
+	}
+	assert.Nil(t, kd.TXTRecords("other."+testNamespace+".svc."+testDomain))
+
+	// The records follow changes of the annotation.
+	updated := *s
+	updated.Annotations = map[string]string{TXTAnnotation: "owner=team-b"}
+	kd.updateService(s, &updated)
+	records = kd.TXTRecords(name)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "owner=team-b", records[0].Text)
+	}
+
+	// An invalid annotation is ignored.
+	invalid := updated
+	invalid.Annotations = map[string]string{TXTAnnotation: strings.Repeat("a", maxTXTSize)}
+	kd.updateService(&updated, &invalid)
+	assert.Nil(t, kd.TXTRecords(name))
+
+	kd.updateService(&invalid, &updated)
+	kd.removeService(&updated)
+	assert.Nil(t, kd.TXTRecords(name))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	skymsg "github.com/skynetservices/skydns/msg"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// EndpointWeightsAnnotation on a headless service holds the weights
+	// of its endpoints, one "<hostname or IP>=<weight>" per line. The
+	// records of the service are returned in a random order where each
+	// endpoint comes first with a probability proportional to its
+	// weight. Endpoints that are not listed have weight 1.
+	EndpointWeightsAnnotation = "dns.alpha.kubernetes.io/endpoint-weights"
+
+	// maxEndpointWeight is the maximum weight of an endpoint.
+	maxEndpointWeight = 100
+)
+
+// parseEndpointWeights returns the endpoint weights in the value of
+// EndpointWeightsAnnotation, skipping empty lines.
+func parseEndpointWeights(value string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid endpoint weight %q, expected <hostname or IP>=<weight>", line)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || weight < 1 || weight > maxEndpointWeight {
+			return nil, fmt.Errorf("invalid weight in %q, must be between 1 and %d", line, maxEndpointWeight)
+		}
+		weights[strings.TrimSpace(parts[0])] = weight
+	}
+	return weights, nil
+}
+
+// endpointWeights returns the endpoint weights of service, or nil if it
+// has none.
+func endpointWeights(service *v1.Service) map[string]int {
+	value, ok := service.Annotations[EndpointWeightsAnnotation]
+	if !ok {
+		return nil
+	}
+	weights, err := parseEndpointWeights(value)
+	if err != nil {
+		glog.Warningf("Ignoring %v annotation of service %v/%v: %v",
+			EndpointWeightsAnnotation, service.Namespace, service.Name, err)
+		return nil
+	}
+	return weights
+}
+
+// endpointWeight returns the weight of the endpoint with address, given
+// the weights of its service.
+func endpointWeight(weights map[string]int, address *v1.EndpointAddress) int {
+	if hostname, ok := getHostname(address); ok {
+		if weight, ok := weights[hostname]; ok {
+			return weight
+		}
+	}
+	if weight, ok := weights[address.IP]; ok {
+		return weight
+	}
+	return 1
+}
+
+// weightedOrder shuffles records so that each record comes first with a
+// probability proportional to its weight. Records with equal weights,
+// e.g. those of services without endpoint weights, are left in place.
+func weightedOrder(records []skymsg.Service) {
+	total := 0
+	weighted := false
+	for i := range records {
+		total += records[i].Weight
+		weighted = weighted || records[i].Weight != records[0].Weight
+	}
+	if !weighted {
+		return
+	}
+	for i := range records {
+		n := rand.Intn(total)
+		for j := i; j < len(records); j++ {
+			if n < records[j].Weight {
+				records[i], records[j] = records[j], records[i]
+				break
+			}
+			n -= records[j].Weight
+		}
+		total -= records[i].Weight
+	}
+}
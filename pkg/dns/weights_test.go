@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestParseEndpointWeights(t *testing.T) {
+	for _, testCase := range []struct {
+		value    string
+		expected map[string]int
+		hasError bool
+	}{
+		{value: "", expected: map[string]int{}},
+		{value: "web-0=3", expected: map[string]int{"web-0": 3}},
+		{
+			value:    "web-0 = 3\n\n  10.0.0.2=100  \n",
+			expected: map[string]int{"web-0": 3, "10.0.0.2": 100},
+		},
+		{value: "web-0", hasError: true},
+		{value: "=3", hasError: true},
+		{value: "web-0=a", hasError: true},
+		{value: "web-0=0", hasError: true},
+		{value: "web-0=101", hasError: true},
+	} {
+		weights, err := parseEndpointWeights(testCase.value)
+		if testCase.hasError {
+			assert.Error(t, err, "%q", testCase.value)
+			continue
+		}
+		assert.NoError(t, err, "%q", testCase.value)
+		assert.Equal(t, testCase.expected, weights, "%q", testCase.value)
+	}
+}
+
+func TestEndpointWeights(t *testing.T) {
+	kd := newKubeDNS()
+	service := newHeadlessService()
+	service.Annotations = map[string]string{EndpointWeightsAnnotation: "web-0=6\n10.0.0.2=3"}
+	assert.NoError(t, kd.servicesStore.Add(service))
+	subset := newSubsetWithOnePort("", 80)
+	subset.Addresses = []v1.EndpointAddress{
+		{IP: "10.0.0.1", Hostname: "web-0"},
+		{IP: "10.0.0.2", Hostname: "web-1"},
+		{IP: "10.0.0.3", Hostname: "web-2"},
+	}
+	endpoints := newEndpoints(service, subset)
+	assert.NoError(t, kd.endpointsStore.Add(endpoints))
+	kd.newService(service)
+
+	// Each endpoint comes first with a probability proportional to its
+	// weight.
+	const queries = 10000
+	first := make(map[string]int)
+	for i := 0; i < queries; i++ {
+		records, err := kd.Records(kd.fqdn(service), false)
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		first[records[0].Host]++
+	}
+	for ip, weight := range map[string]int{"10.0.0.1": 6, "10.0.0.2": 3, "10.0.0.3": 1} {
+		assert.InDelta(t, float64(weight)/10, float64(first[ip])/queries, 0.03, ip)
+	}
+
+	// Without the annotation, the records keep their weights and order.
+	service.Annotations = nil
+	kd.newService(service)
+	records, err := kd.Records(kd.fqdn(service), false)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	for _, record := range records {
+		assert.Equal(t, records[0].Weight, record.Weight)
+	}
+}
@@ -100,10 +100,12 @@ func (cl *Cluster) resolveDirs() {
 	}
 }
 
+// pullImages is a preflight check that all of the images needed by the
+// cluster are available before any containers are started.
 func (cl *Cluster) pullImages() {
-	cl.Docker.Pull(
-		cl.EtcdImage,
-		cl.HyperkubeImage)
+	if err := cl.Docker.EnsureImages(cl.EtcdImage, cl.HyperkubeImage); err != nil {
+		log.Fatalf("Preflight image check failed: %v", err)
+	}
 }
 
 func (cl *Cluster) StartEtcd() {
@@ -0,0 +1,186 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const digImage = "tutum/dnsutils:latest"
+
+// DNSClusterOptions configure a DNSCluster.
+type DNSClusterOptions struct {
+	// Name of the kube-dns container. The network is named after it.
+	Name string
+	// Image of kube-dns.
+	Image string
+	// Args passed to kube-dns, e.g. the master URL.
+	Args []string
+	// Mounts into the kube-dns container, e.g. a config directory.
+	Mounts []Mount
+	// DigImage is the image the queries are run from. It must contain
+	// dig.
+	DigImage string
+	// Domain served by kube-dns.
+	Domain string
+	// Timeout for kube-dns to become healthy.
+	Timeout time.Duration
+	// PollInterval between health checks.
+	PollInterval time.Duration
+}
+
+// DefaultDNSClusterOptions returns the options to run image.
+func DefaultDNSClusterOptions(image string) DNSClusterOptions {
+	return DNSClusterOptions{
+		Name:         "e2e-kube-dns",
+		Image:        image,
+		DigImage:     digImage,
+		Domain:       "cluster.local.",
+		Timeout:      StandardTimeout,
+		PollInterval: 500 * time.Millisecond,
+	}
+}
+
+// DNSCluster runs a kube-dns container on its own network and queries it
+// from throwaway containers on the same network.
+type DNSCluster struct {
+	options DNSClusterOptions
+	docker  Docker
+
+	network string
+	ip      string
+}
+
+// Response to a Lookup.
+type Response struct {
+	// Rcode of the response, e.g. "NOERROR".
+	Rcode string
+	// Answer section, one record per entry.
+	Answer []string
+}
+
+// NewDNSCluster starts kube-dns and waits until it answers queries. If it
+// does not, everything that was started is torn down.
+func NewDNSCluster(docker Docker, options DNSClusterOptions) (*DNSCluster, error) {
+	c := &DNSCluster{
+		options: options,
+		docker:  docker,
+		network: options.Name,
+	}
+
+	if err := docker.CreateNetwork(c.network); err != nil {
+		return nil, err
+	}
+
+	args := append([]string{
+		"-d", "--name", options.Name, "--network", c.network, options.Image},
+		options.Args...)
+	if _, err := docker.RunWithMounts(options.Mounts, args...); err != nil {
+		docker.RemoveNetwork(c.network)
+		return nil, err
+	}
+
+	if err := c.waitForHealthy(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	log.Printf("kube-dns is running on %v", c.ip)
+	return c, nil
+}
+
+// waitForHealthy waits for kube-dns to get an IP on the network and to
+// answer for its domain.
+func (c *DNSCluster) waitForHealthy() error {
+	format := fmt.Sprintf(
+		"{{(index .NetworkSettings.Networks %q).IPAddress}}", c.network)
+	deadline := time.Now().Add(c.options.Timeout)
+
+	var err error
+	for {
+		if c.ip == "" {
+			if c.ip, err = c.docker.Inspect(c.options.Name, format); err == nil && c.ip == "" {
+				err = fmt.Errorf("no IP address on network %v", c.network)
+			}
+		}
+		if c.ip != "" {
+			var resp *Response
+			if resp, err = c.Lookup(c.options.Domain, "SOA"); err == nil && resp.Rcode != "NOERROR" {
+				err = fmt.Errorf("SOA query returned %v", resp.Rcode)
+			}
+			if err == nil {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("kube-dns did not become healthy in %v: %v",
+				c.options.Timeout, err)
+		}
+		time.Sleep(c.options.PollInterval)
+	}
+}
+
+// IP of kube-dns on the network.
+func (c *DNSCluster) IP() string {
+	return c.ip
+}
+
+// Lookup queries kube-dns for name and qtype (e.g. "A", "SRV").
+func (c *DNSCluster) Lookup(name, qtype string) (*Response, error) {
+	output, err := c.docker.RunOutput(
+		"--rm", "--network", c.network, c.options.DigImage,
+		"dig", "+noall", "+comments", "+answer", "@"+c.ip, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	return parseDig(output)
+}
+
+// Close removes kube-dns and its network.
+func (c *DNSCluster) Close() error {
+	c.docker.Remove(c.options.Name)
+	return c.docker.RemoveNetwork(c.network)
+}
+
+// digStatusRegexp matches the rcode in the header comment of dig, e.g.
+// ";; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1234".
+var digStatusRegexp = regexp.MustCompile(`->>HEADER<<-.*status: ([A-Z]+)`)
+
+// parseDig parses the output of "dig +noall +comments +answer".
+func parseDig(output string) (*Response, error) {
+	resp := &Response{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, ";"):
+			if match := digStatusRegexp.FindStringSubmatch(line); match != nil {
+				resp.Rcode = match[1]
+			}
+		default:
+			resp.Answer = append(resp.Answer, strings.Join(strings.Fields(line), " "))
+		}
+	}
+	if resp.Rcode == "" {
+		return nil, fmt.Errorf("no response in dig output %q", output)
+	}
+	return resp, nil
+}
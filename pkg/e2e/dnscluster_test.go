@@ -0,0 +1,187 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const digNoError = `; <<>> DiG 9.9.5 <<>> +noall +comments +answer @172.18.0.2 kubernetes.default.svc.cluster.local. A
+;; global options: +cmd
+;; Got answer:
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 4242
+;; flags: qr aa rd ra; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 0
+
+;; ANSWER SECTION:
+kubernetes.default.svc.cluster.local. 30 IN	A	10.0.0.1
+`
+
+const digNXDomain = `;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 4243
+;; flags: qr aa rd ra; QUERY: 1, ANSWER: 0, AUTHORITY: 1, ADDITIONAL: 0
+`
+
+// fakeDocker records the commands it is given. Containers run with dig
+// return the next of digOutputs.
+type fakeDocker struct {
+	calls      []string
+	ip         string
+	digOutputs []string
+}
+
+var _ Docker = (*fakeDocker)(nil)
+
+func (d *fakeDocker) record(format string, args ...interface{}) {
+	d.calls = append(d.calls, fmt.Sprintf(format, args...))
+}
+
+func (d *fakeDocker) Start()                                 {}
+func (d *fakeDocker) Stop()                                  {}
+func (d *fakeDocker) TryStop(ctx context.Context) error      { return nil }
+func (d *fakeDocker) Pull(images ...string)                  {}
+func (d *fakeDocker) TryPull(images ...string) error         { return nil }
+func (d *fakeDocker) ImageExists(image string) (bool, error) { return true, nil }
+func (d *fakeDocker) EnsureImages(images ...string) error    { return nil }
+func (d *fakeDocker) Tag(src, dst string) error              { return nil }
+func (d *fakeDocker) Push(image string) error                { return nil }
+func (d *fakeDocker) Run(args ...string) string              { return "" }
+func (d *fakeDocker) Kill(tag string)                        {}
+func (d *fakeDocker) Restart(tag string) error               { return nil }
+func (d *fakeDocker) Pause(tag string) error                 { return nil }
+func (d *fakeDocker) Unpause(tag string) error               { return nil }
+func (d *fakeDocker) VolumeCreate(name string) error         { return nil }
+func (d *fakeDocker) VolumeRemove(name string) error         { return nil }
+func (d *fakeDocker) CleanupVolumes() error                  { return nil }
+func (d *fakeDocker) List(filter string) []string            { return nil }
+
+func (d *fakeDocker) TryList(ctx context.Context, filter string) ([]string, error) {
+	return nil, nil
+}
+
+func (d *fakeDocker) Stats(tag string) (ContainerStats, error) {
+	return ContainerStats{}, nil
+}
+
+func (d *fakeDocker) StatsStream(ctx context.Context, tag string) (<-chan ContainerStats, error) {
+	return nil, nil
+}
+
+func (d *fakeDocker) Events(ctx context.Context) (<-chan Event, error) {
+	return nil, nil
+}
+
+func (d *fakeDocker) RunOutput(args ...string) (string, error) {
+	cmd := strings.Join(args, " ")
+	d.record("run %v", cmd)
+	if !strings.Contains(cmd, " dig ") {
+		return "0123456789ab", nil
+	}
+	if len(d.digOutputs) == 0 {
+		return "", fmt.Errorf("connection timed out; no servers could be reached")
+	}
+	output := d.digOutputs[0]
+	d.digOutputs = d.digOutputs[1:]
+	return output, nil
+}
+
+func (d *fakeDocker) RunWithMounts(mounts []Mount, args ...string) (string, error) {
+	volumes, err := mountArgs(mounts)
+	if err != nil {
+		return "", err
+	}
+	return d.RunOutput(append(volumes, args...)...)
+}
+
+func (d *fakeDocker) Inspect(tag, format string) (string, error) {
+	d.record("inspect %v %v", tag, format)
+	return d.ip, nil
+}
+
+func (d *fakeDocker) CreateNetwork(name string) error {
+	d.record("network create %v", name)
+	return nil
+}
+
+func (d *fakeDocker) RemoveNetwork(name string) error {
+	d.record("network rm %v", name)
+	return nil
+}
+
+func (d *fakeDocker) Remove(tag string) {
+	d.record("rm %v", tag)
+}
+
+func testDNSClusterOptions() DNSClusterOptions {
+	options := DefaultDNSClusterOptions("kube-dns:test")
+	options.Args = []string{"--kube-master-url=http://10.0.0.1:8080"}
+	options.Timeout = 100 * time.Millisecond
+	options.PollInterval = time.Millisecond
+	return options
+}
+
+func TestDNSCluster(t *testing.T) {
+	docker := &fakeDocker{
+		ip: "172.18.0.2",
+		// kube-dns is not serving yet on the first health check.
+		digOutputs: []string{"", digNoError, digNoError, digNXDomain},
+	}
+	c, err := NewDNSCluster(docker, testDNSClusterOptions())
+	require.NoError(t, err)
+	assert.Equal(t, "172.18.0.2", c.IP())
+
+	dig := "run --rm --network e2e-kube-dns tutum/dnsutils:latest dig +noall +comments +answer @172.18.0.2 "
+	assert.Equal(t, []string{
+		"network create e2e-kube-dns",
+		"run -d --name e2e-kube-dns --network e2e-kube-dns kube-dns:test --kube-master-url=http://10.0.0.1:8080",
+		`inspect e2e-kube-dns {{(index .NetworkSettings.Networks "e2e-kube-dns").IPAddress}}`,
+		dig + "cluster.local. SOA",
+		dig + "cluster.local. SOA",
+	}, docker.calls)
+
+	resp, err := c.Lookup("kubernetes.default.svc.cluster.local.", "A")
+	require.NoError(t, err)
+	assert.Equal(t, &Response{
+		Rcode:  "NOERROR",
+		Answer: []string{"kubernetes.default.svc.cluster.local. 30 IN A 10.0.0.1"},
+	}, resp)
+
+	resp, err = c.Lookup("missing.default.svc.cluster.local.", "A")
+	require.NoError(t, err)
+	assert.Equal(t, &Response{Rcode: "NXDOMAIN"}, resp)
+
+	_, err = c.Lookup("kubernetes.default.svc.cluster.local.", "A")
+	assert.Error(t, err)
+
+	docker.calls = nil
+	assert.NoError(t, c.Close())
+	assert.Equal(t, []string{"rm e2e-kube-dns", "network rm e2e-kube-dns"}, docker.calls)
+}
+
+func TestDNSClusterNotHealthy(t *testing.T) {
+	// kube-dns never gets an IP.
+	docker := &fakeDocker{}
+	_, err := NewDNSCluster(docker, testDNSClusterOptions())
+	assert.Error(t, err)
+	assert.Equal(t, []string{"rm e2e-kube-dns", "network rm e2e-kube-dns"},
+		docker.calls[len(docker.calls)-2:])
+}
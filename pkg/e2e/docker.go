@@ -17,11 +17,20 @@ limitations under the License.
 package e2e
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"k8s.io/dns/pkg/util"
 )
@@ -33,29 +42,171 @@ type Docker interface {
 	Start()
 	// Stop the daemon
 	Stop()
+	// TryStop stops the daemon, returning an error if it cannot be killed
+	// or does not exit before ctx is done.
+	TryStop(ctx context.Context) error
 	// Pull images into docker.
 	Pull(images ...string)
+	// TryPull pulls images into docker like Pull. A failure to pull an
+	// image does not stop the others from being pulled; the returned
+	// error lists every image that could not be pulled.
+	TryPull(images ...string) error
+	// ImageExists returns true if the image is present in the local docker
+	// image store.
+	ImageExists(image string) (bool, error)
+	// EnsureImages pulls any of the given images that are not already
+	// present with TryPull. The returned error lists every image that
+	// could not be obtained.
+	EnsureImages(images ...string) error
+	// Tag creates the tag dst referring to the image src.
+	Tag(src, dst string) error
+	// Push the image to its registry.
+	Push(image string) error
 	// Run calls "docker run" args, returning the UUID of the container.
 	Run(args ...string) string
+	// RunOutput calls "docker run" args, returning the output of the
+	// command (the UUID of the container if it is detached).
+	RunOutput(args ...string) (string, error)
+	// RunWithMounts calls "docker run" args with mounts bind mounted into
+	// the container, returning the output of the command. It fails
+	// before running anything if a mount source does not exist.
+	RunWithMounts(mounts []Mount, args ...string) (string, error)
+	// Inspect returns the result of applying the "docker inspect" format
+	// template to the container named by tag.
+	Inspect(tag, format string) (string, error)
+	// VolumeCreate creates a named volume. It is registered for removal
+	// by CleanupVolumes.
+	VolumeCreate(name string) error
+	// VolumeRemove removes the named volume.
+	VolumeRemove(name string) error
+	// CleanupVolumes removes the volumes created by VolumeCreate that have
+	// not been removed.
+	CleanupVolumes() error
+	// CreateNetwork creates a bridge network.
+	CreateNetwork(name string) error
+	// RemoveNetwork removes the network.
+	RemoveNetwork(name string) error
 	// Remove the container named by tag.
 	Remove(tag string)
 	// Kill the container named by tag.
 	Kill(tag string)
+	// Restart the container named by tag.
+	Restart(tag string) error
+	// Pause all processes in the container named by tag.
+	Pause(tag string) error
+	// Unpause the container named by tag.
+	Unpause(tag string) error
 	// List tags of containers that match filter. If filter is "", then all running containers
 	// will be listed.
 	List(filter string) []string
+	// TryList lists tags of containers like List, returning an error if
+	// docker fails or does not complete before ctx is done.
+	TryList(ctx context.Context, filter string) ([]string, error)
+	// Stats returns a sample of the resource usage of the container named
+	// by tag.
+	Stats(tag string) (ContainerStats, error)
+	// StatsStream samples the resource usage of the container named by
+	// tag until ctx is done or the container exits. The returned channel
+	// is closed when sampling stops.
+	StatsStream(ctx context.Context, tag string) (<-chan ContainerStats, error)
+	// Events streams the events reported by "docker events" until ctx is
+	// done or the stream ends. The returned channel is closed when
+	// streaming stops.
+	Events(ctx context.Context) (<-chan Event, error)
+}
+
+// Mount is a host path bind mounted into a container, or a named volume
+// mounted into it.
+type Mount struct {
+	// Source path on the host. Relative paths are relative to the
+	// working directory.
+	Source string
+	// Volume is the name of the volume to mount instead of Source.
+	Volume string
+	// Target is the absolute path in the container.
+	Target string
+	// ReadOnly mounts the source read-only.
+	ReadOnly bool
+}
+
+// ContainerStats is a sample of the resource usage of a container, as
+// reported by "docker stats".
+type ContainerStats struct {
+	// CPUPercent is the CPU usage, 100 being one full core.
+	CPUPercent float64
+	// MemoryBytes in use.
+	MemoryBytes uint64
+	// NetInputBytes and NetOutputBytes are the total network I/O since the
+	// container started.
+	NetInputBytes  uint64
+	NetOutputBytes uint64
+}
+
+// Event is a container, image, network or volume event reported by
+// "docker events".
+type Event struct {
+	// Type of the object, e.g. "container".
+	Type string
+	// Action on the object, e.g. "start", "die" or "oom".
+	Action string
+	// ID of the object.
+	ID string
+	// Time of the event.
+	Time time.Time
+	// Attributes of the object, e.g. the "name" and "image" of a
+	// container or the "exitCode" of a "die" event.
+	Attributes map[string]string
+}
+
+// DockerOption configures the Docker returned by NewDocker.
+type DockerOption func(*dockerWrapper)
+
+// WithMaxConcurrency bounds the number of docker commands run at the same
+// time to n, so that large parallel test matrices do not overwhelm the
+// daemon. Further commands wait for a running one to complete. Streaming
+// commands (StatsStream, Events) are not limited. n <= 0 means no limit.
+func WithMaxConcurrency(n int) DockerOption {
+	return func(d *dockerWrapper) {
+		if n <= 0 {
+			d.slots = nil
+			return
+		}
+		d.slots = make(chan struct{}, n)
+	}
+}
+
+// WithPullConcurrency pulls up to n images at the same time in Pull and
+// TryPull. By default, images are pulled one at a time.
+func WithPullConcurrency(n int) DockerOption {
+	return func(d *dockerWrapper) {
+		d.pullConcurrency = n
+	}
+}
+
+// WithPullProgress calls progress when each image pulled by Pull or
+// TryPull is done, with the error pulling it if any. Calls are not
+// concurrent.
+func WithPullProgress(progress func(image string, err error)) DockerOption {
+	return func(d *dockerWrapper) {
+		d.pullProgress = progress
+	}
 }
 
 // NewDocker returns a Docker for the default instance running on the host.
-func NewDocker() Docker {
-	return &dockerWrapper{
+func NewDocker(options ...DockerOption) Docker {
+	d := &dockerWrapper{
 		dockerExec:   "docker",
 		manageDaemon: false,
 		baseDir:      "/",
 		cidr:         "10.123.0.0/24",
 		bridge:       "docker0",
 		socket:       "unix:///var/run/docker.sock",
+		sudo:         "sudo",
+	}
+	for _, option := range options {
+		option(d)
 	}
+	return d
 }
 
 type dockerWrapper struct {
@@ -68,6 +219,21 @@ type dockerWrapper struct {
 
 	socket string
 	cmd    *exec.Cmd
+	// sudo is the sudo executable, used to manage the daemon.
+	sudo string
+
+	// volumesLock protects volumes.
+	volumesLock sync.Mutex
+	// volumes created and not removed yet.
+	volumes map[string]bool
+
+	// slots limits the number of concurrent docker commands, if set.
+	slots chan struct{}
+
+	// pullConcurrency is the number of images pulled at the same time.
+	pullConcurrency int
+	// pullProgress is called when each image pull is done, if set.
+	pullProgress func(image string, err error)
 }
 
 var _ Docker = (*dockerWrapper)(nil)
@@ -101,7 +267,7 @@ func (d *dockerWrapper) Start() {
 		"--pidfile=" + pidfile,
 	}
 
-	d.cmd = exec.Command("sudo", args...)
+	d.cmd = exec.Command(d.sudo, args...)
 
 	log.Printf("Starting Docker %v", args)
 	if err := d.cmd.Start(); err != nil {
@@ -112,26 +278,137 @@ func (d *dockerWrapper) Start() {
 }
 
 func (d *dockerWrapper) Stop() {
+	if err := d.TryStop(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (d *dockerWrapper) TryStop(ctx context.Context) error {
 	if !d.manageDaemon {
-		return
+		return nil
 	}
 
 	// Need to use sudo kill as the docker daemon is running as `root`.
-	if err := exec.Command(
-		"sudo", "kill", fmt.Sprintf("%v", d.cmd.Process.Pid)).Run(); err != nil {
-		log.Fatal(err)
+	if err := exec.CommandContext(ctx,
+		d.sudo, "kill", fmt.Sprintf("%v", d.cmd.Process.Pid)).Run(); err != nil {
+		return fmt.Errorf("error killing docker: %v", err)
 	}
-	state, err := d.cmd.Process.Wait()
-	if err != nil {
-		log.Printf("Wait for docker returned %v", err)
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		state, err := d.cmd.Process.Wait()
+		if err != nil {
+			log.Printf("Wait for docker returned %v", err)
+		}
+		log.Printf("Docker exited with %v", state)
+	}()
+	select {
+	case <-waited:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("docker did not exit: %v", ctx.Err())
 	}
-	log.Printf("Docker exited with %v", state)
 }
 
 func (d *dockerWrapper) Pull(images ...string) {
+	if err := d.TryPull(images...); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (d *dockerWrapper) TryPull(images ...string) error {
+	concurrency := d.pullConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	pulling := make(chan struct{}, concurrency)
+	errs := make([]error, len(images))
+	var progressLock sync.Mutex
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		pulling <- struct{}{}
+		go func(i int, image string) {
+			defer wg.Done()
+			defer func() { <-pulling }()
+
+			output, err := d.execute([]string{"-H", d.socket, "pull", image})
+			if err != nil {
+				util.LogWithPrefix("docker", string(output))
+				err = fmt.Errorf("%v (%v)", err, strings.TrimSpace(string(output)))
+			}
+			errs[i] = err
+			if d.pullProgress != nil {
+				progressLock.Lock()
+				d.pullProgress(image, err)
+				progressLock.Unlock()
+			}
+		}(i, image)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", images[i], err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not pull images: %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (d *dockerWrapper) ImageExists(image string) (bool, error) {
+	output, err := d.execute([]string{"-H", d.socket, "inspect", "--type=image", image})
+	if err == nil {
+		return true, nil
+	}
+	if strings.Contains(string(output), "No such image") {
+		return false, nil
+	}
+	return false, fmt.Errorf("error inspecting image %v: %v (%v)",
+		image, err, strings.TrimSpace(string(output)))
+}
+
+func (d *dockerWrapper) EnsureImages(images ...string) error {
+	var missing, failed []string
 	for _, image := range images {
-		d.runCommand([]string{"-H", d.socket, "pull", image})
+		exists, err := d.ImageExists(image)
+		switch {
+		case err != nil:
+			failed = append(failed, err.Error())
+		case exists:
+			log.Printf("Image %v is present", image)
+		default:
+			missing = append(missing, image)
+		}
+	}
+	if err := d.TryPull(missing...); err != nil {
+		failed = append(failed, err.Error())
 	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not obtain images: %v", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (d *dockerWrapper) Tag(src, dst string) error {
+	if output, err := d.execute([]string{"-H", d.socket, "tag", src, dst}); err != nil {
+		return fmt.Errorf("error tagging %v as %v: %v (%v)",
+			src, dst, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (d *dockerWrapper) Push(image string) error {
+	output, err := d.execute([]string{"-H", d.socket, "push", image})
+	util.LogWithPrefix("docker", string(output))
+	if err != nil {
+		return fmt.Errorf("error pushing %v: %v", image, err)
+	}
+	return nil
 }
 
 func (d *dockerWrapper) Run(args ...string) string {
@@ -140,8 +417,10 @@ func (d *dockerWrapper) Run(args ...string) string {
 		args...)
 	log.Printf("docker run %v", args)
 
+	release := d.acquire()
 	cmd := exec.Command(d.dockerExec, args...)
 	output, err := cmd.CombinedOutput()
+	release()
 	util.LogWithPrefix("docker", string(output))
 
 	if err != nil {
@@ -153,6 +432,152 @@ func (d *dockerWrapper) Run(args ...string) string {
 	return strings.TrimSpace(string(output))
 }
 
+func (d *dockerWrapper) RunOutput(args ...string) (string, error) {
+	output, err := d.execute(append([]string{"-H", d.socket, "run"}, args...))
+	if err != nil {
+		return "", fmt.Errorf("docker run returned %v (%v)",
+			err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (d *dockerWrapper) RunWithMounts(mounts []Mount, args ...string) (string, error) {
+	volumes, err := mountArgs(mounts)
+	if err != nil {
+		return "", err
+	}
+	return d.RunOutput(append(volumes, args...)...)
+}
+
+// mountArgs returns the "docker run" flags for mounts.
+func mountArgs(mounts []Mount) ([]string, error) {
+	var args []string
+	for _, mount := range mounts {
+		if mount.Volume != "" {
+			volume, err := volumeArg(mount)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "-v", volume)
+			continue
+		}
+
+		source, err := filepath.Abs(mount.Source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount source %q: %v", mount.Source, err)
+		}
+		if _, err := os.Stat(source); err != nil {
+			return nil, fmt.Errorf("invalid mount source: %v", err)
+		}
+		if !filepath.IsAbs(mount.Target) {
+			return nil, fmt.Errorf("mount target %q is not an absolute path", mount.Target)
+		}
+		// ':' separates the fields of "-v".
+		if strings.Contains(source, ":") || strings.Contains(mount.Target, ":") {
+			return nil, fmt.Errorf("cannot mount %q on %q: paths cannot contain ':'",
+				source, mount.Target)
+		}
+
+		volume := source + ":" + mount.Target
+		if mount.ReadOnly {
+			volume += ":ro"
+		}
+		args = append(args, "-v", volume)
+	}
+	return args, nil
+}
+
+// volumeArg returns the "-v" value mounting the volume of mount.
+func volumeArg(mount Mount) (string, error) {
+	if mount.Source != "" {
+		return "", fmt.Errorf("cannot mount both %q and volume %q on %q",
+			mount.Source, mount.Volume, mount.Target)
+	}
+	// A volume name with '/' would be taken as a host path.
+	if strings.ContainsAny(mount.Volume, ":/") {
+		return "", fmt.Errorf("invalid volume name %q", mount.Volume)
+	}
+	if !filepath.IsAbs(mount.Target) || strings.Contains(mount.Target, ":") {
+		return "", fmt.Errorf("invalid mount target %q for volume %q", mount.Target, mount.Volume)
+	}
+	volume := mount.Volume + ":" + mount.Target
+	if mount.ReadOnly {
+		volume += ":ro"
+	}
+	return volume, nil
+}
+
+func (d *dockerWrapper) Inspect(tag, format string) (string, error) {
+	output, err := d.execute([]string{"-H", d.socket, "inspect", "--format", format, tag})
+	if err != nil {
+		return "", fmt.Errorf("error inspecting %v: %v (%v)",
+			tag, err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (d *dockerWrapper) CreateNetwork(name string) error {
+	if output, err := d.execute([]string{"-H", d.socket, "network", "create", name}); err != nil {
+		return fmt.Errorf("error creating network %v: %v (%v)",
+			name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (d *dockerWrapper) RemoveNetwork(name string) error {
+	if output, err := d.execute([]string{"-H", d.socket, "network", "rm", name}); err != nil {
+		return fmt.Errorf("error removing network %v: %v (%v)",
+			name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (d *dockerWrapper) VolumeCreate(name string) error {
+	if output, err := d.execute([]string{"-H", d.socket, "volume", "create", name}); err != nil {
+		return fmt.Errorf("error creating volume %v: %v (%v)",
+			name, err, strings.TrimSpace(string(output)))
+	}
+	d.volumesLock.Lock()
+	defer d.volumesLock.Unlock()
+	if d.volumes == nil {
+		d.volumes = make(map[string]bool)
+	}
+	d.volumes[name] = true
+	return nil
+}
+
+func (d *dockerWrapper) VolumeRemove(name string) error {
+	if output, err := d.execute([]string{"-H", d.socket, "volume", "rm", name}); err != nil {
+		return fmt.Errorf("error removing volume %v: %v (%v)",
+			name, err, strings.TrimSpace(string(output)))
+	}
+	d.volumesLock.Lock()
+	defer d.volumesLock.Unlock()
+	delete(d.volumes, name)
+	return nil
+}
+
+func (d *dockerWrapper) CleanupVolumes() error {
+	d.volumesLock.Lock()
+	var names []string
+	for name := range d.volumes {
+		names = append(names, name)
+	}
+	d.volumesLock.Unlock()
+	sort.Strings(names)
+
+	var errs []string
+	for _, name := range names {
+		if err := d.VolumeRemove(name); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 func (d *dockerWrapper) Remove(tag string) {
 	d.runCommand([]string{"-H", d.socket, "rm", "-f", tag})
 }
@@ -161,16 +586,54 @@ func (d *dockerWrapper) Kill(tag string) {
 	d.runCommand([]string{"-H", d.socket, "kill", tag})
 }
 
+func (d *dockerWrapper) Restart(tag string) error {
+	return d.containerCommand("restart", tag)
+}
+
+func (d *dockerWrapper) Pause(tag string) error {
+	return d.containerCommand("pause", tag)
+}
+
+func (d *dockerWrapper) Unpause(tag string) error {
+	return d.containerCommand("unpause", tag)
+}
+
+// containerCommand runs the docker command on the container named by
+// tag.
+func (d *dockerWrapper) containerCommand(command, tag string) error {
+	if output, err := d.execute([]string{"-H", d.socket, command, tag}); err != nil {
+		return fmt.Errorf("error running docker %v %v: %v (%v)",
+			command, tag, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 func (d *dockerWrapper) List(filter string) []string {
+	tags, err := d.TryList(context.Background(), filter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tags
+}
+
+func (d *dockerWrapper) TryList(ctx context.Context, filter string) ([]string, error) {
 	args := []string{"-H", d.socket, "ps", "-q"}
 	if filter != "" {
 		args = append(args, "--filter", filter)
 	}
 	log.Printf("docker %v", args)
-	out, err := exec.Command("docker", args...).Output()
+	release, err := d.acquireContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting containers: %v", err)
+	}
+	out, err := exec.CommandContext(ctx, d.dockerExec, args...).Output()
+	release()
 
 	if err != nil {
-		log.Fatalf("Error getting containers: %v", err)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("error getting containers: %v", err)
 	}
 
 	var ret []string
@@ -180,21 +643,231 @@ func (d *dockerWrapper) List(filter string) []string {
 		}
 	}
 
-	return ret
+	return ret, nil
 }
 
-func (d *dockerWrapper) runCommand(args []string) {
+// statsFormat is the "docker stats --format" template parsed by
+// parseStats.
+const statsFormat = "{{.CPUPerc}}\t{{.MemUsage}}\t{{.NetIO}}"
+
+func (d *dockerWrapper) Stats(tag string) (ContainerStats, error) {
+	output, err := d.execute([]string{
+		"-H", d.socket, "stats", "--no-stream", "--format", statsFormat, tag})
+	if err != nil {
+		return ContainerStats{}, fmt.Errorf("error getting stats for %v: %v (%v)",
+			tag, err, strings.TrimSpace(string(output)))
+	}
+	return parseStats(string(output))
+}
+
+func (d *dockerWrapper) StatsStream(ctx context.Context, tag string) (<-chan ContainerStats, error) {
+	args := []string{"-H", d.socket, "stats", "--format", statsFormat, tag}
 	log.Printf("docker %v", args)
 
-	cmd := exec.Command(d.dockerExec, args...)
-	output, err := cmd.CombinedOutput()
+	cmd := exec.CommandContext(ctx, d.dockerExec, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan ContainerStats)
+	go func() {
+		defer close(ch)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			stats, err := parseStats(scanner.Text())
+			if err != nil {
+				log.Printf("Ignoring docker stats output: %v", err)
+				continue
+			}
+			select {
+			case ch <- stats:
+			case <-ctx.Done():
+			}
+		}
+		if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+			log.Printf("docker stats for %v exited: %v", tag, err)
+		}
+	}()
+
+	return ch, nil
+}
+
+func (d *dockerWrapper) Events(ctx context.Context) (<-chan Event, error) {
+	args := []string{"-H", d.socket, "events", "--format", "{{json .}}"}
+	log.Printf("docker %v", args)
 
+	cmd := exec.CommandContext(ctx, d.dockerExec, args...)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			event, err := parseEvent(scanner.Bytes())
+			if err != nil {
+				log.Printf("Ignoring docker events output: %v", err)
+				continue
+			}
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+			}
+		}
+		if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+			log.Printf("docker events exited: %v", err)
+		}
+	}()
+
+	return ch, nil
+}
+
+// parseEvent parses a line of "docker events --format '{{json .}}'"
+// output.
+func parseEvent(line []byte) (Event, error) {
+	var raw struct {
+		Type   string
+		Action string
+		Actor  struct {
+			ID         string
+			Attributes map[string]string
+		}
+		TimeNano int64 `json:"timeNano"`
+	}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return Event{}, fmt.Errorf("invalid event %q: %v", line, err)
+	}
+	if raw.Type == "" || raw.Action == "" {
+		return Event{}, fmt.Errorf("invalid event %q: no type or action", line)
+	}
+	return Event{
+		Type:       raw.Type,
+		Action:     raw.Action,
+		ID:         raw.Actor.ID,
+		Time:       time.Unix(0, raw.TimeNano),
+		Attributes: raw.Actor.Attributes,
+	}, nil
+}
+
+// ansiEscapeRegexp matches the terminal control sequences that "docker
+// stats" uses to redraw its output when streaming.
+var ansiEscapeRegexp = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// parseStats parses a line of "docker stats --format statsFormat"
+// output, e.g. "0.15%\t5.2MiB / 1.952GiB\t1.2kB / 648B".
+func parseStats(line string) (ContainerStats, error) {
+	line = strings.TrimSpace(ansiEscapeRegexp.ReplaceAllString(line, ""))
+	fields := strings.Split(line, "\t")
+	if len(fields) != 3 {
+		return ContainerStats{}, fmt.Errorf("invalid stats line %q", line)
+	}
+
+	var stats ContainerStats
+	var err error
+
+	cpu := strings.TrimSpace(fields[0])
+	if cpu != "--" {
+		if stats.CPUPercent, err = strconv.ParseFloat(strings.TrimSuffix(cpu, "%"), 64); err != nil {
+			return ContainerStats{}, fmt.Errorf("invalid CPU usage %q: %v", cpu, err)
+		}
+	}
+
+	// Memory usage is "<usage> / <limit>".
+	memory := strings.SplitN(fields[1], "/", 2)
+	if stats.MemoryBytes, err = parseSize(memory[0]); err != nil {
+		return ContainerStats{}, fmt.Errorf("invalid memory usage %q: %v", fields[1], err)
+	}
+
+	// Network I/O is "<input> / <output>".
+	netIO := strings.SplitN(fields[2], "/", 2)
+	if len(netIO) != 2 {
+		return ContainerStats{}, fmt.Errorf("invalid network I/O %q", fields[2])
+	}
+	if stats.NetInputBytes, err = parseSize(netIO[0]); err != nil {
+		return ContainerStats{}, fmt.Errorf("invalid network I/O %q: %v", fields[2], err)
+	}
+	if stats.NetOutputBytes, err = parseSize(netIO[1]); err != nil {
+		return ContainerStats{}, fmt.Errorf("invalid network I/O %q: %v", fields[2], err)
+	}
+
+	return stats, nil
+}
+
+// sizeUnits used by docker when formatting sizes, longest suffix first.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a size such as "5.2MiB" or "648B" into bytes.
+func parseSize(size string) (uint64, error) {
+	size = strings.TrimSpace(size)
+	if size == "--" {
+		return 0, nil
+	}
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(size, unit.suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(size, unit.suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return uint64(value * unit.multiplier), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown unit in %q", size)
+}
+
+func (d *dockerWrapper) runCommand(args []string) {
+	if output, err := d.execute(args); err != nil {
 		util.LogWithPrefix("docker", string(output))
 		log.Fatal(err)
 	}
 }
 
+// execute runs docker with the given args, returning the combined output.
+func (d *dockerWrapper) execute(args []string) ([]byte, error) {
+	log.Printf("docker %v", args)
+
+	defer d.acquire()()
+	cmd := exec.Command(d.dockerExec, args...)
+	return cmd.CombinedOutput()
+}
+
+// acquire waits for a free command slot if the number of concurrent
+// commands is limited. Call the returned function to release the slot.
+func (d *dockerWrapper) acquire() func() {
+	release, _ := d.acquireContext(context.Background())
+	return release
+}
+
+// acquireContext is like acquire, but gives up waiting with ctx.Err()
+// once ctx is done.
+func (d *dockerWrapper) acquireContext(ctx context.Context) (func(), error) {
+	if d.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case d.slots <- struct{}{}:
+		return func() { <-d.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (d *dockerWrapper) ensureBridge() {
 	if exec.Command("ip", "link", "show", d.bridge).Run() == nil {
 		log.Printf("Bridge device %v exists", d.bridge)
@@ -0,0 +1,412 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStats(t *testing.T) {
+	for _, testCase := range []struct {
+		line     string
+		expected ContainerStats
+		hasError bool
+	}{
+		{
+			line: "0.15%\t5.5MiB / 1.952GiB\t1.2kB / 648B\n",
+			expected: ContainerStats{
+				CPUPercent:     0.15,
+				MemoryBytes:    5.5 * (1 << 20),
+				NetInputBytes:  1200,
+				NetOutputBytes: 648,
+			},
+		},
+		{
+			line: "125.50%\t1GiB / 2GiB\t3.5MB / 1.1GB",
+			expected: ContainerStats{
+				CPUPercent:     125.5,
+				MemoryBytes:    1 << 30,
+				NetInputBytes:  3500000,
+				NetOutputBytes: 1100000000,
+			},
+		},
+		{
+			// Streaming output is prefixed with terminal control sequences.
+			line: "\x1b[2J\x1b[H0.00%\t0B / 0B\t0B / 0B",
+		},
+		{
+			// Stats of a stopped container.
+			line: "--\t-- / --\t-- / --",
+		},
+		{line: "", hasError: true},
+		{line: "0.15%\t5.2MiB / 1.952GiB", hasError: true},
+		{line: "abc%\t5.2MiB / 1.952GiB\t1.2kB / 648B", hasError: true},
+		{line: "0.15%\t5.2XB / 1.952GiB\t1.2kB / 648B", hasError: true},
+		{line: "0.15%\t5.2MiB / 1.952GiB\t1.2kB", hasError: true},
+	} {
+		stats, err := parseStats(testCase.line)
+		if testCase.hasError {
+			assert.Error(t, err, "%q", testCase.line)
+			continue
+		}
+		assert.NoError(t, err, "%q", testCase.line)
+		assert.Equal(t, testCase.expected, stats, "%q", testCase.line)
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	for _, testCase := range []struct {
+		line     string
+		expected Event
+		hasError bool
+	}{
+		{
+			line: `{"status":"oom","id":"0123abcd","from":"kube-dns","Type":"container",` +
+				`"Action":"oom","Actor":{"ID":"0123abcd","Attributes":{"image":"kube-dns",` +
+				`"name":"kubedns"}},"scope":"local","time":1500000000,"timeNano":1500000000123456789}`,
+			expected: Event{
+				Type:       "container",
+				Action:     "oom",
+				ID:         "0123abcd",
+				Time:       time.Unix(1500000000, 123456789),
+				Attributes: map[string]string{"image": "kube-dns", "name": "kubedns"},
+			},
+		},
+		{
+			line: `{"status":"die","id":"0123abcd","from":"kube-dns","Type":"container",` +
+				`"Action":"die","Actor":{"ID":"0123abcd","Attributes":{"exitCode":"137"}},` +
+				`"time":1500000001,"timeNano":1500000001000000000}`,
+			expected: Event{
+				Type:       "container",
+				Action:     "die",
+				ID:         "0123abcd",
+				Time:       time.Unix(1500000001, 0),
+				Attributes: map[string]string{"exitCode": "137"},
+			},
+		},
+		{
+			line: `{"Type":"network","Action":"connect","Actor":{"ID":"net1"},"timeNano":1500000002000000000}`,
+			expected: Event{
+				Type:   "network",
+				Action: "connect",
+				ID:     "net1",
+				Time:   time.Unix(1500000002, 0),
+			},
+		},
+		{line: "", hasError: true},
+		{line: "not json", hasError: true},
+		{line: `{"status":"die"}`, hasError: true},
+	} {
+		event, err := parseEvent([]byte(testCase.line))
+		if testCase.hasError {
+			assert.Error(t, err, "%q", testCase.line)
+			continue
+		}
+		assert.NoError(t, err, "%q", testCase.line)
+		assert.Equal(t, testCase.expected, event, "%q", testCase.line)
+	}
+}
+
+func TestMountArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	resolvConf := filepath.Join(dir, "resolv.conf")
+	require.NoError(t, ioutil.WriteFile(resolvConf, []byte("nameserver 8.8.8.8\n"), 0644))
+
+	args, err := mountArgs([]Mount{
+		{Source: dir, Target: "/kube-dns-config"},
+		{Source: resolvConf, Target: "/etc/resolv.conf", ReadOnly: true},
+		{Volume: "cache", Target: "/cache"},
+		{Volume: "seed", Target: "/seed", ReadOnly: true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"-v", dir + ":/kube-dns-config",
+		"-v", resolvConf + ":/etc/resolv.conf:ro",
+		"-v", "cache:/cache",
+		"-v", "seed:/seed:ro",
+	}, args)
+
+	for _, mount := range []Mount{
+		{Source: filepath.Join(dir, "missing"), Target: "/kube-dns-config"},
+		{Source: dir, Target: "kube-dns-config"},
+		{Source: dir, Target: "/kube-dns:config"},
+		{Volume: "cache", Source: dir, Target: "/cache"},
+		{Volume: "cache/x", Target: "/cache"},
+		{Volume: "cache", Target: "cache"},
+	} {
+		_, err := mountArgs([]Mount{mount})
+		assert.Error(t, err, "%+v", mount)
+	}
+}
+
+// writeScript writes an executable shell script to dir/name.
+func writeScript(t *testing.T, dir, name, script string) string {
+	exec := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(exec, []byte("#!/bin/sh\n"+script), 0755))
+	return exec
+}
+
+func TestContainerCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The fake docker records its arguments in dir/argv.
+	record := "echo \"$@\" >> " + dir + "/argv\necho output\n"
+	d := &dockerWrapper{
+		dockerExec: writeScript(t, dir, "docker", record),
+		socket:     "unix:///var/run/docker.sock",
+	}
+	assert.NoError(t, d.Restart("upstream"))
+	assert.NoError(t, d.Pause("upstream"))
+	assert.NoError(t, d.Unpause("upstream"))
+
+	argv, err := ioutil.ReadFile(filepath.Join(dir, "argv"))
+	require.NoError(t, err)
+	assert.Equal(t, "-H unix:///var/run/docker.sock restart upstream\n"+
+		"-H unix:///var/run/docker.sock pause upstream\n"+
+		"-H unix:///var/run/docker.sock unpause upstream\n", string(argv))
+
+	d.dockerExec = writeScript(t, dir, "docker", record+"exit 1\n")
+	for _, command := range []func(string) error{d.Restart, d.Pause, d.Unpause} {
+		err := command("upstream")
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "output")
+		}
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The fake docker records its arguments in dir/argv.
+	record := "echo \"$@\" >> " + dir + "/argv\necho output\n"
+	d := &dockerWrapper{
+		dockerExec: writeScript(t, dir, "docker", record),
+		socket:     "unix:///var/run/docker.sock",
+	}
+	assert.NoError(t, d.VolumeCreate("cache"))
+	assert.NoError(t, d.VolumeCreate("seed"))
+	assert.NoError(t, d.VolumeCreate("state"))
+	assert.NoError(t, d.VolumeRemove("seed"))
+	// Only the volumes that were not removed are cleaned up.
+	assert.NoError(t, d.CleanupVolumes())
+	assert.NoError(t, d.CleanupVolumes())
+
+	argv, err := ioutil.ReadFile(filepath.Join(dir, "argv"))
+	require.NoError(t, err)
+	assert.Equal(t, "-H unix:///var/run/docker.sock volume create cache\n"+
+		"-H unix:///var/run/docker.sock volume create seed\n"+
+		"-H unix:///var/run/docker.sock volume create state\n"+
+		"-H unix:///var/run/docker.sock volume rm seed\n"+
+		"-H unix:///var/run/docker.sock volume rm cache\n"+
+		"-H unix:///var/run/docker.sock volume rm state\n", string(argv))
+
+	// Volumes that fail to be removed stay registered.
+	d.dockerExec = writeScript(t, dir, "docker", record+"exit 1\n")
+	d.volumes = map[string]bool{"cache": true}
+	assert.Error(t, d.CleanupVolumes())
+	assert.Equal(t, map[string]bool{"cache": true}, d.volumes)
+	assert.Error(t, d.VolumeCreate("other"))
+	assert.Equal(t, map[string]bool{"cache": true}, d.volumes)
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The fake docker logs the number of commands running when it
+	// starts.
+	d := NewDocker(WithMaxConcurrency(2)).(*dockerWrapper)
+	d.dockerExec = writeScript(t, dir, "docker", fmt.Sprintf(
+		"touch %[1]v/running.$$\n"+
+			"ls %[1]v | grep -c running >> %[1]v/concurrency\n"+
+			"sleep 0.2\nrm %[1]v/running.$$\n", dir))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := d.RunOutput("busybox")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	output, err := ioutil.ReadFile(filepath.Join(dir, "concurrency"))
+	require.NoError(t, err)
+	counts := strings.Fields(string(output))
+	assert.Len(t, counts, 8)
+	for _, count := range counts {
+		assert.Contains(t, []string{"1", "2"}, count)
+	}
+
+	// A limit of 0 or less means no limit.
+	for _, n := range []int{0, -1} {
+		d := NewDocker(WithMaxConcurrency(n)).(*dockerWrapper)
+		d.dockerExec = writeScript(t, dir, "docker", "exit 0\n")
+		done := make(chan error)
+		go func() {
+			_, err := d.RunOutput("busybox")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.NoError(t, err, "%v", n)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Command blocked with WithMaxConcurrency(%v)", n)
+		}
+	}
+}
+
+func TestTryPull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The fake docker logs the number of pulls running when it starts,
+	// and fails to pull the images named "missing*".
+	var progress []string
+	d := NewDocker(
+		WithPullConcurrency(3),
+		WithPullProgress(func(image string, err error) {
+			progress = append(progress, fmt.Sprintf("%v:%v", image, err == nil))
+		})).(*dockerWrapper)
+	d.dockerExec = writeScript(t, dir, "docker", fmt.Sprintf(
+		"touch %[1]v/running.$$\n"+
+			"ls %[1]v | grep -c running >> %[1]v/concurrency\n"+
+			"sleep 0.2\nrm %[1]v/running.$$\n"+
+			"case $4 in missing*) echo \"not found: $4\"; exit 1;; esac\n", dir))
+
+	images := []string{"a", "missing1", "b", "c", "missing2", "d", "e", "f"}
+	err = d.TryPull(images...)
+	if assert.Error(t, err) {
+		assert.Equal(t, "could not pull images: "+
+			"missing1: exit status 1 (not found: missing1), "+
+			"missing2: exit status 1 (not found: missing2)", err.Error())
+	}
+
+	// Every image is pulled despite the failures.
+	sort.Strings(progress)
+	assert.Equal(t, []string{
+		"a:true", "b:true", "c:true", "d:true", "e:true", "f:true",
+		"missing1:false", "missing2:false",
+	}, progress)
+
+	output, err := ioutil.ReadFile(filepath.Join(dir, "concurrency"))
+	require.NoError(t, err)
+	counts := strings.Fields(string(output))
+	assert.Len(t, counts, len(images))
+	for _, count := range counts {
+		assert.Contains(t, []string{"1", "2", "3"}, count)
+	}
+	assert.Contains(t, counts, "3")
+
+	assert.NoError(t, d.TryPull("a", "b"))
+}
+
+func TestTryList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := &dockerWrapper{
+		dockerExec: writeScript(t, dir, "docker", "echo \"$@\" >> "+dir+"/argv\necho 0123\necho 4567\n"),
+		socket:     "unix:///var/run/docker.sock",
+	}
+	tags, err := d.TryList(context.Background(), "name=kube-dns")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0123", "4567"}, tags)
+	argv, err := ioutil.ReadFile(filepath.Join(dir, "argv"))
+	require.NoError(t, err)
+	assert.Equal(t, "-H unix:///var/run/docker.sock ps -q --filter name=kube-dns\n", string(argv))
+
+	// A hung daemon times out.
+	d.dockerExec = writeScript(t, dir, "docker", "exec sleep 10\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = d.TryList(ctx, "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "deadline exceeded")
+	}
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// So does waiting for a command slot held by a hung command.
+	d = NewDocker(WithMaxConcurrency(1)).(*dockerWrapper)
+	d.dockerExec = writeScript(t, dir, "docker", "exec sleep 2\n")
+	go d.RunOutput("busybox")
+	// Let the hung command take the slot.
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = d.TryList(ctx, "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "deadline exceeded")
+	}
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestTryStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The daemon is not stopped if it is not managed.
+	d := &dockerWrapper{}
+	assert.NoError(t, d.TryStop(context.Background()))
+
+	d = &dockerWrapper{
+		manageDaemon: true,
+		sudo:         writeScript(t, dir, "sudo", "exec \"$@\"\n"),
+	}
+	d.cmd = exec.Command("sleep", "10")
+	require.NoError(t, d.cmd.Start())
+	assert.NoError(t, d.TryStop(context.Background()))
+
+	// A daemon that does not exit times out.
+	d.cmd = exec.Command("sh", "-c", "trap '' TERM; sleep 10")
+	require.NoError(t, d.cmd.Start())
+	defer d.cmd.Process.Kill()
+	// Let the shell set up the trap.
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = d.TryStop(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "did not exit")
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// GoldenQuery is a query, e.g. from a capture, and its expected response.
+type GoldenQuery struct {
+	Name  string
+	Qtype uint16
+	// Rcode expected in the response.
+	Rcode int
+	// Answer expected in the response, as records in zone file format,
+	// e.g. "mysvc.default.svc.cluster.local. 30 IN A 10.0.0.10". The
+	// order of the records and their TTLs are ignored.
+	Answer []string
+}
+
+// Mismatch is a response to a GoldenQuery that differs from the golden
+// one.
+type Mismatch struct {
+	Query GoldenQuery
+	// Rcode and Answer of the response, normalized as in
+	// normalizeAnswer(). They are not set if Err is.
+	Rcode  int
+	Answer []string
+	// Err is set if the query or the golden answer failed.
+	Err error
+}
+
+func (m Mismatch) String() string {
+	q := fmt.Sprintf("%v %v", m.Query.Name, dns.TypeToString[m.Query.Qtype])
+	if m.Err != nil {
+		return fmt.Sprintf("%v: %v", q, m.Err)
+	}
+	return fmt.Sprintf("%v: got %v %v, want %v %v", q,
+		dns.RcodeToString[m.Rcode], m.Answer,
+		dns.RcodeToString[m.Query.Rcode], m.Query.Answer)
+}
+
+// Replay sends queries over UDP to the DNS server at target (host:port)
+// and returns the responses that differ from the golden ones in rcode or
+// answer set.
+func Replay(target string, queries []GoldenQuery) []Mismatch {
+	r := NewResolver(target)
+	var mismatches []Mismatch
+	for _, q := range queries {
+		want, err := parseAnswer(q.Answer)
+		if err != nil {
+			mismatches = append(mismatches, Mismatch{Query: q, Err: err})
+			continue
+		}
+		req := new(dns.Msg)
+		req.SetQuestion(dns.Fqdn(q.Name), q.Qtype)
+		resp, err := r.Raw(req)
+		if err != nil {
+			mismatches = append(mismatches, Mismatch{Query: q, Err: err})
+			continue
+		}
+		got := normalizeAnswer(resp.Answer)
+		if resp.Rcode != q.Rcode || !reflect.DeepEqual(got, want) {
+			mismatches = append(mismatches, Mismatch{Query: q, Rcode: resp.Rcode, Answer: got})
+		}
+	}
+	return mismatches
+}
+
+// parseAnswer parses golden records and returns them normalized.
+func parseAnswer(records []string) ([]string, error) {
+	var answer []dns.RR
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid golden record %q: %v", s, err)
+		}
+		answer = append(answer, rr)
+	}
+	return normalizeAnswer(answer), nil
+}
+
+// normalizeAnswer returns the records of answer in zone file format with
+// TTL 0, sorted. It returns nil if answer is empty.
+func normalizeAnswer(answer []dns.RR) []string {
+	var records []string
+	for _, rr := range answer {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		records = append(records, rr.String())
+	}
+	sort.Strings(records)
+	return records
+}
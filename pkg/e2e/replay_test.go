@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := startTestServer(ctx, t)
+
+	golden := []GoldenQuery{
+		{
+			Name:   "mysvc.default.svc.cluster.local.",
+			Qtype:  dns.TypeA,
+			Answer: []string{"mysvc.default.svc.cluster.local. 30 IN A 10.0.0.10"},
+		},
+		{
+			Name:  "_http._tcp.mysvc.default.svc.cluster.local",
+			Qtype: dns.TypeSRV,
+			Answer: []string{
+				"_http._tcp.mysvc.default.svc.cluster.local. 30 IN SRV 10 100 80 mysvc.default.svc.cluster.local.",
+			},
+		},
+		{
+			Name:  "missing.default.svc.cluster.local.",
+			Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	}
+	assert.Empty(t, Replay(addrs.UDP.String(), golden))
+
+	// A changed address, a wrong rcode and an invalid golden record are
+	// reported.
+	golden[0].Answer = []string{"mysvc.default.svc.cluster.local. 30 IN A 10.0.0.11"}
+	golden[1].Answer = []string{"not a record"}
+	golden[2].Rcode = dns.RcodeSuccess
+	mismatches := Replay(addrs.UDP.String(), golden)
+	require.Len(t, mismatches, 3)
+
+	assert.Equal(t, golden[0], mismatches[0].Query)
+	assert.NoError(t, mismatches[0].Err)
+	assert.Equal(t, dns.RcodeSuccess, mismatches[0].Rcode)
+	assert.Equal(t, []string{"mysvc.default.svc.cluster.local.\t0\tIN\tA\t10.0.0.10"}, mismatches[0].Answer)
+
+	assert.Error(t, mismatches[1].Err)
+
+	assert.NoError(t, mismatches[2].Err)
+	assert.Equal(t, dns.RcodeNameError, mismatches[2].Rcode)
+	assert.Empty(t, mismatches[2].Answer)
+	assert.Contains(t, mismatches[2].String(), "got NXDOMAIN")
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver sends queries to a DNS server, e.g. kube-dns under test.
+type Resolver struct {
+	// Addr (host:port) of the server.
+	Addr string
+	// Net is "udp" or "tcp".
+	Net string
+	// Timeout of each query.
+	Timeout time.Duration
+	// Results, if set, records the queries.
+	Results *Results
+}
+
+// NewResolver returns a Resolver querying addr over UDP.
+func NewResolver(addr string) *Resolver {
+	return &Resolver{Addr: addr, Net: "udp", Timeout: 5 * time.Second}
+}
+
+// RcodeError is returned when a query does not succeed.
+type RcodeError struct {
+	Name  string
+	Rcode int
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("query for %v returned %v", e.Name, dns.RcodeToString[e.Rcode])
+}
+
+// Raw sends msg and returns the response, whatever its rcode.
+func (r *Resolver) Raw(msg *dns.Msg) (*dns.Msg, error) {
+	if r.Results == nil {
+		return r.exchange(msg)
+	}
+	start := r.Results.now()
+	resp, err := r.exchange(msg)
+	var name, result string
+	if len(msg.Question) > 0 {
+		name = msg.Question[0].Name + " " + dns.TypeToString[msg.Question[0].Qtype]
+	}
+	if resp != nil {
+		result = fmt.Sprintf("%v, %d answers", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+	r.Results.record(StepQuery, name, start, result, err)
+	return resp, err
+}
+
+// exchange sends msg and returns the response.
+func (r *Resolver) exchange(msg *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Net: r.Net, Timeout: r.Timeout}
+	resp, _, err := client.Exchange(msg, r.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %v over %v: %v", r.Addr, r.Net, err)
+	}
+	return resp, nil
+}
+
+// A returns the addresses of name.
+func (r *Resolver) A(name string) ([]net.IP, error) {
+	answer, err := r.query(name, dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, rr := range answer {
+		if a, ok := rr.(*dns.A); ok {
+			ips = append(ips, a.A)
+		}
+	}
+	return ips, nil
+}
+
+// SRV returns the SRV records of name.
+func (r *Resolver) SRV(name string) ([]*dns.SRV, error) {
+	answer, err := r.query(name, dns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	var srvs []*dns.SRV
+	for _, rr := range answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			srvs = append(srvs, srv)
+		}
+	}
+	return srvs, nil
+}
+
+// query returns the answer section of the response to the qtype query
+// for name. It returns an *RcodeError if the query does not succeed.
+func (r *Resolver) query(name string, qtype uint16) ([]dns.RR, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	resp, err := r.Raw(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, &RcodeError{Name: name, Rcode: resp.Rcode}
+	}
+	return resp.Answer, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	kdns "k8s.io/dns/pkg/dns"
+	"k8s.io/dns/pkg/dns/config"
+	"k8s.io/dns/pkg/dns/server"
+)
+
+// startTestServer runs kube-dns in-process with the default/mysvc
+// service until ctx is done.
+func startTestServer(ctx context.Context, t *testing.T) server.Addrs {
+	kubeClient := fake.NewSimpleClientset(&v1.Service{
+		ObjectMeta: v1.ObjectMeta{Name: "mysvc", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.10",
+			Ports:     []v1.ServicePort{{Name: "http", Port: 80, Protocol: "TCP"}},
+		},
+	})
+	kd := kdns.NewKubeDNS(kubeClient, "cluster.local.", 10*time.Second,
+		config.NewNopSync(config.NewDefaultConfig()))
+	addrs, err := server.New(server.ServerOptions{
+		Options: server.Options{
+			Domain:      "cluster.local.",
+			Backend:     kd,
+			Nameservers: []string{"127.0.0.1:1"},
+		},
+		Addr: "127.0.0.1:0",
+	}).Run(ctx)
+	require.NoError(t, err)
+	return addrs
+}
+
+func TestResolver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := startTestServer(ctx, t)
+
+	for proto, addr := range map[string]string{
+		"udp": addrs.UDP.String(),
+		"tcp": addrs.TCP.String(),
+	} {
+		r := NewResolver(addr)
+		r.Net = proto
+
+		ips, err := r.A("mysvc.default.svc.cluster.local")
+		require.NoError(t, err, proto)
+		require.Len(t, ips, 1, proto)
+		assert.Equal(t, "10.0.0.10", ips[0].String(), proto)
+
+		srvs, err := r.SRV("_http._tcp.mysvc.default.svc.cluster.local.")
+		require.NoError(t, err, proto)
+		require.Len(t, srvs, 1, proto)
+		assert.Equal(t, uint16(80), srvs[0].Port, proto)
+		assert.Equal(t, "mysvc.default.svc.cluster.local.", srvs[0].Target, proto)
+
+		_, err = r.A("missing.default.svc.cluster.local.")
+		if assert.IsType(t, &RcodeError{}, err, proto) {
+			assert.Equal(t, dns.RcodeNameError, err.(*RcodeError).Rcode, proto)
+		}
+
+		req := new(dns.Msg)
+		req.SetQuestion("missing.default.svc.cluster.local.", dns.TypeA)
+		resp, err := r.Raw(req)
+		require.NoError(t, err, proto)
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode, proto)
+	}
+
+	// Nothing is listening after the server is shut down.
+	cancel()
+	r := NewResolver(addrs.TCP.String())
+	r.Net = "tcp"
+	r.Timeout = time.Second
+	for i := 0; ; i++ {
+		if _, err := r.A("mysvc.default.svc.cluster.local."); err != nil {
+			_, isRcodeError := err.(*RcodeError)
+			assert.False(t, isRcodeError, "%v", err)
+			break
+		}
+		if i == 50 {
+			t.Fatalf("server still answering after shutdown")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
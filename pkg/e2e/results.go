@@ -0,0 +1,168 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Step kinds recorded by Results.
+const (
+	StepPull  = "pull"
+	StepRun   = "run"
+	StepQuery = "query"
+)
+
+// Step is a recorded step of an e2e run.
+type Step struct {
+	// Kind of the step, e.g. StepPull.
+	Kind string `json:"kind"`
+	// Name of what the step acted on, e.g. the image pulled.
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	// DurationSeconds is the time the step took.
+	DurationSeconds float64 `json:"durationSeconds"`
+	// Result of the step, e.g. the container ID or the query rcode.
+	Result string `json:"result,omitempty"`
+	// Error is set if the step failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Summary is the JSON summary of an e2e run.
+type Summary struct {
+	Steps []Step `json:"steps"`
+	// Failed is the number of steps that failed.
+	Failed int `json:"failed"`
+}
+
+// Results collects the steps of an e2e run, for machine-parseable
+// output. Use Docker() and Resolver.Results to record the steps taken
+// through them.
+type Results struct {
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+
+	// lock protects steps.
+	lock  sync.Mutex
+	steps []Step
+}
+
+// NewResults returns an empty Results.
+func NewResults() *Results {
+	return &Results{now: time.Now}
+}
+
+// Record adds step to the results.
+func (r *Results) Record(step Step) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.steps = append(r.steps, step)
+}
+
+// record adds a step of kind that started at start, with err if it
+// failed.
+func (r *Results) record(kind, name string, start time.Time, result string, err error) {
+	step := Step{
+		Kind:            kind,
+		Name:            name,
+		Start:           start,
+		DurationSeconds: r.now().Sub(start).Seconds(),
+		Result:          result,
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	r.Record(step)
+}
+
+// Summary returns the steps recorded so far.
+func (r *Results) Summary() Summary {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	summary := Summary{Steps: append([]Step{}, r.steps...)}
+	for _, step := range r.steps {
+		if step.Error != "" {
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// WriteJSON writes the Summary to w.
+func (r *Results) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r.Summary())
+}
+
+// Docker returns d recording its image pulls and container runs in r.
+func (r *Results) Docker(d Docker) Docker {
+	return &recordingDocker{Docker: d, results: r}
+}
+
+// recordingDocker records the steps taken through a Docker.
+type recordingDocker struct {
+	Docker
+	results *Results
+}
+
+func (d *recordingDocker) Pull(images ...string) {
+	for _, image := range images {
+		start := d.results.now()
+		d.Docker.Pull(image)
+		d.results.record(StepPull, image, start, "", nil)
+	}
+}
+
+func (d *recordingDocker) TryPull(images ...string) error {
+	start := d.results.now()
+	err := d.Docker.TryPull(images...)
+	d.results.record(StepPull, strings.Join(images, " "), start, "", err)
+	return err
+}
+
+func (d *recordingDocker) EnsureImages(images ...string) error {
+	start := d.results.now()
+	err := d.Docker.EnsureImages(images...)
+	d.results.record(StepPull, strings.Join(images, " "), start, "", err)
+	return err
+}
+
+func (d *recordingDocker) Run(args ...string) string {
+	start := d.results.now()
+	id := d.Docker.Run(args...)
+	d.results.record(StepRun, strings.Join(args, " "), start, id, nil)
+	return id
+}
+
+func (d *recordingDocker) RunOutput(args ...string) (string, error) {
+	start := d.results.now()
+	output, err := d.Docker.RunOutput(args...)
+	d.results.record(StepRun, strings.Join(args, " "), start, strings.TrimSpace(output), err)
+	return output, err
+}
+
+func (d *recordingDocker) RunWithMounts(mounts []Mount, args ...string) (string, error) {
+	start := d.results.now()
+	output, err := d.Docker.RunWithMounts(mounts, args...)
+	d.results.record(StepRun, strings.Join(args, " "), start, strings.TrimSpace(output), err)
+	return output, err
+}
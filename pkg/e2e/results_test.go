@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := startTestServer(ctx, t)
+
+	results := NewResults()
+	// Each step takes a second.
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	results.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	docker := results.Docker(&fakeDocker{})
+	docker.Pull("kube-dns:test", "busybox")
+	id, err := docker.RunOutput("-d", "kube-dns:test")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789ab", id)
+	_, err = docker.RunOutput("--rm", "busybox", "dig", "mysvc")
+	assert.Error(t, err)
+	// Methods that are not recorded are passed through.
+	assert.Equal(t, "", docker.Run())
+	docker.Kill("kube-dns")
+
+	r := NewResolver(addrs.UDP.String())
+	r.Results = results
+	_, err = r.A("mysvc.default.svc.cluster.local.")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, results.WriteJSON(&buf))
+	var summary Summary
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &summary))
+
+	assert.Equal(t, 1, summary.Failed)
+	require.Len(t, summary.Steps, 6)
+	for i, expected := range []Step{
+		{Kind: StepPull, Name: "kube-dns:test"},
+		{Kind: StepPull, Name: "busybox"},
+		{Kind: StepRun, Name: "-d kube-dns:test", Result: "0123456789ab"},
+		{
+			Kind:  StepRun,
+			Name:  "--rm busybox dig mysvc",
+			Error: "connection timed out; no servers could be reached",
+		},
+		{Kind: StepRun},
+		{Kind: StepQuery, Name: "mysvc.default.svc.cluster.local. A", Result: "NOERROR, 1 answers"},
+	} {
+		step := summary.Steps[i]
+		assert.Equal(t, 1.0, step.DurationSeconds, "step %d", i)
+		assert.False(t, step.Start.IsZero(), "step %d", i)
+		step.Start = time.Time{}
+		step.DurationSeconds = 0
+		assert.Equal(t, expected, step, "step %d", i)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LogFormatText is the default glog text output.
+	LogFormatText = "text"
+	// LogFormatJSON emits one JSON object per log line.
+	LogFormatJSON = "json"
+)
+
+// glogLineRegexp matches the glog header
+// "Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg".
+var glogLineRegexp = regexp.MustCompile(
+	`^([IWEF])(\d{4} \d{2}:\d{2}:\d{2}\.\d{6})\s+\d+ ([^\]]+)\] (.*)$`)
+
+var glogLevels = map[string]string{
+	"I": "info",
+	"W": "warning",
+	"E": "error",
+	"F": "fatal",
+}
+
+// jsonLogLine is the structure of a log line in LogFormatJSON.
+type jsonLogLine struct {
+	Level     string `json:"level"`
+	Timestamp string `json:"timestamp"`
+	Caller    string `json:"caller,omitempty"`
+	Message   string `json:"message"`
+}
+
+// ValidateLogFormat returns an error if format is not a known log format.
+func ValidateLogFormat(format string) error {
+	switch format {
+	case LogFormatText, LogFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q, must be one of %q or %q",
+		format, LogFormatText, LogFormatJSON)
+}
+
+// InitLogFormat configures the format of the process logs. glog (and
+// the standard logger, which is redirected to glog) always writes text to
+// os.Stderr, so for LogFormatJSON os.Stderr is replaced with a pipe whose
+// contents are converted to JSON before being written to the original
+// stderr.
+func InitLogFormat(format string) error {
+	if err := ValidateLogFormat(format); err != nil {
+		return err
+	}
+	if format == LogFormatText {
+		return nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	out := os.Stderr
+	os.Stderr = w
+	go func() {
+		io.Copy(NewJSONLogWriter(out), r)
+	}()
+	return nil
+}
+
+// NewJSONLogWriter returns a writer that converts glog formatted lines
+// into JSON objects written to out, one per line.
+func NewJSONLogWriter(out io.Writer) io.Writer {
+	return &jsonLogWriter{out: out}
+}
+
+type jsonLogWriter struct {
+	lock sync.Mutex
+	out  io.Writer
+	buf  bytes.Buffer
+}
+
+func (w *jsonLogWriter) Write(data []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf.Write(data)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Incomplete line, keep it for the next Write.
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			return len(data), nil
+		}
+		if _, err := w.out.Write(toJSONLogLine(strings.TrimSuffix(line, "\n"), time.Now())); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// toJSONLogLine converts a single glog line to JSON. Lines that do not
+// have a glog header (e.g. stack traces) are logged as info messages.
+func toJSONLogLine(line string, now time.Time) []byte {
+	entry := jsonLogLine{
+		Level:     glogLevels["I"],
+		Timestamp: now.Format(time.RFC3339Nano),
+		Message:   line,
+	}
+
+	if match := glogLineRegexp.FindStringSubmatch(line); match != nil {
+		entry.Level = glogLevels[match[1]]
+		// glog does not log the year.
+		if ts, err := time.ParseInLocation(
+			"2006 0102 15:04:05.000000",
+			fmt.Sprintf("%d %s", now.Year(), match[2]),
+			now.Location()); err == nil {
+			entry.Timestamp = ts.Format(time.RFC3339Nano)
+		}
+		entry.Caller = match[3]
+		entry.Message = match[4]
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		buf = []byte(fmt.Sprintf(`{"level":"error","message":%q}`, err.Error()))
+	}
+	return append(buf, '\n')
+}
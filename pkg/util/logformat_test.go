@@ -0,0 +1,79 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONLogWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewJSONLogWriter(out)
+
+	w.Write([]byte("I0102 15:04:05.123456    1234 server.go:42] Starting SkyDNS server\n"))
+	// Partial writes are buffered until the end of the line.
+	w.Write([]byte("W0102 15:04:06.000000    1234 dns.go:10] cut"))
+	w.Write([]byte(" in half\ngoroutine 1 [running]:\n"))
+
+	expected := []map[string]string{
+		{"level": "info", "caller": "server.go:42", "message": "Starting SkyDNS server"},
+		{"level": "warning", "caller": "dns.go:10", "message": "cut in half"},
+		{"level": "info", "message": "goroutine 1 [running]:"},
+	}
+
+	scanner := bufio.NewScanner(out)
+	i := 0
+	for ; scanner.Scan(); i++ {
+		entry := map[string]string{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %d is not valid JSON: %q (%v)", i, scanner.Text(), err)
+		}
+		if i >= len(expected) {
+			t.Fatalf("unexpected line %q", scanner.Text())
+		}
+		if entry["timestamp"] == "" {
+			t.Errorf("line %d has no timestamp: %v", i, entry)
+		}
+		for key, value := range expected[i] {
+			if entry[key] != value {
+				t.Errorf("line %d: expected %v=%q, got %q", i, key, value, entry[key])
+			}
+		}
+	}
+	if i != len(expected) {
+		t.Errorf("expected %d lines, got %d", len(expected), i)
+	}
+}
+
+func TestValidateLogFormat(t *testing.T) {
+	for _, testCase := range []struct {
+		format   string
+		hasError bool
+	}{
+		{LogFormatText, false},
+		{LogFormatJSON, false},
+		{"xml", true},
+		{"", true},
+	} {
+		if err := ValidateLogFormat(testCase.format); (err != nil) != testCase.hasError {
+			t.Errorf("ValidateLogFormat(%q) = %v", testCase.format, err)
+		}
+	}
+}